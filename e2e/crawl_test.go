@@ -0,0 +1,308 @@
+package e2e
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/junwei890/crawler/internal/sitetest"
+	"github.com/junwei890/crawler/src"
+)
+
+// crawls the site from / into memory
+func crawl(t *testing.T, server *sitetest.Server, config src.Config) (*src.MemoryStore, *src.Stats, error) {
+	store := src.NewMemoryStore()
+	stats, err := src.CrawlSite(t.Context(), server.URL+"/", config, store)
+
+	return store, stats, err
+}
+
+// paths of the stored pages, in the order they were crawled
+func stored(server *sitetest.Server, store *src.MemoryStore) []string {
+	paths := []string{}
+	for _, content := range store.Content() {
+		paths = append(paths, strings.TrimPrefix(content.URL, server.URL))
+	}
+
+	return paths
+}
+
+func requested(requests []sitetest.Request) []string {
+	paths := []string{}
+	for _, request := range requests {
+		paths = append(paths, request.URI)
+	}
+
+	return paths
+}
+
+func TestTopology(t *testing.T) {
+	testCases := []struct {
+		name     string
+		pages    map[string]sitetest.Page
+		strategy string
+		expected []string
+	}{
+		{
+			name:     "Topology: test case 1",
+			pages:    sitetest.Chain(3),
+			strategy: src.StrategyBFS,
+			expected: []string{"/", "/1", "/2", "/3"},
+		},
+		{
+			name:     "Topology: test case 2",
+			pages:    sitetest.Tree(2, 2),
+			strategy: src.StrategyBFS,
+			expected: []string{"/", "/0", "/1", "/0/0", "/0/1", "/1/0", "/1/1"},
+		},
+		{
+			name:     "Topology: test case 3",
+			pages:    sitetest.Tree(2, 2),
+			strategy: src.StrategyDFS,
+			expected: []string{"/", "/1", "/1/1", "/1/0", "/0", "/0/1", "/0/0"},
+		},
+		{
+			name:     "Topology: test case 4",
+			pages:    sitetest.Complete(3),
+			strategy: src.StrategyBFS,
+			expected: []string{"/", "/1", "/2", "/3"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		server := sitetest.Serve(t, sitetest.Site{Pages: testCase.pages})
+
+		config := src.DefaultConfig()
+		config.Frontier.Strategy = testCase.strategy
+
+		store, stats, err := crawl(t, server, config)
+		if err != nil {
+			t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+			continue
+		}
+
+		if result := stored(server, store); !reflect.DeepEqual(result, testCase.expected) {
+			t.Errorf("%s failed, %v != %v", testCase.name, result, testCase.expected)
+		}
+
+		// every page is requested once, however many pages link to it
+		if result := requested(server.PageRequests()); !reflect.DeepEqual(result, testCase.expected) || stats.Fetched != len(testCase.expected) {
+			t.Errorf("%s failed, requested %v", testCase.name, result)
+		}
+	}
+}
+
+func TestRobots(t *testing.T) {
+	pages := map[string]sitetest.Page{
+		"/":               {Links: []string{"/a", "/private/b", "/private/open/c"}},
+		"/a":              {},
+		"/private/b":      {},
+		"/private/open/c": {},
+	}
+
+	testCases := []struct {
+		name     string
+		robots   string
+		status   int
+		expected []string
+		valid    bool
+	}{
+		{
+			name:     "Robots: test case 1",
+			expected: []string{"/", "/a", "/private/b", "/private/open/c"},
+			valid:    true,
+		},
+		{
+			name:     "Robots: test case 2",
+			robots:   "User-agent: *\nDisallow: /private\n",
+			expected: []string{"/", "/a"},
+			valid:    true,
+		},
+		{
+			name:     "Robots: test case 3",
+			robots:   "User-agent: *\nDisallow: /private\nAllow: /private/open\n",
+			expected: []string{"/", "/a", "/private/open/c"},
+			valid:    true,
+		},
+		{
+			name:     "Robots: test case 4",
+			robots:   "User-agent: *\nDisallow: /\n",
+			expected: []string{},
+			valid:    true,
+		},
+		{
+			name:     "Robots: test case 5",
+			status:   403,
+			expected: []string{},
+			valid:    false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		server := sitetest.Serve(t, sitetest.Site{Pages: pages, Robots: testCase.robots, RobotsStatus: testCase.status})
+
+		store, _, err := crawl(t, server, src.DefaultConfig())
+		if (err == nil) != testCase.valid {
+			t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+		}
+
+		if result := stored(server, store); !reflect.DeepEqual(result, testCase.expected) {
+			t.Errorf("%s failed, %v != %v", testCase.name, result, testCase.expected)
+		}
+
+		// disallowed pages are never requested, not just left unstored
+		if result := requested(server.PageRequests()); !reflect.DeepEqual(result, testCase.expected) {
+			t.Errorf("%s failed, requested %v", testCase.name, result)
+		}
+	}
+}
+
+func TestCrawlDelay(t *testing.T) {
+	server := sitetest.Serve(t, sitetest.Site{
+		Robots: "User-agent: *\nCrawl-delay: 1\n",
+		Pages: map[string]sitetest.Page{
+			"/":      {Links: []string{"/missing", "/short", "/a"}},
+			"/short": {Text: "too short"},
+			"/a":     {},
+		},
+	})
+
+	store, _, err := crawl(t, server, src.DefaultConfig())
+	if err != nil {
+		t.Fatalf("CrawlDelay: test case 1 failed, unexpected error: %v", err)
+	}
+
+	if result, expected := stored(server, store), []string{"/", "/a"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("CrawlDelay: test case 2 failed, %v != %v", result, expected)
+	}
+
+	// the delay holds after pages that failed or weren't stored as much as after ones that were
+	requests := server.PageRequests()
+	if len(requests) != 4 {
+		t.Fatalf("CrawlDelay: test case 3 failed, %d != %d requests", len(requests), 4)
+	}
+	for i := 1; i < len(requests); i++ {
+		if gap := requests[i].At.Sub(requests[i-1].At); gap < time.Second-50*time.Millisecond {
+			t.Errorf("CrawlDelay: test case %d failed, %s requested %s after %s", i+3, requests[i].Path, gap, requests[i-1].Path)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server := sitetest.Serve(t, sitetest.Site{
+		Pages: map[string]sitetest.Page{
+			"/":       {Links: []string{"/slow", "/broken", "/gone", "/missing"}},
+			"/slow":   {Delay: 200 * time.Millisecond},
+			"/broken": {Status: 500},
+			"/gone":   {Status: 410},
+		},
+	})
+
+	store, stats, err := crawl(t, server, src.DefaultConfig())
+	if err != nil {
+		t.Fatalf("Errors: test case 1 failed, unexpected error: %v", err)
+	}
+
+	if result, expected := stored(server, store), []string{"/", "/slow"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("Errors: test case 2 failed, %v != %v", result, expected)
+	}
+
+	if slow := store.Content()[1]; slow.Fetch.LatencyMS < 200 {
+		t.Errorf("Errors: test case 3 failed, %dms latency for a page 200ms slow", slow.Fetch.LatencyMS)
+	}
+
+	// a 5xx isn't refused like a 4xx, but its body is too short to store
+	expected := map[string]int{"http_404": 1, "http_410": 1}
+	if !reflect.DeepEqual(stats.Errors, expected) || stats.TooShort != 1 || stats.Fetched != 3 {
+		t.Errorf("Errors: test case 4 failed, unexpected stats %+v", stats)
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	server := sitetest.Serve(t, sitetest.Site{
+		Pages: map[string]sitetest.Page{
+			"/":      {Links: []string{"/old", "/chain", "/loop"}},
+			"/old":   {Redirect: "/new"},
+			"/new":   {},
+			"/chain": {Redirect: "/hop"},
+			"/hop":   {Redirect: "/end"},
+			"/end":   {},
+			"/loop":  {Redirect: "/loop"},
+		},
+	})
+
+	store, stats, err := crawl(t, server, src.DefaultConfig())
+	if err != nil {
+		t.Fatalf("Redirects: test case 1 failed, unexpected error: %v", err)
+	}
+
+	// pages are stored under the url that was linked to, with what the redirect led to
+	if result, expected := stored(server, store), []string{"/", "/old", "/chain"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("Redirects: test case 2 failed, %v != %v", result, expected)
+	}
+
+	content := store.Content()
+	if content[1].Title != "/new" || content[2].Title != "/end" {
+		t.Errorf("Redirects: test case 3 failed, %s and %s != /new and /end", content[1].Title, content[2].Title)
+	}
+
+	if stats.Errors[src.ErrClassOther] != 1 {
+		t.Errorf("Redirects: test case 4 failed, unexpected stats %+v", stats)
+	}
+}
+
+func TestMediaTypes(t *testing.T) {
+	server := sitetest.Serve(t, sitetest.Site{
+		Pages: map[string]sitetest.Page{
+			"/":           {Links: []string{"/image.png", "/data.bin", "/notes.txt", "/page.xhtml"}},
+			"/image.png":  {ContentType: "image/png", Body: []byte("\x89PNG\r\n\x1a\n")},
+			"/data.bin":   {ContentType: "application/octet-stream", Body: []byte{0, 1, 2, 3}},
+			"/notes.txt":  {ContentType: "text/plain; charset=utf-8", Body: []byte(sitetest.Text("/notes.txt"))},
+			"/page.xhtml": {ContentType: "application/xhtml+xml"},
+		},
+	})
+
+	store, stats, err := crawl(t, server, src.DefaultConfig())
+	if err != nil {
+		t.Fatalf("MediaTypes: test case 1 failed, unexpected error: %v", err)
+	}
+
+	if result, expected := stored(server, store), []string{"/", "/notes.txt", "/page.xhtml"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("MediaTypes: test case 2 failed, %v != %v", result, expected)
+	}
+
+	if stats.Errors[src.ErrClassMediaType] != 2 {
+		t.Errorf("MediaTypes: test case 3 failed, unexpected stats %+v", stats)
+	}
+}
+
+func TestTraps(t *testing.T) {
+	server := sitetest.Serve(t, sitetest.Site{
+		Pages: map[string]sitetest.Page{
+			"/":     {Links: []string{"/list", "/calendar"}},
+			"/list": {Links: []string{"/list?page=2", "/list?page=3", "/list/"}},
+		},
+		Fallback: sitetest.Trap("/calendar"),
+	})
+
+	config := src.DefaultConfig()
+	config.Frontier.Budget = 10
+
+	store, _, err := crawl(t, server, config)
+	if err != nil {
+		t.Fatalf("Traps: test case 1 failed, unexpected error: %v", err)
+	}
+
+	// the budget is what stops a site with endless pages
+	requests := requested(server.PageRequests())
+	if len(requests) != 10 || len(store.Content()) != 10 {
+		t.Errorf("Traps: test case 2 failed, %d requests and %d stored != 10", len(requests), len(store.Content()))
+	}
+
+	// urls only differing by query or a trailing slash are the same page
+	expected := []string{"/", "/list", "/calendar", "/calendar/next", "/calendar/next/next"}
+	if !reflect.DeepEqual(requests[:5], expected) {
+		t.Errorf("Traps: test case 3 failed, %v != %v", requests[:5], expected)
+	}
+}
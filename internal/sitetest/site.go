@@ -0,0 +1,273 @@
+// serves synthetic sites over httptest for crawler tests, one page builder shared by every package
+package sitetest
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a site described by its pages, served by Serve
+type Site struct {
+	// pages by path, the seed is /
+	Pages map[string]Page
+	// served as text/plain at /robots.txt, a 404 when empty unless RobotsStatus says otherwise
+	Robots       string
+	RobotsStatus int
+	// serves paths that aren't in Pages, for sites with endless generated pages
+	Fallback func(path string) (Page, bool)
+}
+
+type Page struct {
+	// the path when empty
+	Title string
+	// paths or full urls linked to, in order
+	Links []string
+	// generated from the path when empty, long enough to be stored and sharing no words with other pages
+	Text string
+	// 200 when unset, anything else is served as a plain text error unless Body is set
+	Status int
+	// served as a 301 to this path or url
+	Redirect string
+	// slept before responding
+	Delay time.Duration
+	// text/html when unset
+	ContentType string
+	// served as is instead of the generated html
+	Body []byte
+}
+
+// a request the site got
+type Request struct {
+	Path string
+	// the path with its query, if any
+	URI string
+	At  time.Time
+}
+
+type Server struct {
+	*httptest.Server
+	site     Site
+	mu       sync.Mutex
+	requests []Request
+}
+
+// serves the site until the test ends
+func Serve(t testing.TB, site Site) *Server {
+	s := &Server{site: site}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Path: r.URL.Path, URI: r.URL.RequestURI(), At: time.Now()})
+	s.mu.Unlock()
+
+	if r.URL.Path == "/robots.txt" {
+		s.robots(w)
+		return
+	}
+
+	page, ok := s.site.Pages[r.URL.Path]
+	if !ok && s.site.Fallback != nil {
+		page, ok = s.site.Fallback(r.URL.Path)
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	time.Sleep(page.Delay)
+
+	if page.Redirect != "" {
+		http.Redirect(w, r, page.Redirect, http.StatusMovedPermanently)
+		return
+	}
+
+	status := page.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status != http.StatusOK && page.Body == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	contentType := page.ContentType
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+
+	if page.Body != nil {
+		w.Write(page.Body)
+		return
+	}
+	w.Write([]byte(Render(r.URL.Path, page)))
+}
+
+func (s *Server) robots(w http.ResponseWriter) {
+	status := s.site.RobotsStatus
+	if status == 0 && s.site.Robots == "" {
+		status = http.StatusNotFound
+	}
+	if status != 0 && status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(s.site.Robots))
+}
+
+// every request the site has had, in the order they came in
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request{}, s.requests...)
+}
+
+// how many times path has been requested
+func (s *Server) Count(path string) int {
+	count := 0
+	for _, request := range s.Requests() {
+		if request.Path == path {
+			count++
+		}
+	}
+
+	return count
+}
+
+// requests for pages, leaving out robots.txt
+func (s *Server) PageRequests() []Request {
+	pages := []Request{}
+	for _, request := range s.Requests() {
+		if request.Path != "/robots.txt" {
+			pages = append(pages, request)
+		}
+	}
+
+	return pages
+}
+
+// the html a page is served as
+func Render(path string, page Page) string {
+	title := page.Title
+	if title == "" {
+		title = path
+	}
+	text := page.Text
+	if text == "" {
+		text = Text(path)
+	}
+
+	anchors := []string{}
+	for _, link := range page.Links {
+		anchors = append(anchors, fmt.Sprintf(`<a href="%s">%s</a>`, link, link))
+	}
+
+	return fmt.Sprintf(`<html lang="en"><head><title>%s</title></head><body><p>%s</p>%s</body></html>`, title, text, strings.Join(anchors, " "))
+}
+
+// words made from a hash of the path, so no two pages look like near duplicates of each other
+func Text(path string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(path))
+
+	return Words(fmt.Sprintf("w%x", hash.Sum32()))
+}
+
+// prefix0 up to prefix79, enough text for a page to be stored, sharing no words with another prefix
+func Words(prefix string) string {
+	words := []string{}
+	for i := range 80 {
+		words = append(words, fmt.Sprintf("%s%d", prefix, i))
+	}
+
+	return strings.Join(words, " ")
+}
+
+// / links to /1, which links to /2 and so on up to /n
+func Chain(n int) map[string]Page {
+	pages := map[string]Page{}
+	for i := range n + 1 {
+		page := Page{}
+		if i < n {
+			page.Links = []string{fmt.Sprintf("/%d", i+1)}
+		}
+		pages[chainPath(i)] = page
+	}
+
+	return pages
+}
+
+func chainPath(i int) string {
+	if i == 0 {
+		return "/"
+	}
+	return fmt.Sprintf("/%d", i)
+}
+
+// / links to fanout children, each of which links to its own children, down to depth
+func Tree(depth, fanout int) map[string]Page {
+	pages := map[string]Page{}
+
+	var grow func(path string, level int)
+	grow = func(path string, level int) {
+		page := Page{}
+		if level < depth {
+			for i := range fanout {
+				child := fmt.Sprintf("%s/%d", strings.TrimSuffix(path, "/"), i)
+				page.Links = append(page.Links, child)
+				grow(child, level+1)
+			}
+		}
+		pages[path] = page
+	}
+	grow("/", 0)
+
+	return pages
+}
+
+// / and /1 up to /n, every one of them linking to every other
+func Complete(n int) map[string]Page {
+	paths := []string{}
+	for i := range n + 1 {
+		paths = append(paths, chainPath(i))
+	}
+
+	pages := map[string]Page{}
+	for _, path := range paths {
+		page := Page{}
+		for _, link := range paths {
+			if link != path {
+				page.Links = append(page.Links, link)
+			}
+		}
+		pages[path] = page
+	}
+
+	return pages
+}
+
+// every path under prefix exists and links one level deeper, like a calendar or a broken relative link
+func Trap(prefix string) func(path string) (Page, bool) {
+	return func(path string) (Page, bool) {
+		if !strings.HasPrefix(path, prefix) {
+			return Page{}, false
+		}
+
+		return Page{Links: []string{strings.TrimSuffix(path, "/") + "/next"}}, true
+	}
+}
@@ -1,26 +1,140 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
 	"os"
 	"strings"
 
 	"github.com/joho/godotenv"
+	"github.com/junwei890/crawler/search"
 	"github.com/junwei890/crawler/src"
+	"github.com/junwei890/crawler/utils"
 )
 
 func main() {
+	config, err := src.LoadConfig("crawler.json")
+	if err != nil {
+		fatal(err)
+	}
+
+	slog.SetDefault(src.NewLogger(os.Stderr, config.Log))
+
+	// swapped in before anything is fetched, so every request of a crawl is recorded or replayed
+	utils.Client.Transport, err = config.HTTP.Transport()
+	if err != nil {
+		fatal(err)
+	}
+
+	command := "crawl"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	// searching the local index doesn't need a database
+	if command == "search" {
+		if err := searchLocal(config, strings.Join(os.Args[2:], " ")); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// neither does serving it
+	if command == "serve" && config.Search.Backend == src.BackendLocal {
+		if err := src.Serve("", config); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
 	if err := godotenv.Load(); err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	dbURI := os.Getenv("DB_URI")
 
-	linksInBytes, err := os.ReadFile("crawler.txt")
+	switch command {
+	case "crawl":
+		linksInBytes, err := os.ReadFile("crawler.txt")
+		if err != nil {
+			fatal(err)
+		}
+
+		summary, err := src.StartCrawl(dbURI, strings.Fields(string(linksInBytes)), config)
+		summary.Print(os.Stdout)
+
+		// a search index that didn't build gets its own exit code so pipelines can tell
+		if errors.Is(err, src.ErrIndexFailed) || errors.Is(err, src.ErrIndexTimeout) {
+			slog.Error("search index isn't ready", "error", err)
+			os.Exit(2)
+		}
+		if err != nil {
+			fatal(err)
+		}
+	case "worker":
+		// workers joining a crawl someone else seeded don't need a crawler.txt
+		linksInBytes, err := os.ReadFile("crawler.txt")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fatal(err)
+		}
+
+		summary, err := src.Work(dbURI, strings.Fields(string(linksInBytes)), config)
+		summary.Print(os.Stdout)
+
+		if errors.Is(err, src.ErrIndexFailed) || errors.Is(err, src.ErrIndexTimeout) {
+			slog.Error("search index isn't ready", "error", err)
+			os.Exit(2)
+		}
+		if err != nil {
+			fatal(err)
+		}
+	case "export":
+		if len(os.Args) != 4 {
+			fatal(errors.New("usage: crawler export jsonl|parquet <path>"))
+		}
+
+		written, err := src.Export(dbURI, os.Args[2], os.Args[3])
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("exported %d pages to %s\n", written, os.Args[3])
+	case "reprocess":
+		stats, err := src.Reprocess(dbURI, config)
+		slog.Info("reprocessed archive", "stats", stats)
+		if err != nil {
+			fatal(err)
+		}
+	case "serve":
+		if err := src.Serve(dbURI, config); err != nil {
+			fatal(err)
+		}
+	case "rank":
+		if err := src.RankPages(dbURI); err != nil {
+			fatal(err)
+		}
+	default:
+		fatal(fmt.Errorf("unknown command %s", command))
+	}
+}
+
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
+
+func searchLocal(config src.Config, query string) error {
+	index, err := search.Load(config.Search.Path)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	if err := src.StartCrawl(dbURI, strings.Fields(string(linksInBytes))); err != nil {
-		log.Fatal(err)
+	results, total := index.Search(query, 10, 0)
+	fmt.Printf("%d results for %q\n\n", total, query)
+
+	for _, result := range results {
+		fmt.Printf("%.3f  %s\n       %s\n       %s\n\n", result.Score, result.Title, result.URL, result.Snippet)
 	}
+
+	return nil
 }
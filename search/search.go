@@ -0,0 +1,366 @@
+package search
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/gob"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/junwei890/crawler/utils"
+)
+
+// bm25 parameters and how much more a title match counts than a body match
+const (
+	k1          = 1.2
+	b           = 0.75
+	titleBoost  = 2.0
+	snippetSize = 30
+)
+
+type Document struct {
+	URL     string `json:"url"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+type Posting struct {
+	Doc       int
+	Positions []int
+}
+
+type Field struct {
+	Postings map[string][]Posting
+	// token count per document, indexed by document id
+	Lengths []int
+	Total   int
+}
+
+// an inverted index over crawled pages, safe to add to from several crawlers at once
+type Index struct {
+	mu    sync.RWMutex
+	Docs  []Document
+	IDs   map[string]int
+	Title Field
+	Body  Field
+}
+
+type Result struct {
+	URL     string  `json:"url"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+func New() *Index {
+	return &Index{
+		IDs:   map[string]int{},
+		Title: Field{Postings: map[string][]Posting{}},
+		Body:  Field{Postings: map[string][]Posting{}},
+	}
+}
+
+// a missing index file gives back an empty index
+func Load(path string) (*Index, error) {
+	file, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index := New()
+	if err := gob.NewDecoder(bytes.NewReader(file)).Decode(index); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// a crash never leaves a half written index behind
+func (idx *Index) Save(path string) error {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	buffer := &bytes.Buffer{}
+	if err := gob.NewEncoder(buffer).Encode(idx); err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(path, buffer.Bytes())
+}
+
+func (idx *Index) Size() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return len(idx.IDs)
+}
+
+func (idx *Index) Get(url string) (Document, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	id, ok := idx.IDs[url]
+	if !ok {
+		return Document{}, false
+	}
+
+	return idx.Docs[id], true
+}
+
+// adds a document, replacing whatever was indexed under the same url
+func (idx *Index) Add(doc Document) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if id, ok := idx.IDs[doc.URL]; ok {
+		idx.remove(id)
+	}
+
+	id := len(idx.Docs)
+	idx.Docs = append(idx.Docs, doc)
+	idx.IDs[doc.URL] = id
+
+	idx.Title.add(id, terms(doc.Title))
+	idx.Body.add(id, terms(doc.Content))
+}
+
+// removes whatever was indexed under url, if anything
+func (idx *Index) Remove(url string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if id, ok := idx.IDs[url]; ok {
+		idx.remove(id)
+	}
+}
+
+func (idx *Index) remove(id int) {
+	doc := idx.Docs[id]
+
+	idx.Title.remove(id, terms(doc.Title))
+	idx.Body.remove(id, terms(doc.Content))
+
+	delete(idx.IDs, doc.URL)
+	idx.Docs[id] = Document{}
+}
+
+func (f *Field) add(id int, terms []string) {
+	positions := map[string][]int{}
+	for i, term := range terms {
+		positions[term] = append(positions[term], i)
+	}
+
+	// ids only ever grow, so postings stay sorted by document
+	for term, list := range positions {
+		f.Postings[term] = append(f.Postings[term], Posting{Doc: id, Positions: list})
+	}
+
+	for len(f.Lengths) <= id {
+		f.Lengths = append(f.Lengths, 0)
+	}
+	f.Lengths[id] = len(terms)
+	f.Total += len(terms)
+}
+
+func (f *Field) remove(id int, terms []string) {
+	for _, term := range terms {
+		postings := slices.DeleteFunc(f.Postings[term], func(posting Posting) bool {
+			return posting.Doc == id
+		})
+
+		if len(postings) == 0 {
+			delete(f.Postings, term)
+		} else {
+			f.Postings[term] = postings
+		}
+	}
+
+	f.Total -= f.Lengths[id]
+	f.Lengths[id] = 0
+}
+
+func (f *Field) positions(term string, id int) []int {
+	postings := f.Postings[term]
+
+	i, found := slices.BinarySearchFunc(postings, id, func(posting Posting, id int) int {
+		return cmp.Compare(posting.Doc, id)
+	})
+	if !found {
+		return nil
+	}
+
+	return postings[i].Positions
+}
+
+func (f *Field) bm25(terms []string, id, docs int) float64 {
+	if docs == 0 || f.Total == 0 {
+		return 0
+	}
+	average := float64(f.Total) / float64(docs)
+
+	score := 0.0
+	for _, term := range terms {
+		frequency := float64(len(f.positions(term, id)))
+		if frequency == 0 {
+			continue
+		}
+
+		df := float64(len(f.Postings[term]))
+		idf := math.Log(1 + (float64(docs)-df+0.5)/(df+0.5))
+		norm := k1 * (1 - b + b*float64(f.Lengths[id])/average)
+
+		score += idf * frequency * (k1 + 1) / (frequency + norm)
+	}
+
+	return score
+}
+
+// the first position at which the whole phrase appears, -1 if it doesn't
+func (f *Field) phrase(phrase []string, id int) int {
+	for _, start := range f.positions(phrase[0], id) {
+		matched := true
+		for offset, term := range phrase[1:] {
+			if !slices.Contains(f.positions(term, id), start+offset+1) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return start
+		}
+	}
+
+	return -1
+}
+
+// ranks documents with bm25, quoted phrases in the query must appear in the title or body,
+// returns a page of results along with the total number of matches
+func (idx *Index) Search(query string, limit, offset int) ([]Result, int) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	loose, phrases := parseQuery(query)
+
+	all := slices.Clone(loose)
+	for _, phrase := range phrases {
+		all = append(all, phrase...)
+	}
+	if len(all) == 0 {
+		return []Result{}, 0
+	}
+
+	candidates := map[int]struct{}{}
+	for _, term := range all {
+		for _, field := range []*Field{&idx.Title, &idx.Body} {
+			for _, posting := range field.Postings[term] {
+				candidates[posting.Doc] = struct{}{}
+			}
+		}
+	}
+
+	type match struct {
+		id    int
+		score float64
+		at    int
+	}
+
+	matches := []match{}
+	for id := range candidates {
+		at := -1
+		missing := false
+		for _, phrase := range phrases {
+			inBody := idx.Body.phrase(phrase, id)
+			if inBody == -1 && idx.Title.phrase(phrase, id) == -1 {
+				missing = true
+				break
+			}
+			if at == -1 {
+				at = inBody
+			}
+		}
+		if missing {
+			continue
+		}
+
+		docs := len(idx.IDs)
+		score := titleBoost*idx.Title.bm25(all, id, docs) + idx.Body.bm25(all, id, docs)
+
+		matches = append(matches, match{id: id, score: score, at: at})
+	}
+
+	slices.SortFunc(matches, func(x, y match) int {
+		if c := cmp.Compare(y.score, x.score); c != 0 {
+			return c
+		}
+		return strings.Compare(idx.Docs[x.id].URL, idx.Docs[y.id].URL)
+	})
+
+	total := len(matches)
+	start := min(max(offset, 0), total)
+	end := min(start+max(limit, 0), total)
+
+	results := []Result{}
+	for _, m := range matches[start:end] {
+		doc := idx.Docs[m.id]
+		results = append(results, Result{
+			URL:     doc.URL,
+			Title:   doc.Title,
+			Snippet: snippet(doc.Content, all, m.at),
+			Score:   m.score,
+		})
+	}
+
+	return results, total
+}
+
+// a window of the original text around the first match, with matched terms wrapped in <mark>
+func snippet(content string, queryTerms []string, at int) string {
+	tokens := tokenize(content)
+	if len(tokens) == 0 {
+		return ""
+	}
+
+	if at == -1 {
+		at = slices.IndexFunc(tokens, func(t token) bool {
+			return slices.Contains(queryTerms, t.term)
+		})
+	}
+	at = max(at, 0)
+
+	first := max(0, at-snippetSize/3)
+	last := min(len(tokens)-1, first+snippetSize-1)
+
+	builder := strings.Builder{}
+	if first > 0 {
+		builder.WriteString("… ")
+	}
+
+	cursor := tokens[first].start
+	if first == 0 {
+		cursor = 0
+	}
+	for _, t := range tokens[first : last+1] {
+		builder.WriteString(content[cursor:t.start])
+		if slices.Contains(queryTerms, t.term) {
+			builder.WriteString("<mark>" + content[t.start:t.end] + "</mark>")
+		} else {
+			builder.WriteString(content[t.start:t.end])
+		}
+		cursor = t.end
+	}
+
+	if last < len(tokens)-1 {
+		builder.WriteString(" …")
+	} else {
+		builder.WriteString(content[cursor:])
+	}
+
+	return strings.Join(strings.Fields(builder.String()), " ")
+}
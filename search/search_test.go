@@ -0,0 +1,187 @@
+package search
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testIndex() *Index {
+	index := New()
+	index.Add(Document{
+		URL:     "https://www.google.com/transformers",
+		Title:   "Attention Is All You Need",
+		Content: "The Transformer is a network architecture based solely on attention mechanisms, dispensing with recurrence entirely.",
+	})
+	index.Add(Document{
+		URL:     "https://www.google.com/rnn",
+		Title:   "Recurrent Neural Networks",
+		Content: "Recurrent networks process sequences one step at a time, attention was later added on top of them.",
+	})
+	index.Add(Document{
+		URL:     "https://www.google.com/cafe",
+		Title:   "Café Guide",
+		Content: "The best café in 東京 serves coffee and cake.",
+	})
+
+	return index
+}
+
+func TestSearch(t *testing.T) {
+	testCases := []struct {
+		name          string
+		query         string
+		expected      []string
+		expectedTotal int
+	}{
+		{
+			name:          "Search: test case 1",
+			query:         "attention",
+			expected:      []string{"https://www.google.com/transformers", "https://www.google.com/rnn"},
+			expectedTotal: 2,
+		},
+		{
+			name:          "Search: test case 2",
+			query:         "recurrent architecture",
+			expected:      []string{"https://www.google.com/rnn", "https://www.google.com/transformers"},
+			expectedTotal: 2,
+		},
+		{
+			name:          "Search: test case 3",
+			query:         `"attention mechanisms"`,
+			expected:      []string{"https://www.google.com/transformers"},
+			expectedTotal: 1,
+		},
+		{
+			name:          "Search: test case 4",
+			query:         `"mechanisms attention"`,
+			expected:      []string{},
+			expectedTotal: 0,
+		},
+		{
+			name:          "Search: test case 5",
+			query:         "CAFE",
+			expected:      []string{"https://www.google.com/cafe"},
+			expectedTotal: 1,
+		},
+		{
+			name:          "Search: test case 6",
+			query:         `"東京"`,
+			expected:      []string{"https://www.google.com/cafe"},
+			expectedTotal: 1,
+		},
+		{
+			name:          "Search: test case 7",
+			query:         "   ",
+			expected:      []string{},
+			expectedTotal: 0,
+		},
+	}
+
+	index := testIndex()
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			results, total := index.Search(testCase.query, 10, 0)
+
+			urls := []string{}
+			for _, result := range results {
+				urls = append(urls, result.URL)
+			}
+
+			if comp := reflect.DeepEqual(urls, testCase.expected); !comp {
+				t.Errorf("%s failed, %v != %v", testCase.name, urls, testCase.expected)
+			}
+
+			if total != testCase.expectedTotal {
+				t.Errorf("%s failed, %d != %d", testCase.name, total, testCase.expectedTotal)
+			}
+		})
+	}
+}
+
+func TestSearchPagination(t *testing.T) {
+	index := testIndex()
+
+	results, total := index.Search("attention", 1, 1)
+	if total != 2 {
+		t.Errorf("Search: pagination test case 1 failed, %d != %d", total, 2)
+	}
+	if len(results) != 1 || results[0].URL != "https://www.google.com/rnn" {
+		t.Errorf("Search: pagination test case 2 failed, unexpected results %v", results)
+	}
+
+	results, _ = index.Search("attention", 10, 5)
+	if len(results) != 0 {
+		t.Errorf("Search: pagination test case 3 failed, unexpected results %v", results)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	index := testIndex()
+
+	results, _ := index.Search(`"attention mechanisms"`, 10, 0)
+	if len(results) != 1 {
+		t.Fatalf("Snippet: test case 1 failed, expected a single result, got %v", results)
+	}
+
+	expected := "The Transformer is a network architecture based solely on <mark>attention</mark> <mark>mechanisms</mark>, dispensing with recurrence entirely."
+	if results[0].Snippet != expected {
+		t.Errorf("Snippet: test case 1 failed, %s != %s", results[0].Snippet, expected)
+	}
+}
+
+func TestReplaceAndPersist(t *testing.T) {
+	index := testIndex()
+	index.Add(Document{
+		URL:     "https://www.google.com/rnn",
+		Title:   "Long Short-Term Memory",
+		Content: "Gated cells help with vanishing gradients.",
+	})
+
+	if size := index.Size(); size != 3 {
+		t.Errorf("Index: test case 1 failed, %d != %d", size, 3)
+	}
+
+	if _, total := index.Search("recurrent", 10, 0); total != 0 {
+		t.Errorf("Index: test case 2 failed, %d != %d", total, 0)
+	}
+
+	path := filepath.Join(t.TempDir(), "crawler.index")
+	if err := index.Save(path); err != nil {
+		t.Errorf("Index: test case 3 failed, unexpected error: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Errorf("Index: test case 4 failed, unexpected error: %v", err)
+	}
+
+	results, total := loaded.Search("gradients", 10, 0)
+	if total != 1 || results[0].Title != "Long Short-Term Memory" {
+		t.Errorf("Index: test case 5 failed, unexpected results %v", results)
+	}
+
+	empty, err := Load(filepath.Join(t.TempDir(), "missing.index"))
+	if err != nil || empty.Size() != 0 {
+		t.Errorf("Index: test case 6 failed, unexpected error: %v", err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	index := testIndex()
+	index.Remove("https://www.google.com/rnn")
+	index.Remove("https://www.google.com/missing")
+
+	if size := index.Size(); size != 2 {
+		t.Errorf("Remove: test case 1 failed, %d != %d", size, 2)
+	}
+	if _, ok := index.Get("https://www.google.com/rnn"); ok {
+		t.Errorf("Remove: test case 2 failed, removed document still there")
+	}
+
+	// attention was on both pages, now only one is left to match it
+	if _, total := index.Search("attention", 10, 0); total != 1 {
+		t.Errorf("Remove: test case 3 failed, %d != %d", total, 1)
+	}
+}
@@ -0,0 +1,97 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/junwei890/crawler/utils"
+)
+
+// terms are matched case and accent insensitively
+var termNormaliser = utils.Normaliser{
+	Lowercase:      true,
+	FoldDiacritics: true,
+}
+
+type token struct {
+	term  string
+	start int
+	end   int
+}
+
+// scripts written without spaces get a token per character, so phrases still work on them
+func unspaced(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Thai)
+}
+
+func wordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r)
+}
+
+// splits text into terms, keeping byte offsets into the original text for snippets
+func tokenize(text string) []token {
+	tokens := []token{}
+	start := -1
+
+	flush := func(end int) {
+		if start == -1 {
+			return
+		}
+
+		if term := termNormaliser.Normalise(text[start:end]); term != "" {
+			tokens = append(tokens, token{term: term, start: start, end: end})
+		}
+		start = -1
+	}
+
+	for i, r := range text {
+		switch {
+		case unspaced(r):
+			flush(i)
+			start = i
+			flush(i + utf8.RuneLen(r))
+		case wordRune(r):
+			if start == -1 {
+				start = i
+			}
+		default:
+			flush(i)
+		}
+	}
+	flush(len(text))
+
+	return tokens
+}
+
+func terms(text string) []string {
+	tokens := tokenize(text)
+
+	terms := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		terms = append(terms, token.term)
+	}
+
+	return terms
+}
+
+// quoted parts of a query are phrases, everything else is a loose term
+func parseQuery(query string) ([]string, [][]string) {
+	loose := []string{}
+	phrases := [][]string{}
+
+	parts := strings.Split(query, `"`)
+	for i, part := range parts {
+		// an unterminated quote is treated as loose terms
+		if i%2 == 1 && i != len(parts)-1 {
+			if phrase := terms(part); len(phrase) > 0 {
+				phrases = append(phrases, phrase)
+			}
+			continue
+		}
+
+		loose = append(loose, terms(part)...)
+	}
+
+	return loose, phrases
+}
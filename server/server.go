@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/junwei890/crawler/search"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+var ErrNotFound = errors.New("document not found")
+
+// anything that can answer queries over crawled content
+type Searcher interface {
+	Search(ctx context.Context, query string, limit, offset int) ([]search.Result, int, error)
+	// returns ErrNotFound for urls that were never stored
+	Document(ctx context.Context, url string) (any, error)
+	// checks the backend can serve queries
+	Ping(ctx context.Context) error
+}
+
+type SearchResponse struct {
+	Query    string          `json:"query"`
+	Page     int             `json:"page"`
+	PageSize int             `json:"page_size"`
+	Total    int             `json:"total"`
+	Results  []search.Result `json:"results"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func New(searcher Searcher) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /search", func(w http.ResponseWriter, r *http.Request) {
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "missing q parameter"})
+			return
+		}
+
+		page, err := positiveInt(r.URL.Query().Get("page"), 1)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "page must be a positive integer"})
+			return
+		}
+
+		size, err := positiveInt(r.URL.Query().Get("size"), defaultPageSize)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "size must be a positive integer"})
+			return
+		}
+		size = min(size, maxPageSize)
+
+		results, total, err := searcher.Search(r.Context(), query, size, (page-1)*size)
+		if err != nil {
+			slog.Error("search failed", "query", query, "error", err)
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "search failed"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, SearchResponse{
+			Query:    query,
+			Page:     page,
+			PageSize: size,
+			Total:    total,
+			Results:  results,
+		})
+	})
+
+	mux.HandleFunc("GET /doc", func(w http.ResponseWriter, r *http.Request) {
+		url := r.URL.Query().Get("url")
+		if url == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "missing url parameter"})
+			return
+		}
+
+		doc, err := searcher.Document(r.Context(), url)
+		if errors.Is(err, ErrNotFound) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			slog.Error("document lookup failed", "url", url, "error", err)
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "lookup failed"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, doc)
+	})
+
+	// liveness, the process is up
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	// readiness, the backend can answer queries
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := searcher.Ping(r.Context()); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+
+	return mux
+}
+
+func positiveInt(raw string, fallback int) (int, error) {
+	if raw == "" {
+		return fallback, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 1 {
+		return 0, errors.New("not a positive integer")
+	}
+
+	return value, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("couldn't write response", "error", err)
+	}
+}
+
+// serves queries straight from a local index file
+type Local struct {
+	Index *search.Index
+}
+
+func (l Local) Search(ctx context.Context, query string, limit, offset int) ([]search.Result, int, error) {
+	results, total := l.Index.Search(query, limit, offset)
+	return results, total, nil
+}
+
+func (l Local) Document(ctx context.Context, url string) (any, error) {
+	doc, ok := l.Index.Get(url)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return doc, nil
+}
+
+func (l Local) Ping(ctx context.Context) error {
+	if l.Index == nil {
+		return errors.New("no index loaded")
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/junwei890/crawler/search"
+)
+
+func testServer() *httptest.Server {
+	index := search.New()
+	for _, doc := range []search.Document{
+		{URL: "https://www.google.com/a", Title: "Go Concurrency", Content: "Goroutines and channels make concurrency simple."},
+		{URL: "https://www.google.com/b", Title: "Go Generics", Content: "Generics arrived in Go 1.18 alongside goroutines improvements."},
+		{URL: "https://www.google.com/c", Title: "Rust Ownership", Content: "Ownership and borrowing keep memory safe."},
+	} {
+		index.Add(doc)
+	}
+
+	return httptest.NewServer(New(Local{Index: index}))
+}
+
+func TestSearchEndpoint(t *testing.T) {
+	server := testServer()
+	defer server.Close()
+
+	testCases := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedTotal  int
+		expectedURLs   []string
+	}{
+		{
+			name:           "GET /search: test case 1",
+			query:          "q=goroutines",
+			expectedStatus: http.StatusOK,
+			expectedTotal:  2,
+			expectedURLs:   []string{"https://www.google.com/a", "https://www.google.com/b"},
+		},
+		{
+			name:           "GET /search: test case 2",
+			query:          "q=goroutines&page=2&size=1",
+			expectedStatus: http.StatusOK,
+			expectedTotal:  2,
+			expectedURLs:   []string{"https://www.google.com/b"},
+		},
+		{
+			name:           "GET /search: test case 3",
+			query:          "q=",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "GET /search: test case 4",
+			query:          "q=go&page=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "GET /search: test case 5",
+			query:          "q=python",
+			expectedStatus: http.StatusOK,
+			expectedTotal:  0,
+			expectedURLs:   []string{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			res, err := http.Get(server.URL + "/search?" + testCase.query)
+			if err != nil {
+				t.Fatalf("%s failed, unexpected error: %v", testCase.name, err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != testCase.expectedStatus {
+				t.Errorf("%s failed, %d != %d", testCase.name, res.StatusCode, testCase.expectedStatus)
+			}
+			if res.StatusCode != http.StatusOK {
+				return
+			}
+
+			body := SearchResponse{}
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+			}
+
+			if body.Total != testCase.expectedTotal {
+				t.Errorf("%s failed, %d != %d", testCase.name, body.Total, testCase.expectedTotal)
+			}
+
+			urls := []string{}
+			for _, result := range body.Results {
+				urls = append(urls, result.URL)
+			}
+			if len(urls) != len(testCase.expectedURLs) {
+				t.Fatalf("%s failed, %v != %v", testCase.name, urls, testCase.expectedURLs)
+			}
+			for i := range urls {
+				if urls[i] != testCase.expectedURLs[i] {
+					t.Errorf("%s failed, %v != %v", testCase.name, urls, testCase.expectedURLs)
+				}
+			}
+		})
+	}
+}
+
+func TestDocEndpoint(t *testing.T) {
+	server := testServer()
+	defer server.Close()
+
+	testCases := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedTitle  string
+	}{
+		{
+			name:           "GET /doc: test case 1",
+			url:            "https://www.google.com/c",
+			expectedStatus: http.StatusOK,
+			expectedTitle:  "Rust Ownership",
+		},
+		{
+			name:           "GET /doc: test case 2",
+			url:            "https://www.google.com/missing",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "GET /doc: test case 3",
+			url:            "",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			res, err := http.Get(server.URL + "/doc?url=" + url.QueryEscape(testCase.url))
+			if err != nil {
+				t.Fatalf("%s failed, unexpected error: %v", testCase.name, err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != testCase.expectedStatus {
+				t.Errorf("%s failed, %d != %d", testCase.name, res.StatusCode, testCase.expectedStatus)
+			}
+			if res.StatusCode != http.StatusOK {
+				return
+			}
+
+			doc := search.Document{}
+			if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+				t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+			}
+
+			if doc.Title != testCase.expectedTitle {
+				t.Errorf("%s failed, %s != %s", testCase.name, doc.Title, testCase.expectedTitle)
+			}
+		})
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	server := testServer()
+	defer server.Close()
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed, unexpected error: %v", path, err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("GET %s failed, %d != %d", path, res.StatusCode, http.StatusOK)
+		}
+	}
+
+	unready := httptest.NewServer(New(Local{}))
+	defer unready.Close()
+
+	res, err := http.Get(unready.URL + "/readyz")
+	if err != nil {
+		t.Fatalf("GET /readyz failed, unexpected error: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz failed, %d != %d", res.StatusCode, http.StatusServiceUnavailable)
+	}
+}
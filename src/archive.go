@@ -0,0 +1,313 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/junwei890/crawler/utils"
+	"github.com/klauspost/compress/zstd"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ArchiveDisk   = "disk"
+	ArchiveGridFS = "gridfs"
+)
+
+var ErrNotArchived = errors.New("not in the archive")
+
+// a page as it was fetched, before anything was extracted from it
+type Capture struct {
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Fetch  Fetch       `json:"fetch"`
+	// decoded from any content encoding, but otherwise as the server sent it
+	Body []byte `json:"-"`
+}
+
+// raw pages kept so extraction can be rerun without refetching
+type Archive interface {
+	// stores the capture, returning the key it can be read back with
+	Put(Capture) (string, error)
+	Get(key string) (Capture, error)
+	// the capture without its body, which is never read, for going over the archive cheaply
+	Head(key string) (Capture, error)
+	// calls fn with the key of every capture, in key order
+	Walk(fn func(key string) error) error
+}
+
+// where an archive keeps its blobs, putting a name that already exists is a no-op since names are hashes
+type blobStore interface {
+	put(name string, data []byte) error
+	get(name string) ([]byte, error)
+	list(prefix string) ([]string, error)
+}
+
+// bodies and captures are stored apart and both named by their hash, so a body fetched from several
+// urls or refetched unchanged is only kept once while each capture keeps its own headers
+type contentArchive struct {
+	blobs   blobStore
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newContentArchive(blobs blobStore) (*contentArchive, error) {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		return nil, err
+	}
+
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	if err != nil {
+		return nil, err
+	}
+
+	return &contentArchive{blobs: blobs, encoder: encoder, decoder: decoder}, nil
+}
+
+// captures refer to their body by hash
+type storedCapture struct {
+	Capture
+	Body string `json:"body"`
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *contentArchive) Put(capture Capture) (string, error) {
+	body := hash(capture.Body)
+	if err := a.blobs.put("bodies/"+body, a.encoder.EncodeAll(capture.Body, nil)); err != nil {
+		return "", err
+	}
+
+	encoded, err := json.Marshal(storedCapture{Capture: capture, Body: body})
+	if err != nil {
+		return "", err
+	}
+
+	key := hash(encoded)
+	if err := a.blobs.put("captures/"+key, a.encoder.EncodeAll(encoded, nil)); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+func (a *contentArchive) read(name string) ([]byte, error) {
+	compressed, err := a.blobs.get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := a.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// names are hashes of what was stored, so corruption is caught here rather than in extraction
+	if hash(data) != name[strings.LastIndex(name, "/")+1:] {
+		return nil, fmt.Errorf("%s doesn't match its hash", name)
+	}
+
+	return data, nil
+}
+
+func (a *contentArchive) Get(key string) (Capture, error) {
+	stored, err := a.head(key)
+	if err != nil {
+		return Capture{}, err
+	}
+
+	capture := stored.Capture
+	capture.Body, err = a.read("bodies/" + stored.Body)
+
+	return capture, err
+}
+
+func (a *contentArchive) Head(key string) (Capture, error) {
+	stored, err := a.head(key)
+	return stored.Capture, err
+}
+
+func (a *contentArchive) head(key string) (storedCapture, error) {
+	encoded, err := a.read("captures/" + key)
+	if err != nil {
+		return storedCapture{}, err
+	}
+
+	stored := storedCapture{}
+	err = json.Unmarshal(encoded, &stored)
+
+	return stored, err
+}
+
+func (a *contentArchive) Walk(fn func(key string) error) error {
+	names, err := a.blobs.list("captures/")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := fn(strings.TrimPrefix(name, "captures/")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// opens the archive set in the config, nil if archiving is off
+func OpenArchive(db *mongo.Database, config ArchiveConfig) (Archive, error) {
+	switch config.Store {
+	case "":
+		return nil, nil
+	case ArchiveDisk:
+		return NewDiskArchive(config.Path)
+	case ArchiveGridFS:
+		return NewGridFSArchive(db)
+	default:
+		return nil, fmt.Errorf("unknown archive store %s", config.Store)
+	}
+}
+
+// blobs under dir, fanned out by the first two characters of their hash so no directory gets huge
+type diskBlobs struct {
+	dir string
+}
+
+func NewDiskArchive(dir string) (Archive, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return newContentArchive(diskBlobs{dir: dir})
+}
+
+func (d diskBlobs) path(name string) string {
+	kind, sum, _ := strings.Cut(name, "/")
+	return filepath.Join(d.dir, kind, sum[:2], sum)
+}
+
+func (d diskBlobs) put(name string, data []byte) error {
+	path := d.path(name)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	// a crawler killed mid write can't leave a bad blob behind
+	return utils.WriteFileAtomic(path, data)
+}
+
+func (d diskBlobs) get(name string) ([]byte, error) {
+	data, err := os.ReadFile(d.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s %w", name, ErrNotArchived)
+	}
+
+	return data, err
+}
+
+func (d diskBlobs) list(prefix string) ([]string, error) {
+	names := []string{}
+
+	root := filepath.Join(d.dir, strings.TrimSuffix(prefix, "/"))
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, prefix+entry.Name())
+		}
+
+		return nil
+	})
+	sort.Strings(names)
+
+	return names, err
+}
+
+// blobs in the archive gridfs bucket, named and keyed by their kind and hash
+type gridBlobs struct {
+	bucket *gridfs.Bucket
+}
+
+func NewGridFSArchive(db *mongo.Database) (Archive, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName("archive"))
+	if err != nil {
+		return nil, err
+	}
+
+	return newContentArchive(gridBlobs{bucket: bucket})
+}
+
+func (g gridBlobs) put(name string, data []byte) error {
+	// checked first as a failed upload removes every chunk under its id, including an earlier upload's
+	count, err := g.bucket.GetFilesCollection().CountDocuments(context.TODO(), bson.D{{Key: "_id", Value: name}})
+	if err != nil || count > 0 {
+		return err
+	}
+
+	err = g.bucket.UploadFromStreamWithID(name, name, bytes.NewReader(data))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (g gridBlobs) get(name string) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+
+	_, err := g.bucket.DownloadToStream(name, buffer)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, fmt.Errorf("%s %w", name, ErrNotArchived)
+	}
+
+	return buffer.Bytes(), err
+}
+
+func (g gridBlobs) list(prefix string) ([]string, error) {
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$regex", Value: "^" + prefix}}}}
+	cursor, err := g.bucket.GetFilesCollection().Find(context.TODO(), filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetProjection(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	names := []string{}
+	for cursor.Next(context.TODO()) {
+		file := struct {
+			ID string `bson:"_id"`
+		}{}
+		if err := cursor.Decode(&file); err != nil {
+			return nil, err
+		}
+		names = append(names, file.ID)
+	}
+
+	return names, cursor.Err()
+}
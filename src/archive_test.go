@@ -0,0 +1,263 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func capturePage(url, body string, at time.Time) Capture {
+	return Capture{
+		URL:    url,
+		Header: http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		Fetch: Fetch{
+			Status:    200,
+			MediaType: "text/html",
+			Encoding:  "gzip",
+			WireBytes: 300,
+			Bytes:     int64(len(body)),
+			FetchedAt: at,
+			LatencyMS: 20,
+			Depth:     1,
+		},
+		Body: []byte(body),
+	}
+}
+
+func countFiles(t *testing.T, dir string) int {
+	count := 0
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	return count
+}
+
+func TestDiskArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := NewDiskArchive(dir)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	first := capturePage("https://example.com/a", "<html><title>A</title></html>", at)
+	mirror := capturePage("https://mirror.example.com/a", "<html><title>A</title></html>", at)
+
+	key, err := archive.Put(first)
+	if err != nil {
+		t.Fatalf("DiskArchive: test case 1 failed, unexpected error: %v", err)
+	}
+
+	result, err := archive.Get(key)
+	if err != nil || !reflect.DeepEqual(result, first) {
+		t.Errorf("DiskArchive: test case 2 failed, %+v != %+v (%v)", result, first, err)
+	}
+
+	// storing the same capture again is a no-op under the same key
+	if again, err := archive.Put(first); err != nil || again != key {
+		t.Errorf("DiskArchive: test case 3 failed, %s != %s (%v)", again, key, err)
+	}
+
+	mirrorKey, err := archive.Put(mirror)
+	if err != nil || mirrorKey == key {
+		t.Errorf("DiskArchive: test case 4 failed, mirror stored under %s (%v)", mirrorKey, err)
+	}
+
+	// the mirror's body is the same, so only its capture is new
+	if bodies, captures := countFiles(t, filepath.Join(dir, "bodies")), countFiles(t, filepath.Join(dir, "captures")); bodies != 1 || captures != 2 {
+		t.Errorf("DiskArchive: test case 5 failed, %d bodies and %d captures != 1 and 2", bodies, captures)
+	}
+
+	keys := []string{}
+	if err := archive.Walk(func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		t.Errorf("DiskArchive: test case 6 failed, unexpected error: %v", err)
+	}
+	expected := []string{key, mirrorKey}
+	if key > mirrorKey {
+		expected = []string{mirrorKey, key}
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("DiskArchive: test case 6 failed, %v != %v", keys, expected)
+	}
+
+	if _, err := archive.Get(strings.Repeat("0", 64)); !errors.Is(err, ErrNotArchived) {
+		t.Errorf("DiskArchive: test case 7 failed, %v != %v", err, ErrNotArchived)
+	}
+
+	// a body that's changed on disk only matters to reads that need it
+	bodyPath := filepath.Join(dir, "bodies", hash(first.Body)[:2], hash(first.Body))
+	if err := os.WriteFile(bodyPath, []byte("corrupt"), 0o600); err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	head, err := archive.Head(key)
+	if err != nil || head.URL != first.URL || head.Fetch != first.Fetch || head.Body != nil {
+		t.Errorf("DiskArchive: test case 8 failed, unexpected capture %+v (%v)", head, err)
+	}
+	if _, err := archive.Get(key); err == nil {
+		t.Errorf("DiskArchive: test case 9 failed, expected error for a corrupted body")
+	}
+
+	// a blob that's changed on disk no longer matches its name
+	path := filepath.Join(dir, "captures", key[:2], key)
+	if err := os.WriteFile(path, archive.(*contentArchive).encoder.EncodeAll([]byte("{}"), nil), 0o600); err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	if _, err := archive.Get(key); err == nil {
+		t.Errorf("DiskArchive: test case 10 failed, expected error for a corrupted capture")
+	}
+}
+
+func TestReprocess(t *testing.T) {
+	archive, err := NewDiskArchive(t.TempDir())
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	page := func(title, text string) string {
+		return fmt.Sprintf(`<html lang="en"><head><title>%s</title></head><body><p>%s</p><a href="/next">next page</a></body></html>`, title, strings.Repeat(text+" ", 60))
+	}
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	captures := []Capture{
+		// refetched later with different content, only the latest capture counts
+		capturePage("https://example.com/a", page("Old", "stale words that were replaced"), start),
+		capturePage("https://example.com/a", page("New", "fresh words about crawling the web"), start.Add(time.Hour)),
+		capturePage("https://example.com/b", page("Other", "an entirely different page on gardening"), start.Add(time.Minute)),
+		// same text as a but fetched after it, so it's the duplicate
+		capturePage("https://example.com/c", page("New", "fresh words about crawling the web"), start.Add(2*time.Hour)),
+		capturePage("https://example.com/short", "<html><title>Short</title><p>too short</p></html>", start),
+	}
+	broken := capturePage("https://example.com/image", "not an image", start)
+	broken.Fetch.MediaType = "image/png"
+	captures = append(captures, broken)
+
+	keys := map[string]string{}
+	for _, capture := range captures {
+		key, err := archive.Put(capture)
+		if err != nil {
+			t.Fatalf("error setting up test, unexpected error: %v", err)
+		}
+		keys[capture.URL] = key
+	}
+
+	config := DefaultConfig()
+	stats := NewStats("archive")
+	result := []Content{}
+	edges := 0
+	found := func(links []Edge) {
+		edges += len(links)
+	}
+	dropped := []string{}
+	drop := func(url string) error {
+		dropped = append(dropped, url)
+		return nil
+	}
+	err = reprocess(archive, config, stats, found, func(content Content) error {
+		result = append(result, content)
+		return nil
+	}, drop)
+	if err != nil {
+		t.Fatalf("Reprocess: test case 1 failed, unexpected error: %v", err)
+	}
+
+	urls := []string{}
+	for _, content := range result {
+		urls = append(urls, content.URL)
+	}
+	if expected := []string{"https://example.com/b", "https://example.com/a", "https://example.com/c"}; !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("Reprocess: test case 2 failed, %v != %v", urls, expected)
+	}
+
+	a := result[1]
+	if a.Title != "New" || a.Language != "en" || a.Host != "example.com" || a.Archive != keys[a.URL] || a.Fetch != captures[1].Fetch {
+		t.Errorf("Reprocess: test case 3 failed, unexpected content %+v", a)
+	}
+	if result[2].DuplicateOf != "https://example.com/a" || result[0].DuplicateOf != "" {
+		t.Errorf("Reprocess: test case 4 failed, %s != %s", result[2].DuplicateOf, "https://example.com/a")
+	}
+	if edges != 3 {
+		t.Errorf("Reprocess: test case 5 failed, %d != %d", edges, 3)
+	}
+
+	if stats.Fetched != 5 || stats.Stored != 3 || stats.TooShort != 1 || stats.Errors[ErrClassParse] != 1 {
+		t.Errorf("Reprocess: test case 6 failed, unexpected stats %+v", stats)
+	}
+
+	// pages that wouldn't be stored any more are dropped, a page that failed to parse is left alone
+	if expected := []string{"https://example.com/short"}; !reflect.DeepEqual(dropped, expected) {
+		t.Errorf("Reprocess: test case 7 failed, %v != %v", dropped, expected)
+	}
+
+	config.Dedup.Mode = DedupSkip
+	dropped = []string{}
+	err = reprocess(archive, config, NewStats("archive"), found, func(content Content) error { return nil }, drop)
+	if expected := []string{"https://example.com/short", "https://example.com/c"}; err != nil || !reflect.DeepEqual(dropped, expected) {
+		t.Errorf("Reprocess: test case 8 failed, %v != %v (%v)", dropped, expected, err)
+	}
+}
+
+func TestArchiveOriginal(t *testing.T) {
+	// latin-1, where é is the single byte 0xe9
+	words := []string{}
+	for i := range 80 {
+		words = append(words, fmt.Sprintf("caf\xe9%d", i))
+	}
+	page := "<html lang=\"fr\"><head><title>Caf\xe9</title></head><body><p>" + strings.Join(words, " ") + "</p></body></html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	archive, err := NewDiskArchive(t.TempDir())
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	r, store := newMemoryRun(DefaultConfig())
+	r.archive = archive
+	if err := crawler(t.Context(), server.URL, r, NewStats(server.URL)); err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	content := store.Content()
+	if len(content) != 1 || content[0].Title != "Café" {
+		t.Fatalf("ArchiveOriginal: test case 1 failed, unexpected content %+v", content)
+	}
+
+	// the archive has the bytes as they were sent, not the utf-8 they were parsed as
+	capture, err := archive.Get(content[0].Archive)
+	if err != nil || string(capture.Body) != page {
+		t.Errorf("ArchiveOriginal: test case 2 failed, %q != %q (%v)", capture.Body, page, err)
+	}
+
+	result := []Content{}
+	err = reprocess(archive, DefaultConfig(), NewStats("archive"), func([]Edge) {}, func(content Content) error {
+		result = append(result, content)
+		return nil
+	}, func(string) error { return nil })
+	if err != nil || len(result) != 1 || result[0].Title != "Café" || !strings.HasPrefix(result[0].Content, "café0 café1") {
+		t.Errorf("ArchiveOriginal: test case 3 failed, unexpected content %+v (%v)", result, err)
+	}
+}
@@ -0,0 +1,212 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/junwei890/crawler/search"
+	"github.com/junwei890/crawler/server"
+	"github.com/junwei890/crawler/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// runs $search aggregations against the atlas search index
+type AtlasSearcher struct {
+	Client     *mongo.Client
+	Collection *mongo.Collection
+	Index      string
+	// applied to queries against the search field, so they match the text as it was normalised
+	Normaliser utils.Normaliser
+}
+
+// one page of hits with the count kept apart from them, so it's there even when the page is empty
+type atlasPage struct {
+	Hits []atlasHit `bson:"hits"`
+	Meta []struct {
+		Count struct {
+			Total int `bson:"total"`
+		} `bson:"count"`
+	} `bson:"meta"`
+}
+
+type atlasHit struct {
+	URL        string           `bson:"_id"`
+	Title      string           `bson:"title"`
+	Score      float64          `bson:"score"`
+	Highlights []atlasHighlight `bson:"highlights"`
+}
+
+type atlasHighlight struct {
+	Score float64 `bson:"score"`
+	Texts []struct {
+		Value string `bson:"value"`
+		Type  string `bson:"type"`
+	} `bson:"texts"`
+}
+
+func (a AtlasSearcher) Search(ctx context.Context, query string, limit, offset int) ([]search.Result, int, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$search", Value: bson.D{
+			{Key: "index", Value: a.Index},
+			// the raw text goes through the language analyzer, the normalised copy catches what it
+			// doesn't fold, like diacritics with fold_diacritics on
+			{Key: "compound", Value: bson.D{
+				{Key: "should", Value: bson.A{
+					bson.D{{Key: "text", Value: bson.D{
+						{Key: "query", Value: query},
+						{Key: "path", Value: bson.A{"title", "content", "anchors"}},
+					}}},
+					bson.D{{Key: "text", Value: bson.D{
+						{Key: "query", Value: a.Normaliser.Normalise(query)},
+						{Key: "path", Value: "search"},
+					}}},
+				}},
+			}},
+			{Key: "highlight", Value: bson.D{{Key: "path", Value: "content"}}},
+			{Key: "count", Value: bson.D{{Key: "type", Value: "total"}}},
+		}}},
+		// the count comes from $$SEARCH_META on its own branch, so a page past the end still has it
+		{{Key: "$facet", Value: bson.D{
+			{Key: "hits", Value: bson.A{
+				bson.D{{Key: "$skip", Value: offset}},
+				bson.D{{Key: "$limit", Value: limit}},
+				bson.D{{Key: "$project", Value: bson.D{
+					{Key: "title", Value: 1},
+					{Key: "score", Value: bson.D{{Key: "$meta", Value: "searchScore"}}},
+					{Key: "highlights", Value: bson.D{{Key: "$meta", Value: "searchHighlights"}}},
+				}}},
+			}},
+			{Key: "meta", Value: bson.A{
+				bson.D{{Key: "$replaceWith", Value: "$$SEARCH_META"}},
+				bson.D{{Key: "$limit", Value: 1}},
+			}},
+		}}},
+	}
+
+	cursor, err := a.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pages := []atlasPage{}
+	if err := cursor.All(ctx, &pages); err != nil {
+		return nil, 0, err
+	}
+	if len(pages) == 0 {
+		return []search.Result{}, 0, nil
+	}
+
+	results, total := pages[0].results()
+	return results, total, nil
+}
+
+func (p atlasPage) results() ([]search.Result, int) {
+	total := 0
+	if len(p.Meta) > 0 {
+		total = p.Meta[0].Count.Total
+	}
+
+	results := []search.Result{}
+	for _, hit := range p.Hits {
+		results = append(results, search.Result{
+			URL:     hit.URL,
+			Title:   hit.Title,
+			Snippet: highlightSnippet(hit.Highlights),
+			Score:   hit.Score,
+		})
+	}
+
+	return results, total
+}
+
+// the best scoring highlight, with hits wrapped in <mark> like the local index does
+func highlightSnippet(highlights []atlasHighlight) string {
+	if len(highlights) == 0 {
+		return ""
+	}
+
+	best := highlights[0]
+	for _, highlight := range highlights[1:] {
+		if highlight.Score > best.Score {
+			best = highlight
+		}
+	}
+
+	builder := strings.Builder{}
+	for _, text := range best.Texts {
+		if text.Type == "hit" {
+			builder.WriteString("<mark>" + text.Value + "</mark>")
+		} else {
+			builder.WriteString(text.Value)
+		}
+	}
+
+	return strings.Join(strings.Fields(builder.String()), " ")
+}
+
+func (a AtlasSearcher) Document(ctx context.Context, url string) (any, error) {
+	doc := Content{}
+
+	err := a.Collection.FindOne(ctx, bson.D{{Key: "_id", Value: url}}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, server.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+func (a AtlasSearcher) Ping(ctx context.Context) error {
+	return a.Client.Ping(ctx, readpref.Primary())
+}
+
+// serves the search api over whichever backend the config picks
+func Serve(dbURI string, config Config) error {
+	var searcher server.Searcher
+
+	if config.Search.Backend == BackendLocal {
+		index, err := search.Load(config.Search.Path)
+		if err != nil {
+			return err
+		}
+
+		searcher = server.Local{Index: index}
+	} else {
+		client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(dbURI))
+		if err != nil {
+			return err
+		}
+
+		defer client.Disconnect(context.TODO())
+
+		// nested structured data should come back as maps so it encodes to json objects
+		bsonOptions := options.Collection().SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true})
+
+		searcher = AtlasSearcher{
+			Client:     client,
+			Collection: client.Database("crawler").Collection("content", bsonOptions),
+			Index:      config.Index.Name,
+			Normaliser: config.Normaliser,
+		}
+	}
+
+	srv := &http.Server{
+		Addr:              config.Server.Addr,
+		Handler:           server.New(searcher),
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	slog.Info("serving search", "backend", config.Search.Backend, "addr", config.Server.Addr)
+
+	return srv.ListenAndServe()
+}
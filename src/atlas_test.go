@@ -0,0 +1,67 @@
+package src
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/junwei890/crawler/search"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAtlasPage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		page     bson.M
+		expected []search.Result
+		total    int
+	}{
+		{
+			name: "atlasPage: test case 1",
+			page: bson.M{
+				"hits": bson.A{
+					bson.M{"_id": "https://example.com/a", "title": "A", "score": 2.5, "highlights": bson.A{
+						bson.M{"score": 1.0, "texts": bson.A{bson.M{"value": "about ", "type": "text"}, bson.M{"value": "crawling", "type": "hit"}}},
+					}},
+				},
+				"meta": bson.A{bson.M{"count": bson.M{"total": 11}}},
+			},
+			expected: []search.Result{{URL: "https://example.com/a", Title: "A", Snippet: "about <mark>crawling</mark>", Score: 2.5}},
+			total:    11,
+		},
+		{
+			// a page past the last hit still knows how many there are
+			name: "atlasPage: test case 2",
+			page: bson.M{
+				"hits": bson.A{},
+				"meta": bson.A{bson.M{"count": bson.M{"total": 11}}},
+			},
+			expected: []search.Result{},
+			total:    11,
+		},
+		{
+			name:     "atlasPage: test case 3",
+			page:     bson.M{"hits": bson.A{}, "meta": bson.A{}},
+			expected: []search.Result{},
+			total:    0,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			encoded, err := bson.Marshal(testCase.page)
+			if err != nil {
+				t.Fatalf("error setting up test, unexpected error: %v", err)
+			}
+
+			page := atlasPage{}
+			if err := bson.Unmarshal(encoded, &page); err != nil {
+				t.Fatalf("error setting up test, unexpected error: %v", err)
+			}
+
+			results, total := page.results()
+			if !reflect.DeepEqual(results, testCase.expected) || total != testCase.total {
+				t.Errorf("%s failed, %v (%d) != %v (%d)", testCase.name, results, total, testCase.expected, testCase.total)
+			}
+		})
+	}
+}
@@ -0,0 +1,437 @@
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/junwei890/crawler/utils"
+)
+
+type Config struct {
+	// how the search field is derived from the raw text
+	Normaliser utils.Normaliser `json:"normaliser"`
+	// atlas search analyzer for text fields that don't set their own
+	Analyzer string `json:"analyzer"`
+	// definition of the atlas search index
+	Index Index `json:"index"`
+	// near duplicate handling across every site in a run
+	Dedup Dedup `json:"dedup"`
+	// where crawled pages are indexed for searching
+	Search Search `json:"search"`
+	// the search api started by the serve command
+	Server Server `json:"server"`
+	// prometheus endpoint served while crawling
+	Metrics Metrics `json:"metrics"`
+	Log     Log     `json:"log"`
+	// the order pages on a site are crawled in
+	Frontier Frontier `json:"frontier"`
+	// hosts shared out between processes run with the worker command
+	Workers Workers `json:"workers"`
+	// files stored pages are written to while crawling, alongside the content collection
+	Sinks []SinkConfig `json:"sinks"`
+	// where fetched pages are kept as they were sent, for the reprocess command
+	Archive ArchiveConfig `json:"archive"`
+	// whether requests go to the network, are recorded as they do or are replayed from a recording
+	HTTP HTTP `json:"http"`
+	// sites whose pages are rendered in a headless chrome before they're parsed
+	Render Render `json:"render"`
+	// css selectors for extracting pages of sites we know, used in place of the generic html extraction
+	Profiles []utils.Profile `json:"profiles"`
+}
+
+type Render struct {
+	// hosts to render, matched against each seed's host
+	Hosts []string `json:"hosts"`
+	// the chrome binary, looked for in the usual places when empty
+	Chrome string `json:"chrome"`
+	// pages rendered at once across every site
+	Concurrency int `json:"concurrency"`
+	// give up on a page that hasn't gone idle after this long
+	Timeout Duration `json:"timeout"`
+}
+
+// the renderer for a run, nil when no site is rendered
+func (r Render) open() (utils.Renderer, error) {
+	if len(r.Hosts) == 0 {
+		return nil, nil
+	}
+
+	renderer, err := utils.NewChromeRenderer(r.Chrome, r.Concurrency, time.Duration(r.Timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	return renderer, nil
+}
+
+const (
+	HTTPLive   = "live"
+	HTTPRecord = "record"
+	HTTPReplay = "replay"
+)
+
+type HTTP struct {
+	// live, record or replay
+	Mode string `json:"mode"`
+	// directory interactions are recorded to and replayed from
+	Cassettes string `json:"cassettes"`
+}
+
+// the transport for utils.Client, nil when requests should go out as normal
+func (h HTTP) Transport() (http.RoundTripper, error) {
+	switch h.Mode {
+	case HTTPRecord:
+		return utils.NewRecorder(h.Cassettes, nil)
+	case HTTPReplay:
+		return utils.NewReplayer(h.Cassettes)
+	default:
+		return nil, nil
+	}
+}
+
+type ArchiveConfig struct {
+	// disk, gridfs or empty to not archive pages
+	Store string `json:"store"`
+	// the directory under the disk store
+	Path string `json:"path"`
+}
+
+type SinkConfig struct {
+	// jsonl, parquet or warc
+	Format string `json:"format"`
+	// gzipped if it ends in .gz, except parquet which is compressed anyway
+	Path string `json:"path"`
+}
+
+const (
+	StrategyBFS      = "bfs"
+	StrategyDFS      = "dfs"
+	StrategyPriority = "priority"
+)
+
+type Frontier struct {
+	// bfs, dfs or priority (highest weighted score first)
+	Strategy string `json:"strategy"`
+	// weights of each signal under the priority strategy, a weight of 0 turns the signal off
+	Sitemap  float64   `json:"sitemap"`
+	Inlinks  float64   `json:"inlinks"`
+	Depth    float64   `json:"depth"`
+	Patterns []Pattern `json:"patterns"`
+	// pages requested per site before moving on, 0 for no limit
+	Budget int `json:"budget"`
+	// spill each site's frontier and seen urls to disk under this directory, empty keeps them in memory
+	Dir string `json:"dir"`
+	// urls held in memory per site before spilling
+	Window int `json:"window"`
+	// urls expected across every site in a run, sizes the one bloom filter in front of their seen urls on disk
+	Expected int `json:"expected"`
+}
+
+type Pattern struct {
+	// regular expression matched against the full url
+	Match  string  `json:"match"`
+	Weight float64 `json:"weight"`
+}
+
+const (
+	LeasesMongo = "mongo"
+	LeasesFile  = "file"
+)
+
+type Workers struct {
+	// mongo shares hosts through a leases collection, file through a json file that only works on one machine
+	Store string `json:"store"`
+	// the leases file under the file store
+	Path string `json:"path"`
+	// names the crawl, every worker of one crawl is started with the same run and a new one starts a
+	// new crawl in the same store
+	Run string `json:"run"`
+	// a host is handed to another worker if its lease isn't renewed for this long
+	TTL       Duration `json:"ttl"`
+	Heartbeat Duration `json:"heartbeat"`
+	// hosts each worker crawls at once
+	Concurrency int `json:"concurrency"`
+}
+
+type Server struct {
+	Addr string `json:"addr"`
+}
+
+type Metrics struct {
+	// where /metrics is served during a crawl, left empty to turn it off
+	Addr string `json:"addr"`
+}
+
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+type Log struct {
+	// debug, info, warn or error
+	Level string `json:"level"`
+	// text for reading in a terminal, json for shipping somewhere to be queried
+	Format string `json:"format"`
+}
+
+const (
+	DedupOff  = "off"
+	DedupSkip = "skip"
+	DedupLink = "link"
+)
+
+type Index struct {
+	Name     string                `json:"name"`
+	Fields   map[string]IndexField `json:"fields"`
+	Synonyms []Synonyms            `json:"synonyms"`
+	// block until the index is queryable or has failed to build
+	Wait bool `json:"wait"`
+	// give up waiting after this long, e.g. "10m"
+	WaitTimeout Duration `json:"wait_timeout"`
+}
+
+// a time.Duration written as a string like "90s" in the config file
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type IndexField struct {
+	// analyzer for full text search on the field, falls back to the top level analyzer
+	Analyzer string `json:"analyzer"`
+	// matched as a whole value instead of being tokenised
+	Keyword bool `json:"keyword"`
+	// search as you type on the field
+	Autocomplete bool `json:"autocomplete"`
+	// counts per value can be faceted on
+	Facet bool `json:"facet"`
+}
+
+// synonym mappings are read from documents in a collection in the same database
+type Synonyms struct {
+	Name       string `json:"name"`
+	Collection string `json:"collection"`
+	Analyzer   string `json:"analyzer"`
+}
+
+const (
+	BackendAtlas = "atlas"
+	BackendLocal = "local"
+)
+
+type Search struct {
+	// atlas (an atlas search index on the content collection) or local (an index file on disk)
+	Backend string `json:"backend"`
+	// location of the local index file
+	Path string `json:"path"`
+}
+
+type Dedup struct {
+	// off, skip (don't store near duplicates) or link (store them with duplicate_of set)
+	Mode string `json:"mode"`
+	// simhash fingerprints this many bits apart or closer count as duplicates
+	MaxDistance int `json:"max_distance"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Normaliser: utils.Normaliser{
+			Lowercase:        true,
+			StripPunctuation: true,
+		},
+		Analyzer: "lucene.english",
+		Index: Index{
+			Name: "search_index",
+			Fields: map[string]IndexField{
+				"title":    {Autocomplete: true},
+				"content":  {},
+				"anchors":  {},
+				"search":   {Analyzer: "lucene.whitespace"},
+				"host":     {Keyword: true, Facet: true},
+				"language": {Keyword: true, Facet: true},
+			},
+			WaitTimeout: Duration(10 * time.Minute),
+		},
+		Dedup: Dedup{
+			Mode:        DedupLink,
+			MaxDistance: 3,
+		},
+		Search: Search{
+			Backend: BackendAtlas,
+			Path:    "crawler.index",
+		},
+		Log: Log{
+			Level:  "info",
+			Format: LogText,
+		},
+		Frontier: Frontier{
+			Strategy: StrategyBFS,
+			Sitemap:  1,
+			Inlinks:  0.5,
+			Depth:    0.1,
+			Window:   100000,
+			Expected: 10000000,
+		},
+		Workers: Workers{
+			Store:       LeasesMongo,
+			Path:        "crawler.leases",
+			TTL:         Duration(2 * time.Minute),
+			Heartbeat:   Duration(30 * time.Second),
+			Concurrency: 10,
+		},
+		Archive: ArchiveConfig{
+			Path: "crawler.archive",
+		},
+		HTTP: HTTP{
+			Mode:      HTTPLive,
+			Cassettes: "crawler.cassettes",
+		},
+		Render: Render{
+			Concurrency: 4,
+			Timeout:     Duration(30 * time.Second),
+		},
+		Server: Server{
+			Addr: ":8080",
+		},
+	}
+}
+
+// a missing config file isn't an error, the defaults are used instead
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+
+	file, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+
+	// fields listed in the file replace the default ones rather than merging into them
+	defaultFields := config.Index.Fields
+	config.Index.Fields = nil
+
+	if err := json.Unmarshal(file, &config); err != nil {
+		return config, err
+	}
+
+	if config.Index.Fields == nil {
+		config.Index.Fields = defaultFields
+	}
+
+	switch config.Dedup.Mode {
+	case DedupOff, DedupSkip, DedupLink:
+	default:
+		return config, fmt.Errorf("unknown dedup mode %s", config.Dedup.Mode)
+	}
+
+	switch config.Search.Backend {
+	case BackendAtlas, BackendLocal:
+	default:
+		return config, fmt.Errorf("unknown search backend %s", config.Search.Backend)
+	}
+
+	if _, err := parseLevel(config.Log.Level); err != nil {
+		return config, err
+	}
+
+	switch config.Log.Format {
+	case LogText, LogJSON:
+	default:
+		return config, fmt.Errorf("unknown log format %s", config.Log.Format)
+	}
+
+	switch config.Frontier.Strategy {
+	case StrategyBFS, StrategyDFS, StrategyPriority:
+	default:
+		return config, fmt.Errorf("unknown frontier strategy %s", config.Frontier.Strategy)
+	}
+
+	if _, err := config.Frontier.patterns(); err != nil {
+		return config, err
+	}
+
+	// a heap has to see every url to know which is best, so it can't be spilled
+	if config.Frontier.Dir != "" && config.Frontier.Strategy == StrategyPriority {
+		return config, errors.New("the priority frontier can't be spilled to disk, use bfs or dfs with dir")
+	}
+
+	switch config.Workers.Store {
+	case LeasesMongo, LeasesFile:
+	default:
+		return config, fmt.Errorf("unknown lease store %s", config.Workers.Store)
+	}
+
+	if config.Workers.Heartbeat <= 0 || config.Workers.Heartbeat >= config.Workers.TTL {
+		return config, errors.New("workers heartbeat has to be positive and shorter than the ttl")
+	}
+
+	if config.Workers.Concurrency < 1 {
+		return config, errors.New("workers concurrency has to be at least 1")
+	}
+
+	if strings.Contains(config.Workers.Run, "/") {
+		return config, errors.New("workers run can't contain a slash")
+	}
+
+	switch config.Archive.Store {
+	case "", ArchiveDisk, ArchiveGridFS:
+	default:
+		return config, fmt.Errorf("unknown archive store %s", config.Archive.Store)
+	}
+
+	switch config.HTTP.Mode {
+	case HTTPLive, HTTPRecord, HTTPReplay:
+	default:
+		return config, fmt.Errorf("unknown http mode %s", config.HTTP.Mode)
+	}
+
+	if config.Render.Concurrency < 1 {
+		return config, errors.New("render concurrency has to be at least 1")
+	}
+
+	if config.Render.Timeout <= 0 {
+		return config, errors.New("render timeout has to be positive")
+	}
+
+	if _, err := utils.CompileProfiles(config.Profiles); err != nil {
+		return config, err
+	}
+
+	for _, sink := range config.Sinks {
+		switch sink.Format {
+		case FormatJSONL, FormatParquet, FormatWARC:
+		default:
+			return config, fmt.Errorf("unknown sink format %s", sink.Format)
+		}
+
+		if sink.Path == "" {
+			return config, fmt.Errorf("%s sink has no path", sink.Format)
+		}
+	}
+
+	return config, nil
+}
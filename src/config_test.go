@@ -0,0 +1,206 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// loads a config file holding input
+func loadConfig(t *testing.T, input string) (Config, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "crawler.json")
+	if err := os.WriteFile(path, []byte(input), 0o600); err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	return LoadConfig(path)
+}
+
+func TestLoadConfig(t *testing.T) {
+	config, err := loadConfig(t, `{"analyzer": "lucene.english", "index": {"fields": {"content": {}}, "wait": true, "wait_timeout": "90s"}}`)
+	if err != nil {
+		t.Errorf("LoadConfig: test case 1 failed, unexpected error: %v", err)
+	}
+
+	if config.Analyzer != "lucene.english" {
+		t.Errorf("LoadConfig: test case 2 failed, %s != %s", config.Analyzer, "lucene.english")
+	}
+
+	if len(config.Index.Fields) != 1 {
+		t.Errorf("LoadConfig: test case 3 failed, fields weren't replaced: %v", config.Index.Fields)
+	}
+
+	if config.Index.Name != "search_index" {
+		t.Errorf("LoadConfig: test case 4 failed, %s != %s", config.Index.Name, "search_index")
+	}
+
+	if !config.Index.Wait || time.Duration(config.Index.WaitTimeout) != 90*time.Second {
+		t.Errorf("LoadConfig: test case 6 failed, %v != %v", time.Duration(config.Index.WaitTimeout), 90*time.Second)
+	}
+
+	config, err = LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || len(config.Index.Fields) != len(DefaultConfig().Index.Fields) {
+		t.Errorf("LoadConfig: test case 5 failed, defaults not used: %v", err)
+	}
+}
+
+// each section of the config is checked as it's loaded, so a bad value fails before anything runs
+func TestLoadConfigSections(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		valid bool
+	}{
+		{
+			name:  "LoadConfig archive: test case 1",
+			input: `{"archive": {"store": "disk", "path": "/tmp/archive"}}`,
+			valid: true,
+		},
+		{
+			name:  "LoadConfig archive: test case 2",
+			input: `{"archive": {"store": "gridfs"}}`,
+			valid: true,
+		},
+		{
+			name:  "LoadConfig archive: test case 3",
+			input: `{"archive": {"store": "s3"}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig http: test case 1",
+			input: `{"http": {"mode": "record", "cassettes": "testdata/cassettes"}}`,
+			valid: true,
+		},
+		{
+			name:  "LoadConfig http: test case 2",
+			input: `{"http": {"mode": "replay"}}`,
+			valid: true,
+		},
+		{
+			name:  "LoadConfig http: test case 3",
+			input: `{"http": {"mode": "proxy"}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig render: test case 1",
+			input: `{"render": {"hosts": ["app.example.com"], "chrome": "/usr/bin/chromium", "concurrency": 2, "timeout": "15s"}}`,
+			valid: true,
+		},
+		{
+			name:  "LoadConfig render: test case 2",
+			input: `{"render": {"concurrency": 0}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig render: test case 3",
+			input: `{"render": {"timeout": "0s"}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig profiles: test case 1",
+			input: `{"profiles": [{"match": "^https://news\\.example\\.com/", "title": "h1", "body": "article p", "date": "time", "author": ".byline", "exclude": ".ad", "links": "main"}]}`,
+			valid: true,
+		},
+		{
+			name:  "LoadConfig profiles: test case 2",
+			input: `{"profiles": [{"match": "[", "body": "article"}]}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig profiles: test case 3",
+			input: `{"profiles": [{"match": ".*", "body": "article >"}]}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig frontier: test case 1",
+			input: `{"frontier": {"strategy": "priority", "budget": 100, "patterns": [{"match": "^https://[^/]+/docs/", "weight": 2}]}}`,
+			valid: true,
+		},
+		{
+			name:  "LoadConfig frontier: test case 2",
+			input: `{"frontier": {"strategy": "random"}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig frontier: test case 3",
+			input: `{"frontier": {"strategy": "priority", "patterns": [{"match": "(", "weight": 1}]}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig frontier: test case 4",
+			input: `{"frontier": {"strategy": "dfs", "dir": "/tmp/frontier", "window": 1000}}`,
+			valid: true,
+		},
+		{
+			name:  "LoadConfig frontier: test case 5",
+			input: `{"frontier": {"strategy": "priority", "dir": "/tmp/frontier"}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig workers: test case 1",
+			input: `{"workers": {"store": "file", "path": "/tmp/leases.json", "ttl": "1m", "heartbeat": "10s", "concurrency": 4}}`,
+			valid: true,
+		},
+		{
+			name:  "LoadConfig workers: test case 2",
+			input: `{"workers": {"store": "redis"}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig workers: test case 3",
+			input: `{"workers": {"ttl": "30s", "heartbeat": "30s"}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig workers: test case 4",
+			input: `{"workers": {"concurrency": 0}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig workers: test case 5",
+			input: `{"workers": {"run": "2026/10"}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig log: test case 1",
+			input: `{"log": {"level": "debug", "format": "json"}}`,
+			valid: true,
+		},
+		{
+			name:  "LoadConfig log: test case 2",
+			input: `{"log": {"level": "loud"}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig log: test case 3",
+			input: `{"log": {"format": "xml"}}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig sinks: test case 1",
+			input: `{"sinks": [{"format": "jsonl", "path": "pages.jsonl.gz"}, {"format": "warc", "path": "pages.warc.gz"}]}`,
+			valid: true,
+		},
+		{
+			name:  "LoadConfig sinks: test case 2",
+			input: `{"sinks": [{"format": "csv", "path": "pages.csv"}]}`,
+			valid: false,
+		},
+		{
+			name:  "LoadConfig sinks: test case 3",
+			input: `{"sinks": [{"format": "parquet"}]}`,
+			valid: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, err := loadConfig(t, testCase.input); (err == nil) != testCase.valid {
+				t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+			}
+		})
+	}
+}
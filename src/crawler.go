@@ -4,233 +4,539 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/url"
-	"regexp"
 	"slices"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
+	"github.com/junwei890/crawler/search"
 	"github.com/junwei890/crawler/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func StartCrawl(dbURI string, links []string) error {
+func StartCrawl(dbURI string, links []string, config Config) (summary Summary, err error) {
+	summary, err = newSummary(links, config)
+	if err != nil {
+		return summary, err
+	}
+
 	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(dbURI))
 	if err != nil {
-		return err
+		return summary, err
 	}
 
 	defer client.Disconnect(context.TODO())
 
-	// doesn't actually get created till something is inserted
+	// don't actually get created till something is inserted
 	db := client.Database("crawler")
-	collection := db.Collection("content")
+
+	// the run is recorded however it ends, so failed runs can be looked into too
+	defer func() {
+		summary.EndedAt = time.Now().UTC()
+		if err != nil {
+			summary.Error = err.Error()
+		}
+
+		if recordErr := recordRun(db, summary); recordErr != nil {
+			slog.Error("couldn't record run", "run", summary.ID.Hex(), "error", recordErr)
+		}
+	}()
+
+	r, err := newRun(db, config)
+	if err != nil {
+		return summary, err
+	}
+	defer r.close()
 
 	wg := &sync.WaitGroup{}
-	channel := make(chan struct{}, 1000)
+	channel := make(chan struct{}, maxCrawlers)
+	r.metrics.crawlerSlots.Set(maxCrawlers)
+
+	stopMetrics := serveMetrics(config.Metrics.Addr)
+	defer stopMetrics()
 
-	for _, link := range links {
+	for i, link := range links {
 		wg.Add(1)
 		channel <- struct{}{}
 
-		go func(link string, db *mongo.Collection) {
+		go func(link string, stats *Stats) {
+			r.metrics.activeCrawlers.Inc()
 			defer func() {
+				r.metrics.activeCrawlers.Dec()
 				<-channel
 				wg.Done()
 			}()
 
-			if err := crawler(link, collection); err != nil {
-				log.Println(err)
+			if err := crawler(context.Background(), link, r, stats); err != nil {
+				slog.Error("didn't crawl site", "seed", link, "error", err)
 			}
-		}(link, collection)
+		}(link, summary.Sites[i])
 	}
 	wg.Wait()
 
-	// check if anything was inserted into the collection before indexing
-	names, err := client.ListDatabaseNames(context.TODO(), bson.D{})
+	summary.Index, err = finishRun(client, db, r)
+
+	return summary, err
+}
+
+// crawls one site into store on its own, without the archive, sinks, search index or metrics of a full run
+func CrawlSite(ctx context.Context, seed string, config Config, store Store) (*Stats, error) {
+	stats := NewStats(seed)
+
+	profiles, err := utils.CompileProfiles(config.Profiles)
 	if err != nil {
-		return err
+		return stats, err
 	}
-	if ok := slices.Contains(names, "crawler"); !ok {
-		return errors.New("no sites were crawled")
+
+	renderer, err := config.Render.open()
+	if err != nil {
+		return stats, err
+	}
+
+	r := &run{
+		config:       config,
+		store:        store,
+		fingerprints: utils.NewFingerprintIndex(config.Dedup.MaxDistance),
+		sinks:        &sinks{},
+		renderer:     renderer,
+		profiles:     profiles,
+		bloom:        config.Frontier.bloomFilter(),
+		metrics:      newMetrics(),
 	}
+	defer r.close()
 
-	// create index if it doesn't exist, update it if it does
-	indexName := "search_index"
-	opts := options.SearchIndexes().SetName(indexName).SetType("search")
+	return stats, crawler(ctx, seed, r, stats)
+}
+
+// state shared by every site's crawler in a run
+type run struct {
+	config       Config
+	store        Store
+	fingerprints *utils.FingerprintIndex
+	// nil unless the local search backend is in use
+	local *search.Index
+	sinks *sinks
+	// nil unless archiving is on
+	archive Archive
+	// nil unless some sites are rendered
+	renderer utils.Renderer
+	profiles utils.Profiles
+	// nil unless the frontier spills to disk, shared by every site's seen set so its memory is paid once
+	bloom   *utils.BloomFilter
+	metrics *metrics
+	// robots.txt and crawl delays of other hosts that rendered pages send requests to
+	hosts hostRules
+}
 
-	cursor, err := collection.SearchIndexes().List(context.TODO(), opts)
+func newRun(db *mongo.Database, config Config) (*run, error) {
+	profiles, err := utils.CompileProfiles(config.Profiles)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer cursor.Close(context.TODO())
 
-	exists := false
-	for cursor.Next(context.TODO()) {
-		var indexMap bson.M
-		if err := cursor.Decode(&indexMap); err != nil {
-			return err
-		}
+	r := &run{
+		config: config,
+		store:  NewMongoStore(db),
+		// fingerprints are shared across sites so mirrors on different hosts get caught too
+		fingerprints: utils.NewFingerprintIndex(config.Dedup.MaxDistance),
+		profiles:     profiles,
+		bloom:        config.Frontier.bloomFilter(),
+		metrics:      defaultMetrics,
+	}
 
-		if indexMap["name"] == indexName {
-			exists = true
+	// the local index builds on whatever earlier runs left on disk
+	if config.Search.Backend == BackendLocal {
+		local, err := search.Load(config.Search.Path)
+		if err != nil {
+			return nil, err
 		}
+		r.local = local
 	}
 
-	searchIndexModel := mongo.SearchIndexModel{
-		Definition: bson.D{
-			{Key: "mappings", Value: bson.D{
-				{Key: "dynamic", Value: false},
-				{Key: "fields", Value: bson.D{
-					{Key: "content", Value: bson.D{
-						{Key: "type", Value: "string"},
-					}},
-				}},
-			}},
-		},
-		Options: opts,
+	archive, err := OpenArchive(db, config.Archive)
+	if err != nil {
+		return nil, err
 	}
+	r.archive = archive
 
-	if exists {
-		if err := collection.SearchIndexes().UpdateOne(context.TODO(), indexName, searchIndexModel.Definition); err != nil {
-			return err
+	sinks, err := openSinks(config.Sinks)
+	if err != nil {
+		return nil, err
+	}
+	r.sinks = sinks
+
+	renderer, err := config.Render.open()
+	if err != nil {
+		r.close()
+		return nil, err
+	}
+	r.renderer = renderer
+
+	return r, nil
+}
+
+// closing the sinks is what makes parquet files readable, so a failure here is worth shouting about
+func (r *run) close() {
+	if err := r.sinks.Close(); err != nil {
+		slog.Error("couldn't close sinks", "error", err)
+	}
+
+	if r.renderer != nil {
+		if err := r.renderer.Close(); err != nil {
+			slog.Error("couldn't close the renderer", "error", err)
 		}
-	} else {
-		if _, err := collection.SearchIndexes().CreateOne(context.TODO(), searchIndexModel); err != nil {
-			return err
+	}
+}
+
+// once every site is crawled, inbound anchors are aggregated and the search index is built
+func finishRun(client *mongo.Client, db *mongo.Database, r *run) (IndexStatus, error) {
+	// check if anything was inserted into the collection before indexing
+	names, err := client.ListDatabaseNames(context.TODO(), bson.D{})
+	if err != nil {
+		return IndexStatus{}, err
+	}
+	if ok := slices.Contains(names, "crawler"); !ok {
+		return IndexStatus{}, errors.New("no sites were crawled")
+	}
+
+	if err := AggregateAnchors(db); err != nil {
+		return IndexStatus{}, err
+	}
+
+	if r.config.Search.Backend == BackendLocal {
+		status := IndexStatus{
+			Name:      r.config.Search.Path,
+			Status:    IndexLocal,
+			Queryable: true,
 		}
+
+		return status, r.local.Save(r.config.Search.Path)
 	}
 
-	return nil
+	return createSearchIndex(db.Collection("content"), r.config)
 }
 
+// content keeps the text as it appeared on the page, search holds the normalised form
 type Content struct {
-	URL     string `bson:"_id"`
-	Title   string `bson:"title"`
-	Content string `bson:"content"`
+	URL        string           `bson:"_id" json:"url"`
+	Host       string           `bson:"host" json:"host"`
+	Language   string           `bson:"language,omitempty" json:"language,omitempty"`
+	Title      string           `bson:"title" json:"title"`
+	Content    string           `bson:"content" json:"content"`
+	Search     string           `bson:"search" json:"search"`
+	Structured []map[string]any `bson:"structured,omitempty" json:"structured,omitempty"`
+	// as written on the page, only found on sites with an extraction profile
+	Date   string `bson:"date,omitempty" json:"date,omitempty"`
+	Author string `bson:"author,omitempty" json:"author,omitempty"`
+	// simhash of the search field, stored as int64 since bson has no unsigned type
+	Fingerprint int64  `bson:"fingerprint" json:"fingerprint"`
+	DuplicateOf string `bson:"duplicate_of,omitempty" json:"duplicate_of,omitempty"`
+	// anchor text of inbound links, aggregated once every site is crawled
+	Anchors []string `bson:"anchors,omitempty" json:"anchors,omitempty"`
+	// written by the offline ranking job
+	PageRank float64 `bson:"pagerank,omitempty" json:"pagerank,omitempty"`
+	InDegree int     `bson:"in_degree,omitempty" json:"in_degree,omitempty"`
+	Fetch    Fetch   `bson:"fetch" json:"fetch"`
+	// key of the page as fetched in the archive, if archiving was on
+	Archive string `bson:"archive,omitempty" json:"archive,omitempty"`
+}
+
+// how a page was fetched
+type Fetch struct {
+	Status    int    `bson:"status" json:"status"`
+	MediaType string `bson:"media_type" json:"media_type"`
+	// content encoding the page was sent with, before it was decoded
+	Encoding  string    `bson:"encoding,omitempty" json:"encoding,omitempty"`
+	WireBytes int64     `bson:"wire_bytes" json:"wire_bytes"`
+	Bytes     int64     `bson:"bytes" json:"bytes"`
+	FetchedAt time.Time `bson:"fetched_at" json:"fetched_at"`
+	LatencyMS int64     `bson:"latency_ms" json:"latency_ms"`
+	// links away from the seed
+	Depth int `bson:"depth" json:"depth"`
+	// extracted from the dom a headless browser ended up with rather than the html as fetched
+	Rendered bool `bson:"rendered,omitempty" json:"rendered,omitempty"`
 }
 
-func crawler(startURL string, collection *mongo.Collection) error {
+// pages with less normalised text than this aren't stored
+const minContentLength = 500
+
+// crawls a site until its frontier runs dry or ctx is cancelled, whatever was crawled is stored either way
+func crawler(ctx context.Context, startURL string, r *run, stats *Stats) error {
 	// get and parse robots.txt file first
 	file, err := utils.GetRobots(startURL)
 	if err != nil {
-		return fmt.Errorf("didn't crawl %s: %v", startURL, err)
+		stats.fail(ErrClassRobots)
+		return fmt.Errorf("robots.txt: %w", err)
 	}
 
 	normURL, err := utils.Normalize(startURL)
 	if err != nil {
-		return fmt.Errorf("didn't crawl %s: %v", startURL, err)
+		stats.fail(ErrClassInvalidURL)
+		return err
 	}
 
 	rules, err := utils.ParseRobots(normURL, file)
 	if err != nil {
-		return fmt.Errorf("didn't crawl %s: %v", startURL, err)
+		stats.fail(ErrClassRobots)
+		return fmt.Errorf("robots.txt: %w", err)
 	}
 
 	dom, err := url.Parse(startURL)
 	if err != nil {
-		return fmt.Errorf("didn't crawl %s: %v", startURL, err)
+		stats.fail(ErrClassInvalidURL)
+		return err
 	}
 
-	visited := map[string]struct{}{}
-	queue := &utils.Queue{}
-	content := []any{}
+	host := dom.Hostname()
+	// per host series go once the site is done, so a long running worker doesn't pile them up
+	defer r.metrics.frontierSize.DeleteLabelValues(host)
+	defer r.metrics.fetchDuration.DeleteLabelValues(host)
+	defer r.metrics.robotsDenials.DeleteLabelValues(host)
+
+	// every line logged for this site carries its seed
+	logger := slog.Default().With("seed", startURL)
 
-	// if id already exists in the collection don't error and continue inserting
-	options := options.InsertMany().SetOrdered(false)
+	render := r.renderer != nil && slices.Contains(r.config.Render.Hosts, host)
 
-	queue.Enqueue(startURL)
+	// sitemaps are fetched on the same turns as pages, so they wait out the crawl delay too
+	throttle := utils.NewThrottle(time.Duration(rules.Delay) * time.Second)
 
-	re, err := regexp.Compile(`[^a-zA-Z0-9 ]+`)
+	sitemaps := []utils.SitemapURL{}
+	if r.config.Frontier.usesSitemaps() {
+		sitemaps = utils.GetSitemaps(ctx, startURL, rules.Sitemaps, throttle)
+		logger.Debug("read sitemaps", "urls", len(sitemaps))
+	}
+
+	frontier, visited, closeState, err := newSiteState(r.config.Frontier, r.bloom, host, sitemaps)
 	if err != nil {
-		return fmt.Errorf("didn't crawl %s: %v", startURL, err)
+		return err
+	}
+	defer func() {
+		if err := closeState(); err != nil {
+			logger.Error("couldn't clean up the frontier", "error", err)
+		}
+	}()
+
+	content := []Content{}
+	edges := []Edge{}
+	requests := 0
+
+	// what a rendered page asks for waits its turn and obeys robots.txt like the crawler's own requests
+	var gate utils.RequestGate
+	if render {
+		gate = r.renderGate(dom, rules, throttle, logger)
 	}
 
-	for {
-		// early returns
-		if comp := queue.CheckEmpty(); comp {
+	// links are checked as they're found, so everything in the frontier is in scope, allowed and new
+	admission := &admission{
+		domain:   dom,
+		rules:    rules,
+		seen:     visited,
+		frontier: frontier,
+		stats:    stats,
+		logger:   logger,
+		metrics:  r.metrics,
+	}
+
+	admission.admit(utils.Item{URL: startURL})
+	for _, sitemap := range sitemaps {
+		admission.admit(utils.Item{URL: sitemap.Loc, Depth: 1})
+	}
+
+	for frontier.Len() > 0 {
+		if ctx.Err() != nil {
+			logger.Info("crawl stopped", "remaining", frontier.Len(), "error", ctx.Err())
 			break
 		}
 
-		popped, err := queue.Dequeue()
-		if err != nil {
-			return err
+		// the budget runs out on requests made, so failing pages count towards it too
+		if budget := r.config.Frontier.Budget; budget > 0 && requests >= budget {
+			logger.Info("crawl budget spent", "budget", budget, "remaining", frontier.Len())
+			break
 		}
 
-		ok, err := utils.CheckDomain(dom, popped)
+		// a frontier that can't be read stops the site, what it crawled so far is still stored below
+		item, err := frontier.Pop()
 		if err != nil {
-			log.Println(fmt.Errorf("didn't crawl %s: %v", popped, err).Error())
-			continue
+			stats.fail(ErrClassStorage)
+			logger.Error("couldn't read the frontier, stopping early", "error_class", ErrClassStorage, "remaining", frontier.Len(), "error", err)
+			break
 		}
-		if !ok {
+		r.metrics.frontierSize.WithLabelValues(host).Set(float64(frontier.Len()))
+
+		popped := item.URL
+		pageLogger := logger.With("url", popped, "depth", item.Depth)
+
+		// a stopped crawl is logged at the top of the loop
+		if err := throttle.Wait(ctx); err != nil {
 			continue
 		}
 
-		currURL, err := utils.Normalize(popped)
+		requests++
+		started := time.Now()
+		fetch := utils.GetPage
+		if r.sinks.raw {
+			fetch = utils.GetRawPage
+		}
+		page, err := fetch(popped)
+		r.metrics.fetchDuration.WithLabelValues(host).Observe(time.Since(started).Seconds())
+		status := statusLabel(page, err)
+		r.metrics.fetches.WithLabelValues(status).Inc()
+		pageLogger = pageLogger.With("status", status)
 		if err != nil {
-			log.Println(fmt.Errorf("didn't crawl %s: %v", popped, err).Error())
+			class := classifyError(err)
+			stats.fail(class)
+			pageLogger.Warn("didn't crawl page", "error_class", class, "error", err)
 			continue
 		}
 
-		ok = utils.CheckAbility(visited, rules, currURL)
-		if !ok {
-			continue
+		latency := time.Since(started)
+		stats.observe(latency)
+		stats.WireBytes += page.WireBytes
+		stats.Bytes += int64(len(page.Body))
+
+		fetched := Fetch{
+			Status:    page.Status,
+			MediaType: page.MediaType,
+			Encoding:  page.Encoding,
+			WireBytes: page.WireBytes,
+			Bytes:     int64(len(page.Body)),
+			FetchedAt: started.UTC(),
+			LatencyMS: latency.Milliseconds(),
+			Depth:     item.Depth,
 		}
 
-		// sleeping for crawl delay right before get request
-		subWg := &sync.WaitGroup{}
-		subWg.Add(1)
-		go func() {
-			defer subWg.Done()
+		// only extraction sees what the browser ends up with, the archive keeps the page as fetched
+		parsed := page
+		if render && page.MediaType == "text/html" {
+			rendered, err := r.renderer.Render(ctx, page, gate)
+			if err != nil {
+				stats.fail(ErrClassRender)
+				pageLogger.Warn("couldn't render page, parsing it as fetched", "error_class", ErrClassRender, "error", err)
+			} else {
+				parsed.Body = rendered
+				fetched.Rendered = true
+			}
+		}
 
-			time.Sleep(time.Duration(rules.Delay) * time.Second)
-		}()
+		// archived before extraction and transcoding, so pages extraction rejects or whose charset was
+		// guessed wrong can still be reprocessed later, and after rendering so reprocess knows to leave
+		// pages it can't rebuild alone
+		archived := ""
+		if r.archive != nil {
+			archived, err = r.archive.Put(Capture{URL: popped, Header: page.Header, Fetch: fetched, Body: page.Original})
+			if err != nil {
+				stats.fail(ErrClassStorage)
+				pageLogger.Warn("couldn't archive page", "error_class", ErrClassStorage, "error", err)
+			}
+		}
 
-		page, err := utils.GetHTML(popped)
+		res, err := r.profiles.Parse(dom, parsed)
 		if err != nil {
-			log.Println(fmt.Errorf("didn't crawl %s: %v", popped, err).Error())
+			stats.fail(ErrClassParse)
+			pageLogger.Warn("didn't crawl page", "error_class", ErrClassParse, "error", err)
 			continue
 		}
 
-		res, err := utils.ParseHTML(dom, page)
-		if err != nil {
-			log.Println(fmt.Errorf("didn't crawl %s: %v", popped, err).Error())
+		// every link goes into the graph, but only those a profile follows go into the frontier
+		for _, link := range res.Links {
+			if !link.Unfollowed {
+				admission.admit(utils.Item{URL: link.URL, Depth: item.Depth + 1})
+			}
+			edges = append(edges, NewEdge(popped, link))
+		}
+		r.metrics.frontierSize.WithLabelValues(host).Set(float64(frontier.Len()))
+
+		raw := strings.Join(res.Content, " ")
+		cleaned := r.config.Normaliser.Normalise(raw)
+		if utf8.RuneCountInString(cleaned) < minContentLength {
+			stats.TooShort++
+			pageLogger.Debug("skipped page", "reason", "too_short")
 			continue
 		}
 
-		for _, link := range res.Links {
-			queue.Enqueue(link)
+		fingerprint := utils.SimHash(cleaned)
+		duplicateOf := ""
+		if r.config.Dedup.Mode != DedupOff {
+			if original, ok := r.fingerprints.CheckAndAdd(popped, fingerprint); ok {
+				if r.config.Dedup.Mode == DedupSkip {
+					stats.Duplicates++
+					pageLogger.Debug("skipped page", "reason", "duplicate", "duplicate_of", original)
+					continue
+				}
+				duplicateOf = original
+			}
 		}
 
-		slice := []string{}
-		for _, content := range res.Content {
-			slice = append(slice, re.ReplaceAllString(content, ""))
+		pageLogger.Info("crawled page")
+		stats.Stored++
+
+		stored := Content{
+			URL:         popped,
+			Host:        host,
+			Language:    res.Language,
+			Title:       res.Title,
+			Content:     raw,
+			Search:      cleaned,
+			Structured:  res.Structured,
+			Date:        res.Date,
+			Author:      res.Author,
+			Fingerprint: int64(fingerprint),
+			DuplicateOf: duplicateOf,
+			Fetch:       fetched,
+			Archive:     archived,
 		}
+		content = append(content, stored)
 
-		cleaned := strings.Join(slice, " ")
-		if len(cleaned) < 500 {
-			continue
+		if err := r.sinks.Write(Record{Content: stored, Request: page.Request, Response: page.Response}); err != nil {
+			stats.fail(ErrClassStorage)
+			pageLogger.Warn("couldn't write page to sinks", "error_class", ErrClassStorage, "error", err)
 		}
 
-		log.Printf("crawled: %s", popped)
+		if r.local != nil && duplicateOf == "" {
+			r.local.Add(search.Document{
+				URL:     popped,
+				Title:   res.Title,
+				Content: raw,
+			})
+		}
+	}
 
-		content = append(content, Content{
-			URL:     popped,
-			Title:   res.Title,
-			Content: cleaned,
-		})
+	logger.Info("finished site", "stats", stats)
 
-		// wait for sleep to finish before proceeding
-		subWg.Wait()
+	// a site where nothing was long enough has nothing to insert
+	if len(content) > 0 {
+		if err := r.store.SaveContent(content); err != nil {
+			stats.fail(ErrClassStorage)
+			return err
+		}
 	}
 
-	if _, err := collection.InsertMany(context.TODO(), content, options); err != nil {
-		return err
+	// pages go in first and a failed links insert is only logged, so the graph can't cost a site its pages
+	if len(edges) > 0 {
+		if err := r.store.SaveLinks(edges); err != nil {
+			stats.fail(ErrClassStorage)
+			logger.Error("couldn't store links", "links", len(edges), "error_class", ErrClassStorage, "error", err)
+		}
 	}
 
 	return nil
 }
+
+// a batch insert timed for the storage metrics, duplicate keys are expected on reruns so aren't counted as errors
+func insertBatch(metrics *metrics, collection *mongo.Collection, documents []any, opts *options.InsertManyOptions) error {
+	started := time.Now()
+	_, err := collection.InsertMany(context.TODO(), documents, opts)
+	metrics.storageDuration.WithLabelValues(collection.Name()).Observe(time.Since(started).Seconds())
+
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		metrics.storageErrors.WithLabelValues(collection.Name()).Inc()
+	}
+
+	return err
+}
@@ -0,0 +1,421 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/junwei890/crawler/internal/sitetest"
+	"github.com/junwei890/crawler/utils"
+)
+
+// a small site with robots.txt, a redirect, a missing page, a short page and an off site link
+func newFixtureSite(t *testing.T) *sitetest.Server {
+	page := func(topic string, links ...string) sitetest.Page {
+		return sitetest.Page{Title: topic, Text: sitetest.Words(topic), Links: links}
+	}
+
+	return sitetest.Serve(t, sitetest.Site{
+		Pages: map[string]sitetest.Page{
+			"/":               page("home", "/a", "/b", "/private/secret", "/moved", "/missing", "/short", "https://elsewhere.example.com/x"),
+			"/a":              page("apple", "/b", "/"),
+			"/b":              page("banana"),
+			"/c":              page("cherry", "/a"),
+			"/private/secret": page("secret"),
+			"/moved":          {Redirect: "/c"},
+			"/short":          {Body: []byte(`<html><title>Short</title><p>too short</p></html>`)},
+		},
+		Robots: "User-agent: *\nDisallow: /private\n",
+	})
+}
+
+// a run that stores into memory instead of mongo
+func newMemoryRun(config Config) (*run, *MemoryStore) {
+	store := NewMemoryStore()
+	return &run{
+		config:       config,
+		store:        store,
+		fingerprints: utils.NewFingerprintIndex(config.Dedup.MaxDistance),
+		sinks:        &sinks{},
+		bloom:        config.Frontier.bloomFilter(),
+		metrics:      newMetrics(),
+	}, store
+}
+
+// urls of the stored pages, in the order they were crawled
+func storedURLs(store *MemoryStore) []string {
+	urls := []string{}
+	for _, content := range store.Content() {
+		urls = append(urls, content.URL)
+	}
+
+	return urls
+}
+
+// timings differ from one crawl to the next, everything else should come out the same
+func withoutTimings(content []Content) []Content {
+	result := []Content{}
+	for _, page := range content {
+		page.Fetch.FetchedAt = time.Time{}
+		page.Fetch.LatencyMS = 0
+		result = append(result, page)
+	}
+
+	return result
+}
+
+func TestCrawlerFixture(t *testing.T) {
+	site := newFixtureSite(t)
+
+	r, store := newMemoryRun(DefaultConfig())
+	stats := NewStats(site.URL)
+	if err := crawler(t.Context(), site.URL, r, stats); err != nil {
+		t.Fatalf("Crawler: test case 1 failed, unexpected error: %v", err)
+	}
+
+	// the redirect is stored under the url that was linked to
+	expected := []string{site.URL, site.URL + "/a", site.URL + "/b", site.URL + "/moved"}
+	if urls := storedURLs(store); !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Crawler: test case 2 failed, %v != %v", urls, expected)
+	}
+
+	if moved := store.Content()[3]; moved.Title != "cherry" || moved.Fetch.Status != 200 || moved.Fetch.Depth != 1 {
+		t.Errorf("Crawler: test case 3 failed, unexpected content %+v", moved)
+	}
+
+	if stats.Fetched != 5 || stats.Stored != 4 || stats.TooShort != 1 || stats.Robots != 1 || stats.OffDomain != 1 || stats.Errors["http_404"] != 1 {
+		t.Errorf("Crawler: test case 4 failed, unexpected stats %+v", stats)
+	}
+
+	// disallowed pages are never requested, and nothing is requested twice
+	if site.Count("/private/secret") != 0 || site.Count("/") != 1 || site.Count("/a") != 1 || site.Count("/robots.txt") != 1 {
+		t.Errorf("Crawler: test case 5 failed, unexpected requests %v", site.Requests())
+	}
+
+	// every link found is stored, including ones that weren't crawled
+	if links := store.Links(); len(links) != 10 {
+		t.Errorf("Crawler: test case 6 failed, %d != %d links", len(links), 10)
+	}
+}
+
+// a store whose links inserts always fail
+type linklessStore struct {
+	*MemoryStore
+}
+
+func (l linklessStore) SaveLinks(edges []Edge) error {
+	return errors.New("links collection unavailable")
+}
+
+func TestCrawlerLinksFailure(t *testing.T) {
+	site := newFixtureSite(t)
+
+	r, store := newMemoryRun(DefaultConfig())
+	r.store = linklessStore{MemoryStore: store}
+	stats := NewStats(site.URL)
+	if err := crawler(t.Context(), site.URL, r, stats); err != nil {
+		t.Fatalf("LinksFailure: test case 1 failed, unexpected error: %v", err)
+	}
+
+	// the pages are stored all the same, only the failure is counted
+	if content := store.Content(); len(content) != 4 || stats.Errors[ErrClassStorage] != 1 {
+		t.Errorf("LinksFailure: test case 2 failed, %d != %d pages stored, unexpected stats %+v", len(content), 4, stats)
+	}
+}
+
+func TestCrawlerFrontierFailure(t *testing.T) {
+	site := newFixtureSite(t)
+
+	config := DefaultConfig()
+	config.Frontier.Dir = t.TempDir()
+	config.Frontier.Window = 1
+
+	// every url is spilled as soon as it's queued, so taking the disk away while /a is fetched
+	// leaves the rest of the frontier unreadable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/a" {
+			os.RemoveAll(config.Frontier.Dir)
+		}
+		site.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	r, store := newMemoryRun(config)
+	stats := NewStats(server.URL)
+	if err := crawler(t.Context(), server.URL, r, stats); err != nil {
+		t.Fatalf("FrontierFailure: test case 1 failed, unexpected error: %v", err)
+	}
+
+	// what was crawled before the frontier broke is stored, and the failure is counted
+	expected := []string{server.URL, server.URL + "/a"}
+	if urls := storedURLs(store); !reflect.DeepEqual(urls, expected) {
+		t.Errorf("FrontierFailure: test case 2 failed, %v != %v", urls, expected)
+	}
+	if stats.Errors[ErrClassStorage] != 1 || len(store.Links()) == 0 {
+		t.Errorf("FrontierFailure: test case 3 failed, %d links stored, unexpected stats %+v", len(store.Links()), stats)
+	}
+}
+
+func TestCrawlerReplay(t *testing.T) {
+	site := newFixtureSite(t)
+	dir := t.TempDir()
+
+	transport := utils.Client.Transport
+	defer func() {
+		utils.Client.Transport = transport
+	}()
+
+	recorder, err := utils.NewRecorder(dir, nil)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	utils.Client.Transport = recorder
+
+	recorded, recordedStore := newMemoryRun(DefaultConfig())
+	recordedStats := NewStats(site.URL)
+	if err := crawler(t.Context(), site.URL, recorded, recordedStats); err != nil {
+		t.Fatalf("Replay: test case 1 failed, unexpected error: %v", err)
+	}
+
+	// nothing can reach the site from here on
+	site.Close()
+
+	replayer, err := utils.NewReplayer(dir)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	utils.Client.Transport = replayer
+
+	for i := range 2 {
+		replayed, replayedStore := newMemoryRun(DefaultConfig())
+		replayedStats := NewStats(site.URL)
+		if err := crawler(t.Context(), site.URL, replayed, replayedStats); err != nil {
+			t.Fatalf("Replay: test case %d failed, unexpected error: %v", i+2, err)
+		}
+
+		if result, expected := withoutTimings(replayedStore.Content()), withoutTimings(recordedStore.Content()); !reflect.DeepEqual(result, expected) {
+			t.Errorf("Replay: test case %d failed, %v != %v", i+2, storedURLs(replayedStore), storedURLs(recordedStore))
+		}
+
+		if !reflect.DeepEqual(replayedStore.Links(), recordedStore.Links()) || !reflect.DeepEqual(replayedStats.Errors, recordedStats.Errors) || replayedStats.Stored != recordedStats.Stored {
+			t.Errorf("Replay: test case %d failed, unexpected stats %+v != %+v", i+2, replayedStats, recordedStats)
+		}
+	}
+}
+
+// renders every page as the app it would become, or fails when err is set, asking the gate for
+// requests first like a page's scripts would
+type fakeRenderer struct {
+	mu       sync.Mutex
+	rendered []string
+	requests []string
+	blocked  []string
+	err      error
+}
+
+func (f *fakeRenderer) Render(ctx context.Context, fetched utils.Page, gate utils.RequestGate) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rendered = append(f.rendered, fetched.URL)
+	for _, request := range f.requests {
+		if err := gate(ctx, request); err != nil {
+			f.blocked = append(f.blocked, request)
+		}
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return []byte(sitetest.Render("/", sitetest.Page{Title: "rendered", Text: sitetest.Words("rendered")})), nil
+}
+
+func (f *fakeRenderer) Close() error {
+	return nil
+}
+
+func TestCrawlerRender(t *testing.T) {
+	// an app shell with nothing in it until its scripts run
+	shell := `<html><head><title>App</title></head><body><div id="app"></div><script src="/app.js"></script></body></html>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(shell))
+	}))
+	defer server.Close()
+
+	// a cdn that wants nothing crawled but its fonts
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("User-agent: *\nAllow: /fonts\nDisallow: /\n"))
+	}))
+	defer cdn.Close()
+
+	requests := []string{
+		server.URL + "/app.js",
+		server.URL + "/private/data.json",
+		cdn.URL + "/fonts/sans.woff2",
+		cdn.URL + "/lib.js",
+		"data:text/plain,hello",
+	}
+
+	testCases := []struct {
+		name     string
+		hosts    []string
+		err      error
+		rendered bool
+		stored   int
+		blocked  []string
+	}{
+		{
+			name:     "Render: test case 1",
+			hosts:    []string{"127.0.0.1"},
+			rendered: true,
+			stored:   1,
+			blocked:  []string{server.URL + "/private/data.json", cdn.URL + "/lib.js"},
+		},
+		{
+			name:     "Render: test case 2",
+			hosts:    []string{"app.example.com"},
+			rendered: false,
+			stored:   0,
+		},
+		{
+			name:     "Render: test case 3",
+			hosts:    []string{"127.0.0.1"},
+			err:      errors.New("chrome went away"),
+			rendered: true,
+			stored:   0,
+			blocked:  []string{server.URL + "/private/data.json", cdn.URL + "/lib.js"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		config := DefaultConfig()
+		config.Render.Hosts = testCase.hosts
+
+		archive, err := NewDiskArchive(t.TempDir())
+		if err != nil {
+			t.Fatalf("error setting up test, unexpected error: %v", err)
+		}
+
+		r, store := newMemoryRun(config)
+		r.archive = archive
+		renderer := &fakeRenderer{requests: requests, err: testCase.err}
+		r.renderer = renderer
+
+		stats := NewStats(server.URL)
+		if err := crawler(t.Context(), server.URL, r, stats); err != nil {
+			t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+			continue
+		}
+
+		if (len(renderer.rendered) > 0) != testCase.rendered {
+			t.Errorf("%s failed, rendered %v", testCase.name, renderer.rendered)
+		}
+		if !slices.Equal(renderer.blocked, testCase.blocked) {
+			t.Errorf("%s failed, %v != %v", testCase.name, renderer.blocked, testCase.blocked)
+		}
+
+		content := store.Content()
+		if len(content) != testCase.stored {
+			t.Errorf("%s failed, %d != %d pages stored", testCase.name, len(content), testCase.stored)
+			continue
+		}
+		if testCase.stored > 0 && (content[0].Title != "rendered" || !content[0].Fetch.Rendered) {
+			t.Errorf("%s failed, unexpected content %+v", testCase.name, content[0])
+		}
+
+		// the archive keeps the shell as fetched rather than what the browser made of it
+		if testCase.stored > 0 {
+			capture, err := archive.Get(content[0].Archive)
+			if err != nil || string(capture.Body) != shell {
+				t.Errorf("%s failed, %q != %q (%v)", testCase.name, capture.Body, shell, err)
+			}
+		}
+
+		// a page that couldn't be rendered is parsed as fetched
+		if testCase.err != nil && (stats.Errors[ErrClassRender] != 1 || stats.TooShort != 1) {
+			t.Errorf("%s failed, unexpected stats %+v", testCase.name, stats)
+		}
+
+		// reprocessing leaves a rendered page as stored instead of dropping it for its empty shell,
+		// one parsed as fetched is reprocessed like any other
+		emitted, dropped := []string{}, []string{}
+		err = reprocess(archive, config, NewStats("archive"), func([]Edge) {}, func(content Content) error {
+			emitted = append(emitted, content.URL)
+			return nil
+		}, func(url string) error {
+			dropped = append(dropped, url)
+			return nil
+		})
+		expected := []string{}
+		if !testCase.rendered || testCase.err != nil {
+			expected = []string{server.URL}
+		}
+		if err != nil || len(emitted) != 0 || !slices.Equal(dropped, expected) {
+			t.Errorf("%s failed, %v != %v (emitted %v, %v)", testCase.name, dropped, expected, emitted, err)
+		}
+	}
+}
+
+func TestCrawlerProfiles(t *testing.T) {
+	site := newFixtureSite(t)
+
+	// a profile for the apple page only, reading its text from the body and following none of its links
+	config := DefaultConfig()
+	config.Profiles = []utils.Profile{{Match: `/a$`, Title: "p", Body: "body", Author: "title", Links: "nav"}}
+
+	store := NewMemoryStore()
+	if _, err := CrawlSite(t.Context(), site.URL, config, store); err != nil {
+		t.Fatalf("CrawlerProfiles: test case 1 failed, unexpected error: %v", err)
+	}
+
+	content := store.Content()
+	if len(content) != 4 || content[1].URL != site.URL+"/a" {
+		t.Fatalf("CrawlerProfiles: test case 2 failed, %v stored", storedURLs(store))
+	}
+
+	apple := content[1]
+	if !strings.HasPrefix(apple.Title, "apple0 apple1") || apple.Author != "apple" || !strings.HasSuffix(apple.Content, "apple79 /b /") {
+		t.Errorf("CrawlerProfiles: test case 3 failed, unexpected content %+v", apple)
+	}
+	if content[0].Author != "" || content[0].Title != "home" {
+		t.Errorf("CrawlerProfiles: test case 4 failed, unexpected content %+v", content[0])
+	}
+
+	// links the profile doesn't follow are still in the graph
+	links := []string{}
+	for _, link := range store.Links() {
+		if link.From == apple.URL {
+			links = append(links, link.To)
+		}
+	}
+	slices.Sort(links)
+	if !reflect.DeepEqual(links, []string{site.URL + "/", site.URL + "/b"}) {
+		t.Errorf("CrawlerProfiles: test case 5 failed, %v != %v", links, []string{site.URL + "/", site.URL + "/b"})
+	}
+
+	// with the seed following none of its links, nothing past it is crawled
+	config.Profiles = []utils.Profile{{Match: `^` + regexp.QuoteMeta(site.URL) + `/?$`, Links: "nav"}}
+	store = NewMemoryStore()
+	if _, err := CrawlSite(t.Context(), site.URL, config, store); err != nil {
+		t.Fatalf("CrawlerProfiles: test case 6 failed, unexpected error: %v", err)
+	}
+	if len(store.Content()) != 1 || len(store.Links()) != 7 {
+		t.Errorf("CrawlerProfiles: test case 7 failed, %v stored with %d links", storedURLs(store), len(store.Links()))
+	}
+}
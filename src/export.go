@@ -0,0 +1,66 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// writes every page in the content collection to a file, returning how many were written
+func Export(dbURI, format, path string) (int, error) {
+	// the collection only holds what was parsed out of each page, and the archive keeps bodies decoded
+	// without the request or status line, so neither can give back what came over the wire
+	if format == FormatWARC {
+		return 0, errors.New("raw requests and responses aren't stored, not even in the archive, add a warc sink to the crawl instead")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(dbURI))
+	if err != nil {
+		return 0, err
+	}
+
+	defer client.Disconnect(context.TODO())
+
+	sink, err := OpenSink(format, path)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := exportContent(client.Database("crawler").Collection("content"), sink)
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+
+	return written, err
+}
+
+func exportContent(collection *mongo.Collection, sink Sink) (int, error) {
+	cursor, err := collection.Find(context.TODO(), bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.TODO())
+
+	written := 0
+	for cursor.Next(context.TODO()) {
+		content := Content{}
+		if err := cursor.Decode(&content); err != nil {
+			return written, err
+		}
+
+		if err := sink.Write(Record{Content: content}); err != nil {
+			return written, err
+		}
+		written++
+
+		if written%10000 == 0 {
+			slog.Info("exporting", "pages", written)
+		}
+	}
+
+	return written, cursor.Err()
+}
@@ -0,0 +1,169 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/junwei890/crawler/utils"
+)
+
+func (f Frontier) patterns() ([]utils.Pattern, error) {
+	patterns := []utils.Pattern{}
+	for _, pattern := range f.Patterns {
+		match, err := regexp.Compile(pattern.Match)
+		if err != nil {
+			return nil, fmt.Errorf("bad frontier pattern %s: %v", pattern.Match, err)
+		}
+
+		patterns = append(patterns, utils.Pattern{Match: match, Weight: pattern.Weight})
+	}
+
+	return patterns, nil
+}
+
+// sitemaps are only worth fetching when they feed the priority
+func (f Frontier) usesSitemaps() bool {
+	return f.Strategy == StrategyPriority && f.Sitemap != 0
+}
+
+// builds the in memory frontier a site is crawled from, sitemap urls only matter to the priority strategy
+func newFrontier(config Frontier, sitemaps []utils.SitemapURL) (utils.Frontier, error) {
+	switch config.Strategy {
+	case StrategyDFS:
+		return utils.NewLIFO(), nil
+	case StrategyPriority:
+		patterns, err := config.patterns()
+		if err != nil {
+			return nil, err
+		}
+
+		return utils.NewPriority(utils.Combine(
+			utils.Weighted{Scorer: utils.SitemapScorer(sitemaps), Weight: config.Sitemap},
+			utils.Weighted{Scorer: utils.InlinkScorer, Weight: config.Inlinks},
+			utils.Weighted{Scorer: utils.DepthScorer, Weight: config.Depth},
+			utils.Weighted{Scorer: utils.PatternScorer(patterns), Weight: 1},
+		)), nil
+	default:
+		return utils.NewFIFO(), nil
+	}
+}
+
+// one bloom filter for every site in a run, nil when seen sets stay in memory and don't need one
+func (f Frontier) bloomFilter() *utils.BloomFilter {
+	if f.Dir == "" {
+		return nil
+	}
+
+	return utils.NewBloomFilter(f.Expected)
+}
+
+// the frontier and seen set for one site, on disk under their own directory when one is configured,
+// close removes whatever was spilled and reports any disk error hit along the way
+func newSiteState(config Frontier, bloom *utils.BloomFilter, host string, sitemaps []utils.SitemapURL) (utils.Frontier, utils.SeenSet, func() error, error) {
+	if config.Dir == "" {
+		frontier, err := newFrontier(config, sitemaps)
+		return frontier, utils.NewMemorySeen(), func() error { return nil }, err
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o700); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// several runs or workers can share the directory, so every site gets a fresh one
+	dir, err := os.MkdirTemp(config.Dir, strings.ReplaceAll(host, ":", "_")+"-")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	seen, err := utils.NewDiskSeen(filepath.Join(dir, "seen"), config.Window, bloom)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, nil, err
+	}
+
+	var frontier interface {
+		utils.Frontier
+		Close() error
+	}
+	if config.Strategy == StrategyDFS {
+		frontier, err = utils.NewDiskLIFO(filepath.Join(dir, "frontier"), config.Window)
+	} else {
+		frontier, err = utils.NewDiskFIFO(filepath.Join(dir, "frontier"), config.Window)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, nil, err
+	}
+
+	closer := func() error {
+		return errors.Join(seen.Close(), frontier.Close(), os.RemoveAll(dir))
+	}
+
+	return frontier, seen, closer, nil
+}
+
+// decides at discovery time which links make it into a site's frontier, so every url is queued
+// at most once however many pages link to it
+type admission struct {
+	domain   *url.URL
+	rules    utils.Rules
+	seen     utils.SeenSet
+	frontier utils.Frontier
+	stats    *Stats
+	logger   *slog.Logger
+	metrics  *metrics
+}
+
+// a frontier that can count another inlink to a url it's already holding
+type bumper interface {
+	Bump(utils.Item) bool
+}
+
+func (a *admission) admit(item utils.Item) {
+	ok, err := utils.CheckDomain(a.domain, item.URL)
+	if err != nil {
+		a.stats.fail(ErrClassInvalidURL)
+		a.logger.Warn("didn't queue page", "url", item.URL, "depth", item.Depth, "error_class", ErrClassInvalidURL, "error", err)
+		return
+	}
+
+	normURL, err := utils.Normalize(item.URL)
+	if err != nil {
+		a.stats.fail(ErrClassInvalidURL)
+		a.logger.Warn("didn't queue page", "url", item.URL, "depth", item.Depth, "error_class", ErrClassInvalidURL, "error", err)
+		return
+	}
+
+	// off domain urls are never crawled, so they can share the seen set to be counted once
+	if !ok {
+		if !a.seen.Add(normURL) {
+			a.stats.OffDomain++
+			a.logger.Debug("skipped page", "url", item.URL, "depth", item.Depth, "reason", "off_domain")
+		}
+		return
+	}
+
+	// already queued or crawled, a priority frontier still counts the link towards its score
+	if a.seen.Has(normURL) {
+		if bumper, ok := a.frontier.(bumper); ok {
+			bumper.Bump(item)
+		}
+		return
+	}
+
+	if !utils.CheckAbility(a.seen, a.rules, normURL) {
+		a.stats.Robots++
+		a.metrics.robotsDenials.WithLabelValues(a.domain.Hostname()).Inc()
+		a.logger.Debug("skipped page", "url", item.URL, "depth", item.Depth, "reason", "robots")
+		return
+	}
+
+	a.frontier.Push(item)
+	a.stats.FrontierPeak = max(a.stats.FrontierPeak, a.frontier.Len())
+}
@@ -0,0 +1,186 @@
+package src
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/junwei890/crawler/utils"
+)
+
+func TestNewFrontier(t *testing.T) {
+	config := DefaultConfig().Frontier
+
+	frontier, err := newFrontier(config, nil)
+	if _, ok := frontier.(*utils.FIFO); !ok || err != nil {
+		t.Errorf("NewFrontier: test case 1 failed, %T isn't a fifo: %v", frontier, err)
+	}
+
+	config.Strategy = StrategyDFS
+	frontier, err = newFrontier(config, nil)
+	if _, ok := frontier.(*utils.LIFO); !ok || err != nil {
+		t.Errorf("NewFrontier: test case 2 failed, %T isn't a lifo: %v", frontier, err)
+	}
+
+	config.Strategy = StrategyPriority
+	config.Patterns = []Pattern{{Match: `/papers/`, Weight: 3}}
+	frontier, err = newFrontier(config, []utils.SitemapURL{{Loc: "https://example.com/about", Priority: 1}})
+	if err != nil {
+		t.Errorf("NewFrontier: test case 3 failed, unexpected error: %v", err)
+	}
+
+	frontier.Push(utils.Item{URL: "https://example.com/news", Depth: 1})
+	frontier.Push(utils.Item{URL: "https://example.com/about", Depth: 1})
+	frontier.Push(utils.Item{URL: "https://example.com/papers/1", Depth: 4})
+
+	// a budget of two only ever reaches the most valuable pages
+	expected := []string{"https://example.com/papers/1", "https://example.com/about"}
+	for i, url := range expected {
+		item, err := frontier.Pop()
+		if err != nil || item.URL != url {
+			t.Errorf("NewFrontier: test case %d failed, %s != %s", i+4, item.URL, url)
+		}
+	}
+}
+
+func TestNewSiteState(t *testing.T) {
+	config := DefaultConfig().Frontier
+
+	frontier, seen, closer, err := newSiteState(config, nil, "example.com", nil)
+	if err != nil {
+		t.Fatalf("NewSiteState: test case 1 failed, unexpected error: %v", err)
+	}
+	if _, ok := seen.(utils.MemorySeen); !ok {
+		t.Errorf("NewSiteState: test case 2 failed, %T isn't in memory", seen)
+	}
+	if _, ok := frontier.(*utils.FIFO); !ok {
+		t.Errorf("NewSiteState: test case 3 failed, %T isn't in memory", frontier)
+	}
+	closer()
+
+	config.Dir = t.TempDir()
+	config.Window = 2
+	frontier, seen, closer, err = newSiteState(config, config.bloomFilter(), "example.com:8080", nil)
+	if err != nil {
+		t.Fatalf("NewSiteState: test case 4 failed, unexpected error: %v", err)
+	}
+	if _, ok := seen.(*utils.DiskSeen); !ok {
+		t.Errorf("NewSiteState: test case 5 failed, %T isn't on disk", seen)
+	}
+	if _, ok := frontier.(*utils.DiskFIFO); !ok {
+		t.Errorf("NewSiteState: test case 6 failed, %T isn't on disk", frontier)
+	}
+
+	for _, url := range []string{"a", "b", "c", "d", "e"} {
+		frontier.Push(utils.Item{URL: url})
+		seen.Add(url)
+	}
+
+	entries, _ := os.ReadDir(config.Dir)
+	if len(entries) != 1 || !strings.HasPrefix(entries[0].Name(), "example.com_8080-") {
+		t.Errorf("NewSiteState: test case 7 failed, unexpected directories %v", entries)
+	}
+
+	if err := closer(); err != nil {
+		t.Errorf("NewSiteState: test case 8 failed, unexpected error: %v", err)
+	}
+	if entries, _ := os.ReadDir(config.Dir); len(entries) != 0 {
+		t.Errorf("NewSiteState: test case 9 failed, %v left behind", entries)
+	}
+}
+
+func newTestAdmission(t *testing.T, frontier utils.Frontier) *admission {
+	domain, err := url.Parse("https://example.com")
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	return &admission{
+		domain:   domain,
+		rules:    utils.Rules{Disallowed: []string{"example.com/private"}},
+		seen:     utils.NewMemorySeen(),
+		frontier: frontier,
+		stats:    NewStats("https://example.com"),
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics:  newMetrics(),
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	a := newTestAdmission(t, utils.NewFIFO())
+
+	// every page links to every other page, plus an off domain and a disallowed page
+	const pages = 200
+	for from := 0; from < pages; from++ {
+		for to := 0; to < pages; to++ {
+			a.admit(utils.Item{URL: fmt.Sprintf("https://example.com/%d", to), Depth: 1})
+			a.admit(utils.Item{URL: fmt.Sprintf("https://example.com/%d/#top", to), Depth: 1})
+		}
+		a.admit(utils.Item{URL: "https://elsewhere.com/", Depth: 1})
+		a.admit(utils.Item{URL: "https://example.com/private/page", Depth: 1})
+	}
+
+	if a.frontier.Len() != pages {
+		t.Errorf("Admission: test case 1 failed, %d != %d", a.frontier.Len(), pages)
+	}
+	if a.stats.FrontierPeak != pages {
+		t.Errorf("Admission: test case 2 failed, %d != %d", a.stats.FrontierPeak, pages)
+	}
+	if a.stats.OffDomain != 1 || a.stats.Robots != 1 {
+		t.Errorf("Admission: test case 3 failed, off domain %d, robots %d", a.stats.OffDomain, a.stats.Robots)
+	}
+
+	a.admit(utils.Item{URL: "://bad"})
+	if a.stats.Errors[ErrClassInvalidURL] != 1 {
+		t.Errorf("Admission: test case 4 failed, %v", a.stats.Errors)
+	}
+}
+
+func TestAdmissionBump(t *testing.T) {
+	a := newTestAdmission(t, utils.NewPriority(utils.InlinkScorer))
+
+	a.admit(utils.Item{URL: "https://example.com/a", Depth: 1})
+	a.admit(utils.Item{URL: "https://example.com/b", Depth: 1})
+	// found again from other pages, b ends up with the most inlinks
+	a.admit(utils.Item{URL: "https://example.com/b/", Depth: 2})
+	a.admit(utils.Item{URL: "https://example.com/b", Depth: 2})
+
+	item, err := a.frontier.Pop()
+	if err != nil || item.URL != "https://example.com/b" || item.Inlinks != 3 {
+		t.Errorf("AdmissionBump: test case 1 failed, %+v: %v", item, err)
+	}
+	if a.frontier.Len() != 1 {
+		t.Errorf("AdmissionBump: test case 2 failed, %d != %d", a.frontier.Len(), 1)
+	}
+}
+
+// repeats of known links shouldn't cost memory, the frontier and seen set only ever hold unique urls
+func TestAdmissionMemory(t *testing.T) {
+	a := newTestAdmission(t, utils.NewFIFO())
+
+	links := make([]string, 1000)
+	for i := range links {
+		links[i] = fmt.Sprintf("https://example.com/page/%d", i)
+	}
+
+	// half a million links are found, a thousand of them unique
+	for page := 0; page < 500; page++ {
+		for _, link := range links {
+			a.admit(utils.Item{URL: link, Depth: 1})
+		}
+	}
+
+	if a.frontier.Len() != len(links) {
+		t.Errorf("AdmissionMemory: test case 1 failed, %d != %d", a.frontier.Len(), len(links))
+	}
+	if a.seen.Len() != len(links) {
+		t.Errorf("AdmissionMemory: test case 2 failed, %d != %d", a.seen.Len(), len(links))
+	}
+	if a.stats.FrontierPeak != len(links) {
+		t.Errorf("AdmissionMemory: test case 3 failed, %d != %d", a.stats.FrontierPeak, len(links))
+	}
+}
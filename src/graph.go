@@ -0,0 +1,176 @@
+package src
+
+import (
+	"context"
+	"math"
+	"slices"
+
+	"github.com/junwei890/crawler/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	damping       = 0.85
+	maxIterations = 100
+	tolerance     = 1e-9
+)
+
+// an outlink, ids are deterministic so recrawls don't duplicate edges
+type Edge struct {
+	ID       string   `bson:"_id"`
+	From     string   `bson:"from"`
+	To       string   `bson:"to"`
+	Anchor   string   `bson:"anchor"`
+	Rel      []string `bson:"rel,omitempty"`
+	Position int      `bson:"position"`
+}
+
+func NewEdge(from string, link utils.Link) Edge {
+	return Edge{
+		ID:       from + " " + link.URL,
+		From:     from,
+		To:       link.URL,
+		Anchor:   link.Text,
+		Rel:      link.Rel,
+		Position: link.Position,
+	}
+}
+
+// power iteration, rank from pages without outlinks is spread evenly across every page
+func PageRank(edges []Edge) (map[string]float64, map[string]int) {
+	outlinks := map[string][]string{}
+	inDegree := map[string]int{}
+	nodes := map[string]struct{}{}
+
+	for _, edge := range edges {
+		nodes[edge.From] = struct{}{}
+		nodes[edge.To] = struct{}{}
+
+		// self links and nofollow links don't pass on any rank
+		if edge.From == edge.To || slices.Contains(edge.Rel, "nofollow") {
+			continue
+		}
+
+		outlinks[edge.From] = append(outlinks[edge.From], edge.To)
+		inDegree[edge.To]++
+	}
+
+	ranks := map[string]float64{}
+	if len(nodes) == 0 {
+		return ranks, inDegree
+	}
+
+	n := float64(len(nodes))
+	for node := range nodes {
+		ranks[node] = 1 / n
+	}
+
+	for range maxIterations {
+		dangling := 0.0
+		for node := range nodes {
+			if len(outlinks[node]) == 0 {
+				dangling += ranks[node]
+			}
+		}
+
+		next := map[string]float64{}
+		for node := range nodes {
+			next[node] = (1-damping)/n + damping*dangling/n
+		}
+
+		for from, tos := range outlinks {
+			share := ranks[from] / float64(len(tos))
+			for _, to := range tos {
+				next[to] += damping * share
+			}
+		}
+
+		delta := 0.0
+		for node := range nodes {
+			delta += math.Abs(next[node] - ranks[node])
+		}
+
+		ranks = next
+		if delta < tolerance {
+			break
+		}
+	}
+
+	return ranks, inDegree
+}
+
+// offline job run after a crawl, writes pagerank and in degree back onto crawled documents
+func RankPages(dbURI string) error {
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(dbURI))
+	if err != nil {
+		return err
+	}
+
+	defer client.Disconnect(context.TODO())
+
+	db := client.Database("crawler")
+
+	cursor, err := db.Collection("links").Find(context.TODO(), bson.D{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.TODO())
+
+	edges := []Edge{}
+	if err := cursor.All(context.TODO(), &edges); err != nil {
+		return err
+	}
+
+	ranks, inDegree := PageRank(edges)
+
+	models := []mongo.WriteModel{}
+	for url, rank := range ranks {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: url}}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{
+				{Key: "pagerank", Value: rank},
+				{Key: "in_degree", Value: inDegree[url]},
+			}}}))
+	}
+
+	if len(models) == 0 {
+		return nil
+	}
+
+	// urls that were linked to but never stored simply don't match anything
+	if _, err := db.Collection("content").BulkWrite(context.TODO(), models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// collects the anchor text of every inbound link onto the page it points at,
+// pages that were linked to but never stored are left out
+func AggregateAnchors(db *mongo.Database) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "anchor", Value: bson.D{{Key: "$ne", Value: ""}}},
+			{Key: "$expr", Value: bson.D{{Key: "$ne", Value: bson.A{"$from", "$to"}}}},
+		}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$to"},
+			{Key: "anchors", Value: bson.D{{Key: "$addToSet", Value: "$anchor"}}},
+		}}},
+		{{Key: "$merge", Value: bson.D{
+			{Key: "into", Value: "content"},
+			{Key: "on", Value: "_id"},
+			{Key: "whenMatched", Value: "merge"},
+			{Key: "whenNotMatched", Value: "discard"},
+		}}},
+	}
+
+	cursor, err := db.Collection("links").Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return err
+	}
+
+	return cursor.Close(context.TODO())
+}
@@ -0,0 +1,49 @@
+package src
+
+import (
+	"math"
+	"testing"
+
+	"github.com/junwei890/crawler/utils"
+)
+
+func TestPageRank(t *testing.T) {
+	edges := []Edge{
+		NewEdge("a", utils.Link{URL: "b"}),
+		NewEdge("a", utils.Link{URL: "c"}),
+		NewEdge("b", utils.Link{URL: "c"}),
+		NewEdge("c", utils.Link{URL: "a"}),
+		NewEdge("d", utils.Link{URL: "c"}),
+		NewEdge("c", utils.Link{URL: "c"}),
+		NewEdge("b", utils.Link{URL: "d", Rel: []string{"nofollow"}}),
+	}
+
+	ranks, inDegree := PageRank(edges)
+
+	total := 0.0
+	for _, rank := range ranks {
+		total += rank
+	}
+	if math.Abs(total-1) > 1e-6 {
+		t.Errorf("PageRank: test case 1 failed, ranks sum to %f", total)
+	}
+
+	if !(ranks["c"] > ranks["a"] && ranks["a"] > ranks["b"] && ranks["b"] > ranks["d"]) {
+		t.Errorf("PageRank: test case 2 failed, unexpected ordering %v", ranks)
+	}
+
+	expected := map[string]int{"a": 1, "b": 1, "c": 3}
+	for node, degree := range expected {
+		if inDegree[node] != degree {
+			t.Errorf("PageRank: test case 3 failed, %s has in degree %d != %d", node, inDegree[node], degree)
+		}
+	}
+	if inDegree["d"] != 0 {
+		t.Errorf("PageRank: test case 4 failed, %d != %d", inDegree["d"], 0)
+	}
+
+	ranks, _ = PageRank([]Edge{})
+	if len(ranks) != 0 {
+		t.Errorf("PageRank: test case 5 failed, %v not empty", ranks)
+	}
+}
@@ -0,0 +1,328 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// builds the atlas search definition from config, fields are sorted so the output is stable
+func indexDefinition(config Config) bson.D {
+	names := []string{}
+	for name := range config.Index.Fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	fields := bson.D{}
+	for _, name := range names {
+		field := config.Index.Fields[name]
+		types := bson.A{}
+
+		analyzer := field.Analyzer
+		if analyzer == "" {
+			analyzer = config.Analyzer
+		}
+		if field.Keyword {
+			analyzer = "lucene.keyword"
+		}
+
+		types = append(types, bson.D{
+			{Key: "type", Value: "string"},
+			{Key: "analyzer", Value: analyzer},
+		})
+
+		if field.Autocomplete {
+			types = append(types, bson.D{
+				{Key: "type", Value: "autocomplete"},
+				{Key: "tokenization", Value: "edgeGram"},
+				{Key: "minGrams", Value: 2},
+				{Key: "maxGrams", Value: 15},
+				{Key: "foldDiacritics", Value: true},
+			})
+		}
+
+		// string facets need the token type
+		if field.Facet {
+			types = append(types, bson.D{
+				{Key: "type", Value: "token"},
+				{Key: "normalizer", Value: "lowercase"},
+			})
+		}
+
+		if len(types) == 1 {
+			fields = append(fields, bson.E{Key: name, Value: types[0]})
+		} else {
+			fields = append(fields, bson.E{Key: name, Value: types})
+		}
+	}
+
+	definition := bson.D{
+		{Key: "mappings", Value: bson.D{
+			{Key: "dynamic", Value: false},
+			{Key: "fields", Value: fields},
+		}},
+	}
+
+	if len(config.Index.Synonyms) > 0 {
+		synonyms := bson.A{}
+		for _, synonym := range config.Index.Synonyms {
+			analyzer := synonym.Analyzer
+			if analyzer == "" {
+				analyzer = config.Analyzer
+			}
+
+			synonyms = append(synonyms, bson.D{
+				{Key: "name", Value: synonym.Name},
+				{Key: "analyzer", Value: analyzer},
+				{Key: "source", Value: bson.D{{Key: "collection", Value: synonym.Collection}}},
+			})
+		}
+
+		definition = append(definition, bson.E{Key: "synonyms", Value: synonyms})
+	}
+
+	return definition
+}
+
+// compares definitions ignoring key order, both sides go through json so numeric types line up,
+// and atlas fills in defaults when it hands a definition back, so only what indexDefinition sets counts
+func definitionChanged(existing, desired any) (bool, error) {
+	normalise := func(definition any) (map[string]any, error) {
+		extJSON, err := bson.MarshalExtJSON(definition, false, false)
+		if err != nil {
+			return nil, err
+		}
+
+		generic := map[string]any{}
+		if err := json.Unmarshal(extJSON, &generic); err != nil {
+			return nil, err
+		}
+
+		// no synonyms and an empty list of them are the same thing
+		if _, ok := generic["synonyms"]; !ok {
+			generic["synonyms"] = []any{}
+		}
+
+		return generic, nil
+	}
+
+	before, err := normalise(existing)
+	if err != nil {
+		return false, err
+	}
+
+	after, err := normalise(desired)
+	if err != nil {
+		return false, err
+	}
+
+	return !covers(before, after, ""), nil
+}
+
+// whether existing has every value desired sets, keys only existing has are defaults and don't count,
+// except under "fields", where a field dropped from the config is a change too
+func covers(existing, desired any, key string) bool {
+	switch want := desired.(type) {
+	case map[string]any:
+		have, ok := existing.(map[string]any)
+		if !ok || (key == "fields" && len(have) != len(want)) {
+			return false
+		}
+		for name, value := range want {
+			if !covers(have[name], value, name) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		have, ok := existing.([]any)
+		if !ok || len(have) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !covers(have[i], want[i], key) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(existing, desired)
+	}
+}
+
+const (
+	IndexReady  = "READY"
+	IndexFailed = "FAILED"
+	// the index isn't in atlas, used for the local backend
+	IndexLocal = "LOCAL"
+)
+
+var (
+	ErrIndexFailed  = errors.New("search index failed to build")
+	ErrIndexTimeout = errors.New("timed out waiting for search index")
+)
+
+var indexPollInterval = 5 * time.Second
+
+type IndexStatus struct {
+	Name      string `bson:"name"`
+	Status    string `bson:"status"`
+	Queryable bool   `bson:"queryable"`
+	Message   string `bson:"message,omitempty"`
+}
+
+// pulls the status out of a listSearchIndexes entry, messages can sit at the top level
+// or in the per host details
+func indexStatus(indexMap bson.M) IndexStatus {
+	status := IndexStatus{}
+	status.Name, _ = indexMap["name"].(string)
+	status.Status, _ = indexMap["status"].(string)
+	status.Queryable, _ = indexMap["queryable"].(bool)
+
+	messages := []string{}
+	if message, ok := indexMap["message"].(string); ok && message != "" {
+		messages = append(messages, message)
+	}
+
+	if details, ok := indexMap["statusDetail"].(bson.A); ok {
+		for _, detail := range details {
+			detailMap, ok := detail.(bson.M)
+			if !ok {
+				continue
+			}
+
+			mainIndex, ok := detailMap["mainIndex"].(bson.M)
+			if !ok {
+				continue
+			}
+
+			if message, ok := mainIndex["message"].(string); ok && message != "" && !slices.Contains(messages, message) {
+				messages = append(messages, message)
+			}
+		}
+	}
+	status.Message = strings.Join(messages, "; ")
+
+	return status
+}
+
+func findSearchIndex(ctx context.Context, collection *mongo.Collection, name string) (bson.M, error) {
+	cursor, err := collection.SearchIndexes().List(ctx, options.SearchIndexes().SetName(name))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var indexMap bson.M
+		if err := cursor.Decode(&indexMap); err != nil {
+			return nil, err
+		}
+
+		if indexMap["name"] == name {
+			return indexMap, nil
+		}
+	}
+
+	return nil, cursor.Err()
+}
+
+// polls until the latest definition is queryable, the build fails or the timeout runs out
+func waitForIndex(collection *mongo.Collection, name string, definition bson.D, timeout time.Duration) (IndexStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(indexPollInterval)
+	defer ticker.Stop()
+
+	status := IndexStatus{Name: name}
+	for {
+		indexMap, err := findSearchIndex(ctx, collection, name)
+		if err != nil && ctx.Err() == nil {
+			return status, err
+		}
+
+		if indexMap != nil {
+			status = indexStatus(indexMap)
+
+			switch status.Status {
+			case IndexFailed:
+				return status, fmt.Errorf("%w: %s", ErrIndexFailed, status.Message)
+			case IndexReady:
+				// right after an update the old definition can still report ready
+				changed, err := definitionChanged(indexMap["latestDefinition"], definition)
+				if err != nil {
+					return status, err
+				}
+				if !changed && status.Queryable {
+					return status, nil
+				}
+			}
+
+			slog.Info("waiting on search index", "index", name, "status", status.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, fmt.Errorf("%w after %s", ErrIndexTimeout, timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+func createSearchIndex(collection *mongo.Collection, config Config) (IndexStatus, error) {
+	// create index if it doesn't exist, update it if it has changed
+	indexName := config.Index.Name
+	opts := options.SearchIndexes().SetName(indexName).SetType("search")
+
+	existing, err := findSearchIndex(context.TODO(), collection, indexName)
+	if err != nil {
+		return IndexStatus{Name: indexName}, err
+	}
+
+	searchIndexModel := mongo.SearchIndexModel{
+		Definition: indexDefinition(config),
+		Options:    opts,
+	}
+	definition := searchIndexModel.Definition.(bson.D)
+
+	if existing == nil {
+		if _, err := collection.SearchIndexes().CreateOne(context.TODO(), searchIndexModel); err != nil {
+			return IndexStatus{Name: indexName}, err
+		}
+	} else {
+		changed, err := definitionChanged(existing["latestDefinition"], definition)
+		if err != nil {
+			return indexStatus(existing), err
+		}
+
+		if !changed {
+			slog.Info("search index is up to date", "index", indexName)
+		} else if err := collection.SearchIndexes().UpdateOne(context.TODO(), indexName, definition); err != nil {
+			return indexStatus(existing), err
+		}
+	}
+
+	if config.Index.Wait {
+		return waitForIndex(collection, indexName, definition, time.Duration(config.Index.WaitTimeout))
+	}
+
+	// not waiting, so report whatever atlas says right now
+	current, err := findSearchIndex(context.TODO(), collection, indexName)
+	if err != nil || current == nil {
+		return IndexStatus{Name: indexName, Status: "PENDING"}, err
+	}
+
+	return indexStatus(current), nil
+}
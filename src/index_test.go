@@ -0,0 +1,198 @@
+package src
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIndexDefinition(t *testing.T) {
+	config := DefaultConfig()
+	config.Analyzer = "lucene.english"
+	config.Index.Fields = map[string]IndexField{
+		"title":   {Autocomplete: true},
+		"content": {Analyzer: "lucene.french"},
+		"host":    {Keyword: true, Facet: true},
+	}
+	config.Index.Synonyms = []Synonyms{
+		{Name: "words", Collection: "synonyms"},
+	}
+
+	expected := bson.M{
+		"mappings": bson.M{
+			"dynamic": false,
+			"fields": bson.M{
+				"content": bson.M{"type": "string", "analyzer": "lucene.french"},
+				"host": bson.A{
+					bson.M{"type": "string", "analyzer": "lucene.keyword"},
+					bson.M{"type": "token", "normalizer": "lowercase"},
+				},
+				"title": bson.A{
+					bson.M{"type": "string", "analyzer": "lucene.english"},
+					bson.M{"type": "autocomplete", "tokenization": "edgeGram", "minGrams": 2, "maxGrams": 15, "foldDiacritics": true},
+				},
+			},
+		},
+		"synonyms": bson.A{
+			bson.M{"name": "words", "analyzer": "lucene.english", "source": bson.M{"collection": "synonyms"}},
+		},
+	}
+
+	// checked both ways, since keys the desired side doesn't set are taken as atlas defaults
+	changed, err := definitionChanged(expected, indexDefinition(config))
+	if err != nil {
+		t.Errorf("indexDefinition: test case 1 failed, unexpected error: %v", err)
+	}
+	if changed {
+		t.Errorf("indexDefinition: test case 1 failed, %v != %v", indexDefinition(config), expected)
+	}
+
+	changed, err = definitionChanged(indexDefinition(config), expected)
+	if err != nil || changed {
+		t.Errorf("indexDefinition: test case 2 failed, %v != %v (%v)", indexDefinition(config), expected, err)
+	}
+}
+
+func TestDefinitionChanged(t *testing.T) {
+	desired := bson.D{
+		{Key: "mappings", Value: bson.D{
+			{Key: "dynamic", Value: false},
+			{Key: "fields", Value: bson.D{
+				{Key: "content", Value: bson.D{
+					{Key: "type", Value: "string"},
+					{Key: "analyzer", Value: "lucene.standard"},
+				}},
+			}},
+		}},
+	}
+
+	testCases := []struct {
+		name     string
+		existing any
+		expected bool
+	}{
+		{
+			name: "definitionChanged: test case 1",
+			existing: bson.M{
+				"mappings": bson.M{
+					"fields":  bson.M{"content": bson.M{"analyzer": "lucene.standard", "type": "string"}},
+					"dynamic": false,
+				},
+			},
+			expected: false,
+		},
+		{
+			name: "definitionChanged: test case 2",
+			existing: bson.M{
+				"mappings": bson.M{
+					"fields":  bson.M{"content": bson.M{"analyzer": "lucene.english", "type": "string"}},
+					"dynamic": false,
+				},
+			},
+			expected: true,
+		},
+		{
+			name:     "definitionChanged: test case 3",
+			existing: bson.M{},
+			expected: true,
+		},
+		{
+			// as atlas hands it back, with its defaults filled in
+			name: "definitionChanged: test case 4",
+			existing: bson.M{
+				"analyzer":       "lucene.standard",
+				"searchAnalyzer": "lucene.standard",
+				"storedSource":   false,
+				"mappings": bson.M{
+					"dynamic": false,
+					"fields": bson.M{
+						"content": bson.M{"analyzer": "lucene.standard", "type": "string", "indexOptions": "offsets", "store": true, "norms": "include"},
+					},
+				},
+				"synonyms": bson.A{},
+			},
+			expected: false,
+		},
+		{
+			name: "definitionChanged: test case 5",
+			existing: bson.M{
+				"mappings": bson.M{
+					"dynamic": false,
+					"fields": bson.M{
+						"content": bson.M{"analyzer": "lucene.standard", "type": "string"},
+						"title":   bson.M{"analyzer": "lucene.standard", "type": "string"},
+					},
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "definitionChanged: test case 6",
+			existing: bson.M{
+				"mappings": bson.M{
+					"dynamic": false,
+					"fields":  bson.M{"content": bson.M{"analyzer": "lucene.standard", "type": "string"}},
+				},
+				"synonyms": bson.A{bson.M{"name": "words", "analyzer": "lucene.standard", "source": bson.M{"collection": "synonyms"}}},
+			},
+			expected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			changed, err := definitionChanged(testCase.existing, desired)
+			if err != nil {
+				t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+			}
+
+			if changed != testCase.expected {
+				t.Errorf("%s failed, %t != %t", testCase.name, changed, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestIndexStatus(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    bson.M
+		expected IndexStatus
+	}{
+		{
+			name: "indexStatus: test case 1",
+			input: bson.M{
+				"name":      "search_index",
+				"status":    "READY",
+				"queryable": true,
+			},
+			expected: IndexStatus{Name: "search_index", Status: IndexReady, Queryable: true},
+		},
+		{
+			name: "indexStatus: test case 2",
+			input: bson.M{
+				"name":      "search_index",
+				"status":    "FAILED",
+				"queryable": false,
+				"statusDetail": bson.A{
+					bson.M{"hostname": "a", "mainIndex": bson.M{"status": "FAILED", "message": "unknown analyzer lucene.klingon"}},
+					bson.M{"hostname": "b", "mainIndex": bson.M{"status": "FAILED", "message": "unknown analyzer lucene.klingon"}},
+				},
+			},
+			expected: IndexStatus{Name: "search_index", Status: IndexFailed, Message: "unknown analyzer lucene.klingon"},
+		},
+		{
+			name:     "indexStatus: test case 3",
+			input:    bson.M{"name": "search_index", "status": "BUILDING", "message": "building"},
+			expected: IndexStatus{Name: "search_index", Status: "BUILDING", Message: "building"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if result := indexStatus(testCase.input); result != testCase.expected {
+				t.Errorf("%s failed, %v != %v", testCase.name, result, testCase.expected)
+			}
+		})
+	}
+}
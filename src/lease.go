@@ -0,0 +1,255 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNoLease   = errors.New("no hosts left to claim")
+	ErrLeaseLost = errors.New("lease expired and was claimed by another worker")
+)
+
+const (
+	LeasePending = "pending"
+	LeaseActive  = "leased"
+	LeaseDone    = "done"
+	// the status of the marker left once a run's seeds are all in the store
+	LeaseSeeded = "seeded"
+	// the status of the marker left by the worker that finishes the run
+	LeaseFinished = "finished"
+)
+
+// markers can't clash with a host, seeds are grouped by hostname
+const (
+	seededKey = "*seeded"
+	finishKey = "*finished"
+)
+
+// unique per run and host, so a later run in the same store leases its hosts afresh, runs can't
+// hold a slash so ids can't clash across them
+func leaseID(run, host string) string {
+	return run + "/" + host
+}
+
+// a host and its seeds, held by at most one worker at a time so politeness holds across processes
+type Lease struct {
+	Host string `bson:"host" json:"host"`
+	// the run the host was seeded for
+	Run     string    `bson:"run" json:"run"`
+	Seeds   []string  `bson:"seeds" json:"seeds"`
+	Status  string    `bson:"status" json:"status"`
+	Worker  string    `bson:"worker" json:"worker"`
+	Expires time.Time `bson:"expires" json:"expires"`
+	// bumped on every claim, so a worker that lost its lease can't renew or release someone else's
+	Token int64 `bson:"token" json:"token"`
+}
+
+// where workers claim hosts from, every call is scoped to the run the store was opened for
+type LeaseStore interface {
+	// adds seeds grouped by host then marks the run seeded, hosts already in the run keep their state
+	// and seeding nothing leaves the run as it is
+	Seed(ctx context.Context, seeds []string) error
+	// leases a pending host or one whose lease expired, ErrNoLease if there are none
+	Claim(ctx context.Context, worker string, ttl time.Duration) (Lease, error)
+	// pushes the lease's expiry back, ErrLeaseLost if it was reclaimed
+	Renew(ctx context.Context, lease Lease, ttl time.Duration) error
+	// marks the host done, or puts it back for another worker if it wasn't finished
+	Release(ctx context.Context, lease Lease, done bool) error
+	// hosts that aren't done, leased ones included, a run that hasn't been seeded yet counts as one
+	// so workers started before the seeds wait for them rather than finishing an empty run
+	Remaining(ctx context.Context) (int, error)
+	// reports whether this worker should finish the run, true for exactly one worker once every host is done
+	Finish(ctx context.Context, worker string) (bool, error)
+}
+
+func NewLeaseStore(db *mongo.Database, config Workers) LeaseStore {
+	if config.Store == LeasesFile {
+		return NewFileLeases(config.Path, config.Run)
+	}
+
+	return NewMongoLeases(db.Collection("leases"), config.Run)
+}
+
+// seeds that don't parse are kept as their own host so they still get claimed and fail in the crawler
+func groupSeeds(seeds []string) map[string][]string {
+	hosts := map[string][]string{}
+	for _, seed := range seeds {
+		host := seed
+		if parsed, err := url.Parse(seed); err == nil && parsed.Hostname() != "" {
+			host = parsed.Hostname()
+		}
+
+		hosts[host] = append(hosts[host], seed)
+	}
+
+	return hosts
+}
+
+type MongoLeases struct {
+	collection *mongo.Collection
+	run        string
+}
+
+func NewMongoLeases(collection *mongo.Collection, run string) *MongoLeases {
+	return &MongoLeases{collection: collection, run: run}
+}
+
+func (m *MongoLeases) Seed(ctx context.Context, seeds []string) error {
+	if len(seeds) == 0 {
+		return nil
+	}
+
+	for host, hostSeeds := range groupSeeds(seeds) {
+		update := bson.D{
+			{Key: "$setOnInsert", Value: bson.D{
+				{Key: "host", Value: host},
+				{Key: "run", Value: m.run},
+				{Key: "status", Value: LeasePending},
+				{Key: "worker", Value: ""},
+				{Key: "expires", Value: time.Time{}},
+				{Key: "token", Value: 0},
+			}},
+			{Key: "$addToSet", Value: bson.D{
+				{Key: "seeds", Value: bson.D{{Key: "$each", Value: hostSeeds}}},
+			}},
+		}
+
+		if _, err := m.collection.UpdateByID(ctx, leaseID(m.run, host), update, options.Update().SetUpsert(true)); err != nil {
+			return err
+		}
+	}
+
+	// only written once every host is in, so no worker finishes a run that's still being seeded
+	marker := bson.D{{Key: "$setOnInsert", Value: bson.D{
+		{Key: "host", Value: seededKey},
+		{Key: "run", Value: m.run},
+		{Key: "seeds", Value: bson.A{}},
+		{Key: "status", Value: LeaseSeeded},
+	}}}
+	_, err := m.collection.UpdateByID(ctx, leaseID(m.run, seededKey), marker, options.Update().SetUpsert(true))
+
+	return err
+}
+
+// the find and update is atomic, so two workers can't claim the same host
+func (m *MongoLeases) Claim(ctx context.Context, worker string, ttl time.Duration) (Lease, error) {
+	now := time.Now().UTC()
+
+	filter := bson.D{
+		{Key: "run", Value: m.run},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "status", Value: LeasePending}},
+			bson.D{{Key: "status", Value: LeaseActive}, {Key: "expires", Value: bson.D{{Key: "$lt", Value: now}}}},
+		}},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: LeaseActive},
+			{Key: "worker", Value: worker},
+			{Key: "expires", Value: now.Add(ttl)},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "token", Value: 1}}},
+	}
+
+	lease := Lease{}
+	err := m.collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&lease)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return lease, ErrNoLease
+	}
+
+	return lease, err
+}
+
+func held(lease Lease) bson.D {
+	return bson.D{
+		{Key: "_id", Value: leaseID(lease.Run, lease.Host)},
+		{Key: "status", Value: LeaseActive},
+		{Key: "token", Value: lease.Token},
+	}
+}
+
+func (m *MongoLeases) Renew(ctx context.Context, lease Lease, ttl time.Duration) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "expires", Value: time.Now().UTC().Add(ttl)}}}}
+
+	res, err := m.collection.UpdateOne(ctx, held(lease), update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
+
+func (m *MongoLeases) Release(ctx context.Context, lease Lease, done bool) error {
+	status := LeasePending
+	if done {
+		status = LeaseDone
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: status},
+		{Key: "worker", Value: ""},
+		{Key: "expires", Value: time.Time{}},
+	}}}
+
+	res, err := m.collection.UpdateOne(ctx, held(lease), update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
+
+func (m *MongoLeases) Remaining(ctx context.Context) (int, error) {
+	count, err := m.collection.CountDocuments(ctx, bson.D{
+		{Key: "run", Value: m.run},
+		{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{LeasePending, LeaseActive}}}},
+	})
+	if err != nil || count > 0 {
+		return int(count), err
+	}
+
+	seeded, err := m.collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: leaseID(m.run, seededKey)}})
+	if err != nil {
+		return 0, err
+	}
+	if seeded == 0 {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// the marker's id is unique per run, so only the first worker to insert it finishes the run
+func (m *MongoLeases) Finish(ctx context.Context, worker string) (bool, error) {
+	remaining, err := m.Remaining(ctx)
+	if err != nil || remaining > 0 {
+		return false, err
+	}
+
+	_, err = m.collection.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: leaseID(m.run, finishKey)},
+		{Key: "host", Value: finishKey},
+		{Key: "run", Value: m.run},
+		{Key: "seeds", Value: bson.A{}},
+		{Key: "status", Value: LeaseFinished},
+		{Key: "worker", Value: worker},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
@@ -0,0 +1,210 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/junwei890/crawler/utils"
+)
+
+// leases kept in a json file, every change happens under an exclusive lock on a file next to it,
+// so workers on the same machine can share hosts without a database
+type FileLeases struct {
+	path string
+	run  string
+	// flock is per open file, this keeps goroutines in one process from racing on the temp file
+	mu sync.Mutex
+}
+
+func NewFileLeases(path, run string) *FileLeases {
+	return &FileLeases{path: path, run: run}
+}
+
+// reads the leases, lets change edit them and writes them back if it returns true, all under the lock
+func (f *FileLeases) update(change func(leases map[string]*Lease) (bool, error)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	unlock, err := lockFile(f.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	leases := map[string]*Lease{}
+	data, err := os.ReadFile(f.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &leases); err != nil {
+			return err
+		}
+	}
+
+	changed, err := change(leases)
+	if err != nil || !changed {
+		return err
+	}
+
+	data, err = json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(f.path, data)
+}
+
+// the hosts and the seeded marker are written together, so no worker sees the run half seeded
+func (f *FileLeases) Seed(ctx context.Context, seeds []string) error {
+	if len(seeds) == 0 {
+		return nil
+	}
+
+	return f.update(func(leases map[string]*Lease) (bool, error) {
+		for host, hostSeeds := range groupSeeds(seeds) {
+			lease, ok := leases[leaseID(f.run, host)]
+			if !ok {
+				lease = &Lease{Host: host, Run: f.run, Status: LeasePending}
+				leases[leaseID(f.run, host)] = lease
+			}
+
+			for _, seed := range hostSeeds {
+				if !slices.Contains(lease.Seeds, seed) {
+					lease.Seeds = append(lease.Seeds, seed)
+				}
+			}
+		}
+
+		if _, ok := leases[leaseID(f.run, seededKey)]; !ok {
+			leases[leaseID(f.run, seededKey)] = &Lease{Host: seededKey, Run: f.run, Status: LeaseSeeded}
+		}
+
+		return true, nil
+	})
+}
+
+func (f *FileLeases) Claim(ctx context.Context, worker string, ttl time.Duration) (Lease, error) {
+	claimed := Lease{}
+
+	err := f.update(func(leases map[string]*Lease) (bool, error) {
+		now := time.Now().UTC()
+
+		// hosts are handed out in order so runs are repeatable
+		ids := make([]string, 0, len(leases))
+		for id := range leases {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			lease := leases[id]
+			if lease.Run != f.run {
+				continue
+			}
+			if lease.Status == LeasePending || (lease.Status == LeaseActive && lease.Expires.Before(now)) {
+				lease.Status = LeaseActive
+				lease.Worker = worker
+				lease.Expires = now.Add(ttl)
+				lease.Token++
+
+				claimed = *lease
+				return true, nil
+			}
+		}
+
+		return false, ErrNoLease
+	})
+
+	return claimed, err
+}
+
+// finds the lease if it's still held under the same token
+func heldIn(leases map[string]*Lease, lease Lease) (*Lease, error) {
+	current, ok := leases[leaseID(lease.Run, lease.Host)]
+	if !ok || current.Status != LeaseActive || current.Token != lease.Token {
+		return nil, ErrLeaseLost
+	}
+
+	return current, nil
+}
+
+func (f *FileLeases) Renew(ctx context.Context, lease Lease, ttl time.Duration) error {
+	return f.update(func(leases map[string]*Lease) (bool, error) {
+		current, err := heldIn(leases, lease)
+		if err != nil {
+			return false, err
+		}
+
+		current.Expires = time.Now().UTC().Add(ttl)
+		return true, nil
+	})
+}
+
+func (f *FileLeases) Release(ctx context.Context, lease Lease, done bool) error {
+	return f.update(func(leases map[string]*Lease) (bool, error) {
+		current, err := heldIn(leases, lease)
+		if err != nil {
+			return false, err
+		}
+
+		current.Status = LeasePending
+		if done {
+			current.Status = LeaseDone
+		}
+		current.Worker = ""
+		current.Expires = time.Time{}
+
+		return true, nil
+	})
+}
+
+func (f *FileLeases) Remaining(ctx context.Context) (int, error) {
+	remaining := 0
+
+	err := f.update(func(leases map[string]*Lease) (bool, error) {
+		remaining = f.pending(leases)
+		return false, nil
+	})
+
+	return remaining, err
+}
+
+func (f *FileLeases) pending(leases map[string]*Lease) int {
+	if _, ok := leases[leaseID(f.run, seededKey)]; !ok {
+		return 1
+	}
+
+	count := 0
+	for _, lease := range leases {
+		if lease.Run == f.run && (lease.Status == LeasePending || lease.Status == LeaseActive) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (f *FileLeases) Finish(ctx context.Context, worker string) (bool, error) {
+	finishing := false
+
+	err := f.update(func(leases map[string]*Lease) (bool, error) {
+		if _, ok := leases[leaseID(f.run, finishKey)]; ok || f.pending(leases) > 0 {
+			return false, nil
+		}
+
+		leases[leaseID(f.run, finishKey)] = &Lease{Host: finishKey, Run: f.run, Status: LeaseFinished, Worker: worker}
+		finishing = true
+
+		return true, nil
+	})
+
+	return finishing, err
+}
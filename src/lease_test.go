@@ -0,0 +1,425 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupSeeds(t *testing.T) {
+	result := groupSeeds([]string{
+		"https://a.example.com",
+		"https://a.example.com/docs",
+		"http://b.example.com:8080",
+		"not a url",
+	})
+
+	expected := map[string][]string{
+		"a.example.com": {"https://a.example.com", "https://a.example.com/docs"},
+		"b.example.com": {"http://b.example.com:8080"},
+		"not a url":     {"not a url"},
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("GroupSeeds: test case 1 failed, %v != %v", result, expected)
+	}
+	for host, seeds := range expected {
+		if !slices.Equal(result[host], seeds) {
+			t.Errorf("GroupSeeds: test case %s failed, %v != %v", host, result[host], seeds)
+		}
+	}
+}
+
+func TestFileLeases(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "leases.json")
+	store := NewFileLeases(path, testRun)
+
+	// a run nobody has seeded yet isn't finished, however empty it is
+	if remaining, err := store.Remaining(ctx); err != nil || remaining != 1 {
+		t.Errorf("FileLeases: test case 1 failed, %d != %d (%v)", remaining, 1, err)
+	}
+	if finishing, err := store.Finish(ctx, "one"); err != nil || finishing {
+		t.Errorf("FileLeases: test case 2 failed, %t != %t (%v)", finishing, false, err)
+	}
+	if err := store.Seed(ctx, []string{}); err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	if remaining, err := store.Remaining(ctx); err != nil || remaining != 1 {
+		t.Errorf("FileLeases: test case 3 failed, %d != %d (%v)", remaining, 1, err)
+	}
+
+	if err := store.Seed(ctx, []string{"https://a.example.com", "https://a.example.com/docs", "https://b.example.com"}); err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	// seeding again, as every worker started with a crawler.txt does, changes nothing
+	if err := store.Seed(ctx, []string{"https://a.example.com"}); err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	if remaining, err := store.Remaining(ctx); err != nil || remaining != 2 {
+		t.Errorf("FileLeases: test case 4 failed, %d != %d (%v)", remaining, 2, err)
+	}
+
+	a, err := store.Claim(ctx, "one", time.Minute)
+	if err != nil || a.Host != "a.example.com" || len(a.Seeds) != 2 || a.Worker != "one" {
+		t.Errorf("FileLeases: test case 5 failed, unexpected lease %+v (%v)", a, err)
+	}
+
+	// b is claimed with a lease that's already run out, as if its worker died
+	b, err := store.Claim(ctx, "two", -time.Second)
+	if err != nil || b.Host != "b.example.com" {
+		t.Errorf("FileLeases: test case 6 failed, unexpected lease %+v (%v)", b, err)
+	}
+
+	reclaimed, err := store.Claim(ctx, "three", time.Minute)
+	if err != nil || reclaimed.Host != "b.example.com" || reclaimed.Token != b.Token+1 {
+		t.Errorf("FileLeases: test case 7 failed, unexpected lease %+v (%v)", reclaimed, err)
+	}
+
+	if _, err := store.Claim(ctx, "four", time.Minute); !errors.Is(err, ErrNoLease) {
+		t.Errorf("FileLeases: test case 8 failed, %v != %v", err, ErrNoLease)
+	}
+
+	// the worker that lost b can't touch it any more
+	if err := store.Renew(ctx, b, time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("FileLeases: test case 9 failed, %v != %v", err, ErrLeaseLost)
+	}
+	if err := store.Release(ctx, b, true); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("FileLeases: test case 10 failed, %v != %v", err, ErrLeaseLost)
+	}
+
+	if err := store.Renew(ctx, a, time.Minute); err != nil {
+		t.Errorf("FileLeases: test case 11 failed, unexpected error: %v", err)
+	}
+	if err := store.Release(ctx, a, true); err != nil {
+		t.Errorf("FileLeases: test case 12 failed, unexpected error: %v", err)
+	}
+
+	if finishing, err := store.Finish(ctx, "three"); err != nil || finishing {
+		t.Errorf("FileLeases: test case 13 failed, %t != %t (%v)", finishing, false, err)
+	}
+
+	// released without finishing, so it goes back up for claiming
+	if err := store.Release(ctx, reclaimed, false); err != nil {
+		t.Errorf("FileLeases: test case 14 failed, unexpected error: %v", err)
+	}
+	again, err := store.Claim(ctx, "four", time.Minute)
+	if err != nil || again.Host != "b.example.com" {
+		t.Errorf("FileLeases: test case 15 failed, unexpected lease %+v (%v)", again, err)
+	}
+	if err := store.Release(ctx, again, true); err != nil {
+		t.Errorf("FileLeases: test case 16 failed, unexpected error: %v", err)
+	}
+
+	if remaining, err := store.Remaining(ctx); err != nil || remaining != 0 {
+		t.Errorf("FileLeases: test case 17 failed, %d != %d (%v)", remaining, 0, err)
+	}
+	if finishing, err := store.Finish(ctx, "four"); err != nil || !finishing {
+		t.Errorf("FileLeases: test case 18 failed, %t != %t (%v)", finishing, true, err)
+	}
+	if finishing, err := store.Finish(ctx, "one"); err != nil || finishing {
+		t.Errorf("FileLeases: test case 19 failed, %t != %t (%v)", finishing, false, err)
+	}
+
+	// a later run in the same file leases the same hosts afresh and finishes on its own
+	later := NewFileLeases(path, "later")
+	if err := later.Seed(ctx, []string{"https://a.example.com", "https://b.example.com"}); err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	if remaining, err := later.Remaining(ctx); err != nil || remaining != 2 {
+		t.Errorf("FileLeases: test case 20 failed, %d != %d (%v)", remaining, 2, err)
+	}
+	if finishing, err := later.Finish(ctx, "one"); err != nil || finishing {
+		t.Errorf("FileLeases: test case 21 failed, %t != %t (%v)", finishing, false, err)
+	}
+	if lease, err := later.Claim(ctx, "one", time.Minute); err != nil || lease.Host != "a.example.com" || lease.Run != "later" {
+		t.Errorf("FileLeases: test case 22 failed, unexpected lease %+v (%v)", lease, err)
+	}
+}
+
+const testRun = "test"
+
+var testWorkers = Workers{
+	TTL:         Duration(300 * time.Millisecond),
+	Heartbeat:   Duration(50 * time.Millisecond),
+	Concurrency: 3,
+}
+
+func seedHosts(t *testing.T, store LeaseStore, n int) []string {
+	hosts := []string{}
+	seeds := []string{}
+	for i := range n {
+		host := fmt.Sprintf("host%02d.example.com", i)
+		hosts = append(hosts, host)
+		seeds = append(seeds, "https://"+host)
+	}
+
+	if err := store.Seed(context.Background(), seeds); err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	return hosts
+}
+
+func TestWorkLeases(t *testing.T) {
+	store := NewFileLeases(filepath.Join(t.TempDir(), "leases.json"), testRun)
+	hosts := seedHosts(t, store, 12)
+
+	mu := &sync.Mutex{}
+	crawled := []string{}
+	crawl := func(ctx context.Context, lease Lease) {
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		crawled = append(crawled, lease.Host)
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			workLeases(context.Background(), store, fmt.Sprintf("worker%d", i), testWorkers, crawl)
+		}()
+	}
+	wg.Wait()
+
+	slices.Sort(crawled)
+	if !slices.Equal(crawled, hosts) {
+		t.Errorf("WorkLeases: test case 1 failed, %v != %v", crawled, hosts)
+	}
+}
+
+func TestWorkLeasesBeforeSeeds(t *testing.T) {
+	store := NewFileLeases(filepath.Join(t.TempDir(), "leases.json"), testRun)
+
+	mu := &sync.Mutex{}
+	crawled := []string{}
+	crawl := func(ctx context.Context, lease Lease) {
+		mu.Lock()
+		defer mu.Unlock()
+		crawled = append(crawled, lease.Host)
+	}
+
+	// this worker starts on an empty store, so it has to wait for the seeds rather than finish
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		workLeases(context.Background(), store, "early", testWorkers, crawl)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("WorkLeasesBeforeSeeds: test case 1 failed, worker returned before the run was seeded")
+	case <-time.After(4 * time.Duration(testWorkers.Heartbeat)):
+	}
+	if finishing, err := store.Finish(context.Background(), "early"); err != nil || finishing {
+		t.Errorf("WorkLeasesBeforeSeeds: test case 2 failed, %t != %t (%v)", finishing, false, err)
+	}
+
+	hosts := seedHosts(t, store, 4)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WorkLeasesBeforeSeeds: test case 3 failed, worker never finished the seeded hosts")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	slices.Sort(crawled)
+	if !slices.Equal(crawled, hosts) {
+		t.Errorf("WorkLeasesBeforeSeeds: test case 4 failed, %v != %v", crawled, hosts)
+	}
+	if finishing, err := store.Finish(context.Background(), "early"); err != nil || !finishing {
+		t.Errorf("WorkLeasesBeforeSeeds: test case 5 failed, %t != %t (%v)", finishing, true, err)
+	}
+}
+
+// renews fail as though another worker had taken the host
+type stolenLeases struct {
+	*FileLeases
+	released chan Lease
+}
+
+func (s stolenLeases) Renew(ctx context.Context, lease Lease, ttl time.Duration) error {
+	return ErrLeaseLost
+}
+
+func (s stolenLeases) Release(ctx context.Context, lease Lease, done bool) error {
+	s.released <- lease
+	return s.FileLeases.Release(ctx, lease, done)
+}
+
+func TestWorkLeasesLost(t *testing.T) {
+	store := stolenLeases{
+		FileLeases: NewFileLeases(filepath.Join(t.TempDir(), "leases.json"), testRun),
+		released:   make(chan Lease, 1),
+	}
+	seedHosts(t, store, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopped := make(chan struct{})
+	crawl := func(ctx context.Context, lease Lease) {
+		<-ctx.Done()
+		close(stopped)
+	}
+	go workLeases(ctx, store, "worker", Workers{TTL: Duration(time.Minute), Heartbeat: testWorkers.Heartbeat, Concurrency: 1}, crawl)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WorkLeasesLost: test case 1 failed, crawl wasn't stopped when the lease was lost")
+	}
+
+	// the host belongs to someone else now, so it isn't released
+	select {
+	case lease := <-store.released:
+		t.Errorf("WorkLeasesLost: test case 2 failed, %s was released", lease.Host)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// run as a child process by the tests below, claiming hosts and logging each one it crawls
+func TestWorkerProcess(t *testing.T) {
+	leases := os.Getenv("CRAWLER_TEST_LEASES")
+	if leases == "" {
+		t.Skip("only run as a child process")
+	}
+
+	output, err := os.OpenFile(os.Getenv("CRAWLER_TEST_OUTPUT"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+
+	hang := os.Getenv("CRAWLER_TEST_HANG") != ""
+	crawl := func(ctx context.Context, lease Lease) {
+		if hang {
+			select {}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprintln(output, lease.Host)
+	}
+
+	workLeases(context.Background(), NewFileLeases(leases, testRun), workerID(), testWorkers, crawl)
+}
+
+func startWorker(t *testing.T, leases, output string, hang bool) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWorkerProcess$")
+	cmd.Env = append(os.Environ(), "CRAWLER_TEST_LEASES="+leases, "CRAWLER_TEST_OUTPUT="+output)
+	if hang {
+		cmd.Env = append(cmd.Env, "CRAWLER_TEST_HANG=1")
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	return cmd
+}
+
+func crawledHosts(t *testing.T, output string) []string {
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	hosts := strings.Fields(string(data))
+	slices.Sort(hosts)
+
+	return hosts
+}
+
+func TestWorkerProcesses(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the file lease store needs flock")
+	}
+
+	dir := t.TempDir()
+	leases := filepath.Join(dir, "leases.json")
+	output := filepath.Join(dir, "crawled")
+	hosts := seedHosts(t, NewFileLeases(leases, testRun), 20)
+
+	workers := []*exec.Cmd{}
+	for range 3 {
+		workers = append(workers, startWorker(t, leases, output, false))
+	}
+	for i, worker := range workers {
+		if err := worker.Wait(); err != nil {
+			t.Fatalf("WorkerProcesses: worker %d failed, unexpected error: %v", i, err)
+		}
+	}
+
+	// every host crawled by exactly one worker
+	if result := crawledHosts(t, output); !slices.Equal(result, hosts) {
+		t.Errorf("WorkerProcesses: test case 1 failed, %v != %v", result, hosts)
+	}
+}
+
+func leased(t *testing.T, store *FileLeases) int {
+	count := 0
+	err := store.update(func(leases map[string]*Lease) (bool, error) {
+		for _, lease := range leases {
+			if lease.Status == LeaseActive {
+				count++
+			}
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	return count
+}
+
+func TestWorkerProcessCrash(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the file lease store needs flock")
+	}
+
+	dir := t.TempDir()
+	leases := filepath.Join(dir, "leases.json")
+	output := filepath.Join(dir, "crawled")
+	store := NewFileLeases(leases, testRun)
+	hosts := seedHosts(t, store, 6)
+
+	// this worker claims hosts then hangs until it's killed, leaving its leases to expire
+	crashing := startWorker(t, leases, output, true)
+	deadline := time.Now().Add(10 * time.Second)
+	for leased(t, store) < testWorkers.Concurrency {
+		if time.Now().After(deadline) {
+			t.Fatal("error setting up test, crashing worker never claimed its hosts")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := crashing.Process.Kill(); err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	crashing.Wait()
+
+	if err := startWorker(t, leases, output, false).Wait(); err != nil {
+		t.Fatalf("WorkerProcessCrash: worker failed, unexpected error: %v", err)
+	}
+
+	if result := crawledHosts(t, output); !slices.Equal(result, hosts) {
+		t.Errorf("WorkerProcessCrash: test case 1 failed, %v != %v", result, hosts)
+	}
+}
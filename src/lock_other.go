@@ -0,0 +1,9 @@
+//go:build !unix
+
+package src
+
+import "errors"
+
+func lockFile(path string) (func() error, error) {
+	return nil, errors.New("the file lease store needs flock, use the mongo store on this platform")
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package src
+
+import (
+	"os"
+	"syscall"
+)
+
+// blocks until this process holds an exclusive lock on path, the returned func releases it
+func lockFile(path string) (func() error, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	fd := int(file.Fd())
+	if err := syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return func() error {
+		if err := syscall.Flock(fd, syscall.LOCK_UN); err != nil {
+			file.Close()
+			return err
+		}
+
+		return file.Close()
+	}, nil
+}
@@ -0,0 +1,33 @@
+package src
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+func parseLevel(level string) (slog.Level, error) {
+	parsed := slog.LevelInfo
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return parsed, fmt.Errorf("unknown log level %s", level)
+	}
+
+	return parsed, nil
+}
+
+// a logger writing to w as configured, config is expected to have been validated by LoadConfig
+func NewLogger(w io.Writer, config Log) *slog.Logger {
+	level, err := parseLevel(config.Level)
+	if err != nil {
+		level = slog.LevelInfo
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+
+	if strings.EqualFold(config.Format, LogJSON) {
+		return slog.New(slog.NewJSONHandler(w, options))
+	}
+
+	return slog.New(slog.NewTextHandler(w, options))
+}
@@ -0,0 +1,43 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := NewLogger(buffer, Log{Level: "warn", Format: LogJSON})
+
+	logger.Info("crawled page", "url", "https://example.com")
+	if buffer.Len() != 0 {
+		t.Errorf("NewLogger: test case 1 failed, info logged at warn level: %s", buffer.String())
+	}
+
+	stats := NewStats("https://example.com")
+	stats.observe(0)
+	stats.fail(ErrClassTimeout)
+	logger.With("seed", "https://example.com").Warn("didn't crawl page", "error_class", ErrClassTimeout, "stats", stats)
+
+	line := map[string]any{}
+	if err := json.Unmarshal(buffer.Bytes(), &line); err != nil {
+		t.Fatalf("NewLogger: test case 2 failed, output isn't json: %v", err)
+	}
+
+	if line["seed"] != "https://example.com" || line["error_class"] != ErrClassTimeout || line["level"] != "WARN" {
+		t.Errorf("NewLogger: test case 3 failed, fields missing: %v", line)
+	}
+
+	group, ok := line["stats"].(map[string]any)
+	if !ok || group["fetched"] != 1.0 || group["errors"] != 1.0 {
+		t.Errorf("NewLogger: test case 4 failed, stats not grouped: %v", line["stats"])
+	}
+
+	buffer.Reset()
+	NewLogger(buffer, Log{Level: "debug", Format: LogText}).Debug("skipped page", "reason", "robots")
+	if !strings.Contains(buffer.String(), "level=DEBUG") || !strings.Contains(buffer.String(), "reason=robots") {
+		t.Errorf("NewLogger: test case 5 failed, %s", buffer.String())
+	}
+}
@@ -0,0 +1,151 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/junwei890/crawler/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// how many sites are crawled at once
+const maxCrawlers = 1000
+
+// the collectors a run reports to, each set on a registry of its own so nothing else linked into
+// the binary leaks onto /metrics, and tests can count into a set of their own
+type metrics struct {
+	registry        *prometheus.Registry
+	frontierSize    *prometheus.GaugeVec
+	fetches         *prometheus.CounterVec
+	fetchDuration   *prometheus.HistogramVec
+	robotsDenials   *prometheus.CounterVec
+	storageDuration *prometheus.HistogramVec
+	storageErrors   *prometheus.CounterVec
+	activeCrawlers  prometheus.Gauge
+	crawlerSlots    prometheus.Gauge
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+
+		frontierSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "crawler_frontier_size",
+			Help: "URLs waiting in the queue, per host.",
+		}, []string{"host"}),
+
+		fetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "crawler_fetches_total",
+			Help: "Page fetches by status code, error for requests that never got a response.",
+		}, []string{"code"}),
+
+		fetchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "crawler_fetch_duration_seconds",
+			Help:    "Time taken to fetch and decode a page, per host.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		}, []string{"host"}),
+
+		robotsDenials: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "crawler_robots_denials_total",
+			Help: "URLs skipped because robots.txt disallows them, per host.",
+		}, []string{"host"}),
+
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "crawler_storage_batch_duration_seconds",
+			Help:    "Time taken to insert a batch of documents, per collection.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"collection"}),
+
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "crawler_storage_errors_total",
+			Help: "Batch inserts that failed, per collection.",
+		}, []string{"collection"}),
+
+		activeCrawlers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "crawler_active_crawlers",
+			Help: "Sites being crawled right now.",
+		}),
+
+		crawlerSlots: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "crawler_crawler_slots",
+			Help: "Sites that can be crawled at once, set by whichever command is running.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.frontierSize,
+		m.fetches,
+		m.fetchDuration,
+		m.robotsDenials,
+		m.storageDuration,
+		m.storageErrors,
+		m.activeCrawlers,
+		m.crawlerSlots,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// what runs report to and /metrics serves
+var defaultMetrics = newMetrics()
+
+func MetricsHandler() http.Handler {
+	return defaultMetrics.handler()
+}
+
+// fetch outcome as a status code label, failures that never got a status are just error
+func statusLabel(page utils.Page, err error) string {
+	statusErr := &utils.StatusError{}
+	if errors.As(err, &statusErr) {
+		return strconv.Itoa(statusErr.Code)
+	}
+	if err != nil || page.Status == 0 {
+		return "error"
+	}
+
+	return strconv.Itoa(page.Status)
+}
+
+// serves /metrics until the returned function is called, does nothing without an address
+func serveMetrics(addr string) func() {
+	if addr == "" {
+		return func() {}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", MetricsHandler())
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server stopped", "error", err)
+		}
+	}()
+	slog.Info("serving metrics", "addr", addr)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Error("couldn't stop metrics server", "error", err)
+		}
+	}
+}
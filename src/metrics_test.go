@@ -0,0 +1,103 @@
+package src
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/junwei890/crawler/utils"
+)
+
+func TestStatusLabel(t *testing.T) {
+	testCases := []struct {
+		name     string
+		page     utils.Page
+		err      error
+		expected string
+	}{
+		{
+			name:     "StatusLabel: test case 1",
+			page:     utils.Page{Status: 200},
+			expected: "200",
+		},
+		{
+			name:     "StatusLabel: test case 2",
+			err:      &utils.StatusError{Code: 404},
+			expected: "404",
+		},
+		{
+			name:     "StatusLabel: test case 3",
+			err:      errors.New("connection reset"),
+			expected: "error",
+		},
+		{
+			name:     "StatusLabel: test case 4",
+			page:     utils.Page{Status: 503},
+			expected: "503",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if result := statusLabel(testCase.page, testCase.err); result != testCase.expected {
+			t.Errorf("%s failed, %s != %s", testCase.name, result, testCase.expected)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	defaultMetrics.frontierSize.WithLabelValues("example.com").Set(3)
+	defaultMetrics.fetches.WithLabelValues("200").Inc()
+	defaultMetrics.fetchDuration.WithLabelValues("example.com").Observe(0.2)
+	defaultMetrics.robotsDenials.WithLabelValues("example.com").Inc()
+	defaultMetrics.storageDuration.WithLabelValues("content").Observe(0.1)
+	defaultMetrics.storageErrors.WithLabelValues("content").Inc()
+	defaultMetrics.crawlerSlots.Set(maxCrawlers)
+
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	expected := []string{
+		`crawler_frontier_size{host="example.com"} 3`,
+		`crawler_fetches_total{code="200"} 1`,
+		`crawler_fetch_duration_seconds_count{host="example.com"} 1`,
+		`crawler_robots_denials_total{host="example.com"} 1`,
+		`crawler_storage_batch_duration_seconds_count{collection="content"} 1`,
+		`crawler_storage_errors_total{collection="content"} 1`,
+		`crawler_active_crawlers 0`,
+		`crawler_crawler_slots 1000`,
+		`go_goroutines`,
+	}
+	for i, metric := range expected {
+		if !strings.Contains(string(body), metric) {
+			t.Errorf("MetricsHandler: test case %d failed, %s missing", i+1, metric)
+		}
+	}
+}
+
+func TestMetricsSiteSeries(t *testing.T) {
+	site := newFixtureSite(t)
+
+	r, _ := newMemoryRun(DefaultConfig())
+	if err := crawler(t.Context(), site.URL, r, NewStats(site.URL)); err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	r.metrics.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	// the fetches are still counted, but nothing is left labelled with the finished host
+	body := recorder.Body.String()
+	if !strings.Contains(body, "crawler_fetches_total") {
+		t.Errorf("MetricsSiteSeries: test case 1 failed, fetches missing:\n%s", body)
+	}
+	if strings.Contains(body, `host="127.0.0.1"`) {
+		t.Errorf("MetricsSiteSeries: test case 2 failed, host series left behind:\n%s", body)
+	}
+}
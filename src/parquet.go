@@ -0,0 +1,117 @@
+package src
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// bytes buffered in memory before a row group is flushed
+const parquetRowGroup = 64 * 1024 * 1024
+
+// one row per page, the search field is left out as it can be derived from content
+type parquetRow struct {
+	URL         string   `parquet:"name=url, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Host        string   `parquet:"name=host, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Language    string   `parquet:"name=language, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Title       string   `parquet:"name=title, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Content     string   `parquet:"name=content, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Structured  string   `parquet:"name=structured, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Date        string   `parquet:"name=date, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Author      string   `parquet:"name=author, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Fingerprint int64    `parquet:"name=fingerprint, type=INT64"`
+	DuplicateOf string   `parquet:"name=duplicate_of, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Anchors     []string `parquet:"name=anchors, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	PageRank    float64  `parquet:"name=pagerank, type=DOUBLE"`
+	InDegree    int64    `parquet:"name=in_degree, type=INT64"`
+	Status      int32    `parquet:"name=status, type=INT32"`
+	MediaType   string   `parquet:"name=media_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Encoding    string   `parquet:"name=encoding, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	WireBytes   int64    `parquet:"name=wire_bytes, type=INT64"`
+	Bytes       int64    `parquet:"name=bytes, type=INT64"`
+	FetchedAt   int64    `parquet:"name=fetched_at, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	LatencyMS   int64    `parquet:"name=latency_ms, type=INT64"`
+	Depth       int32    `parquet:"name=depth, type=INT32"`
+	Archive     string   `parquet:"name=archive, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Rendered    bool     `parquet:"name=rendered, type=BOOLEAN"`
+}
+
+func newParquetRow(content Content) (parquetRow, error) {
+	// structured data has no fixed shape, so it's kept as a json string
+	structured := ""
+	if len(content.Structured) > 0 {
+		encoded, err := json.Marshal(content.Structured)
+		if err != nil {
+			return parquetRow{}, err
+		}
+		structured = string(encoded)
+	}
+
+	anchors := content.Anchors
+	if anchors == nil {
+		anchors = []string{}
+	}
+
+	return parquetRow{
+		URL:         content.URL,
+		Host:        content.Host,
+		Language:    content.Language,
+		Title:       content.Title,
+		Content:     content.Content,
+		Structured:  structured,
+		Date:        content.Date,
+		Author:      content.Author,
+		Fingerprint: content.Fingerprint,
+		DuplicateOf: content.DuplicateOf,
+		Anchors:     anchors,
+		PageRank:    content.PageRank,
+		InDegree:    int64(content.InDegree),
+		Status:      int32(content.Fetch.Status),
+		MediaType:   content.Fetch.MediaType,
+		Encoding:    content.Fetch.Encoding,
+		WireBytes:   content.Fetch.WireBytes,
+		Bytes:       content.Fetch.Bytes,
+		FetchedAt:   content.Fetch.FetchedAt.UnixMilli(),
+		LatencyMS:   content.Fetch.LatencyMS,
+		Depth:       int32(content.Fetch.Depth),
+		Archive:     content.Archive,
+		Rendered:    content.Fetch.Rendered,
+	}, nil
+}
+
+// snappy compressed parquet, pages are buffered into row groups so nothing is readable till it's closed
+type ParquetSink struct {
+	file   io.WriteCloser
+	writer *writer.ParquetWriter
+}
+
+func NewParquetSink(w io.WriteCloser) (*ParquetSink, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(parquetRow), 4)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = parquetRowGroup
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	return &ParquetSink{file: w, writer: pw}, nil
+}
+
+func (p *ParquetSink) Write(record Record) error {
+	row, err := newParquetRow(record.Content)
+	if err != nil {
+		return err
+	}
+
+	return p.writer.Write(row)
+}
+
+func (p *ParquetSink) Close() error {
+	if err := p.writer.WriteStop(); err != nil {
+		p.file.Close()
+		return err
+	}
+
+	return p.file.Close()
+}
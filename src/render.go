@@ -0,0 +1,103 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/junwei890/crawler/utils"
+)
+
+var errRobotsDisallowed = errors.New("disallowed by robots.txt")
+
+// robots.txt and crawl delays of hosts rendered pages reach out to, read once per run
+type hostRules struct {
+	mu    sync.Mutex
+	hosts map[string]*hostRule
+}
+
+type hostRule struct {
+	once     sync.Once
+	rules    utils.Rules
+	throttle *utils.Throttle
+	err      error
+}
+
+// the rules for an origin like https://cdn.example.com, a robots.txt that can't be read is kept as an error
+func (h *hostRules) get(origin string) (utils.Rules, *utils.Throttle, error) {
+	h.mu.Lock()
+	if h.hosts == nil {
+		h.hosts = map[string]*hostRule{}
+	}
+	rule, ok := h.hosts[origin]
+	if !ok {
+		rule = &hostRule{}
+		h.hosts[origin] = rule
+	}
+	h.mu.Unlock()
+
+	rule.once.Do(func() {
+		file, err := utils.GetRobots(origin)
+		if err != nil {
+			rule.err = fmt.Errorf("robots.txt: %w", err)
+			return
+		}
+
+		normURL, err := utils.Normalize(origin)
+		if err != nil {
+			rule.err = err
+			return
+		}
+
+		rules, err := utils.ParseRobots(normURL, file)
+		if err != nil {
+			rule.err = fmt.Errorf("robots.txt: %w", err)
+			return
+		}
+
+		rule.rules = rules
+		rule.throttle = utils.NewThrottle(time.Duration(rules.Delay) * time.Second)
+	})
+
+	return rule.rules, rule.throttle, rule.err
+}
+
+// holds what a rendered page asks for to the same robots.txt and crawl delay as the crawler, requests
+// to the site's own host share its rules and throttle, other hosts are looked up once per run
+func (r *run) renderGate(site *url.URL, rules utils.Rules, throttle *utils.Throttle, logger *slog.Logger) utils.RequestGate {
+	return func(ctx context.Context, rawURL string) error {
+		target, err := url.Parse(rawURL)
+		if err != nil {
+			return err
+		}
+
+		// data and blob urls never leave the browser
+		if target.Scheme != "http" && target.Scheme != "https" {
+			return nil
+		}
+
+		hostRules, hostThrottle := rules, throttle
+		if target.Host != site.Host {
+			hostRules, hostThrottle, err = r.hosts.get(target.Scheme + "://" + target.Host)
+			if err != nil {
+				logger.Debug("blocked rendered request", "request", rawURL, "error", err)
+				return err
+			}
+		}
+
+		normURL, err := utils.Normalize(rawURL)
+		if err != nil {
+			return err
+		}
+		if !utils.Allowed(hostRules, normURL) {
+			logger.Debug("blocked rendered request", "request", rawURL, "error", errRobotsDisallowed)
+			return errRobotsDisallowed
+		}
+
+		return hostThrottle.Wait(ctx)
+	}
+}
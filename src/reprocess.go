@@ -0,0 +1,288 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/junwei890/crawler/search"
+	"github.com/junwei890/crawler/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// reruns extraction over the latest archived capture of every page and updates the content and links
+// collections with the results, without fetching anything
+func Reprocess(dbURI string, config Config) (*Stats, error) {
+	stats := NewStats("archive")
+
+	if config.Archive.Store == "" {
+		return stats, errors.New("no archive configured, set archive.store to disk or gridfs")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(dbURI))
+	if err != nil {
+		return stats, err
+	}
+
+	defer client.Disconnect(context.TODO())
+
+	db := client.Database("crawler")
+
+	archive, err := OpenArchive(db, config.Archive)
+	if err != nil {
+		return stats, err
+	}
+
+	var local *search.Index
+	if config.Search.Backend == BackendLocal {
+		local, err = search.Load(config.Search.Path)
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	collection := db.Collection("content")
+	store := NewMongoStore(db)
+	edges := []Edge{}
+
+	found := func(links []Edge) {
+		edges = append(edges, links...)
+	}
+
+	emit := func(content Content) error {
+		if err := updateContent(collection, content); err != nil {
+			stats.fail(ErrClassStorage)
+			return err
+		}
+
+		if local == nil {
+			return nil
+		}
+		// a page that's become a duplicate comes out of the local index, like it would never have gone in
+		if content.DuplicateOf == "" {
+			local.Add(search.Document{
+				URL:     content.URL,
+				Title:   content.Title,
+				Content: content.Content,
+			})
+		} else {
+			local.Remove(content.URL)
+		}
+
+		return nil
+	}
+
+	drop := func(url string) error {
+		if _, err := collection.DeleteOne(context.TODO(), bson.D{{Key: "_id", Value: url}}); err != nil {
+			stats.fail(ErrClassStorage)
+			return err
+		}
+
+		if local != nil {
+			local.Remove(url)
+		}
+
+		return nil
+	}
+
+	err = reprocess(archive, config, stats, found, emit, drop)
+	if err != nil {
+		return stats, err
+	}
+
+	// links found by the new extraction are added, ones it no longer finds are left in place
+	if len(edges) > 0 {
+		if err := store.SaveLinks(edges); err != nil {
+			return stats, err
+		}
+	}
+
+	if err := AggregateAnchors(db); err != nil {
+		return stats, err
+	}
+
+	// atlas search picks the changes up by itself
+	if local != nil {
+		return stats, local.Save(config.Search.Path)
+	}
+
+	return stats, nil
+}
+
+// fields set by extraction are replaced, anchors and ranks from the rest of the pipeline are kept
+func updateContent(collection *mongo.Collection, content Content) error {
+	set := bson.D{
+		{Key: "host", Value: content.Host},
+		{Key: "language", Value: content.Language},
+		{Key: "title", Value: content.Title},
+		{Key: "content", Value: content.Content},
+		{Key: "search", Value: content.Search},
+		{Key: "structured", Value: content.Structured},
+		{Key: "date", Value: content.Date},
+		{Key: "author", Value: content.Author},
+		{Key: "fingerprint", Value: content.Fingerprint},
+		{Key: "fetch", Value: content.Fetch},
+		{Key: "archive", Value: content.Archive},
+	}
+
+	update := bson.D{{Key: "$set", Value: set}}
+	if content.DuplicateOf == "" {
+		update = append(update, bson.E{Key: "$unset", Value: bson.D{{Key: "duplicate_of", Value: ""}}})
+	} else {
+		update[0].Value = append(set, bson.E{Key: "duplicate_of", Value: content.DuplicateOf})
+	}
+
+	_, err := collection.UpdateByID(context.TODO(), content.URL, update, options.Update().SetUpsert(true))
+	return err
+}
+
+type latestCapture struct {
+	key       string
+	url       string
+	fetchedAt time.Time
+	rendered  bool
+}
+
+// extracts every page from its latest capture, oldest first so duplicates point at the page first
+// crawled as they would have in the crawl, handing every page's links to found, what would have
+// been stored to emit and the url of every page that no longer would be to drop, pages that were
+// rendered are left alone
+func reprocess(archive Archive, config Config, stats *Stats, found func([]Edge), emit func(Content) error, drop func(url string) error) error {
+	// only the captures are read to find the latest, bodies are left until they're extracted
+	latest := map[string]latestCapture{}
+	err := archive.Walk(func(key string) error {
+		capture, err := archive.Head(key)
+		if err != nil {
+			return err
+		}
+
+		if current, ok := latest[capture.URL]; !ok || capture.Fetch.FetchedAt.After(current.fetchedAt) {
+			latest[capture.URL] = latestCapture{key: key, url: capture.URL, fetchedAt: capture.Fetch.FetchedAt, rendered: capture.Fetch.Rendered}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	captures := make([]latestCapture, 0, len(latest))
+	for _, capture := range latest {
+		captures = append(captures, capture)
+	}
+	sort.Slice(captures, func(i, j int) bool {
+		if !captures[i].fetchedAt.Equal(captures[j].fetchedAt) {
+			return captures[i].fetchedAt.Before(captures[j].fetchedAt)
+		}
+		return captures[i].url < captures[j].url
+	})
+
+	fingerprints := utils.NewFingerprintIndex(config.Dedup.MaxDistance)
+
+	profiles, err := utils.CompileProfiles(config.Profiles)
+	if err != nil {
+		return err
+	}
+
+	for _, latest := range captures {
+		// the archive only has the page as fetched, not what the browser made of it, so the page
+		// stored from the render is left as it is rather than rebuilt from an empty shell
+		if latest.rendered {
+			slog.Debug("skipped page", "url", latest.url, "archive", latest.key, "reason", "rendered")
+			continue
+		}
+
+		capture, err := archive.Get(latest.key)
+		if err != nil {
+			return err
+		}
+		stats.Fetched++
+
+		logger := slog.Default().With("url", capture.URL, "archive", latest.key)
+
+		dom, err := url.Parse(capture.URL)
+		if err != nil {
+			stats.fail(ErrClassInvalidURL)
+			logger.Warn("didn't reprocess page", "error_class", ErrClassInvalidURL, "error", err)
+			continue
+		}
+
+		// captures hold the body as it was sent, so it's transcoded as it would be when fetched
+		body, err := utils.TranscodeText(capture.Body, capture.Fetch.MediaType, capture.Header.Get("Content-Type"))
+		if err != nil {
+			stats.fail(ErrClassParse)
+			logger.Warn("didn't reprocess page", "error_class", ErrClassParse, "error", err)
+			continue
+		}
+
+		res, err := profiles.Parse(dom, utils.Page{URL: capture.URL, Body: body, Original: capture.Body, Status: capture.Fetch.Status, MediaType: capture.Fetch.MediaType, Header: capture.Header})
+		if err != nil {
+			stats.fail(ErrClassParse)
+			logger.Warn("didn't reprocess page", "error_class", ErrClassParse, "error", err)
+			continue
+		}
+
+		edges := []Edge{}
+		for _, link := range res.Links {
+			edges = append(edges, NewEdge(capture.URL, link))
+		}
+		found(edges)
+
+		raw := strings.Join(res.Content, " ")
+		cleaned := config.Normaliser.Normalise(raw)
+		if utf8.RuneCountInString(cleaned) < minContentLength {
+			stats.TooShort++
+			logger.Debug("skipped page", "reason", "too_short")
+			if err := drop(capture.URL); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fingerprint := utils.SimHash(cleaned)
+		duplicateOf := ""
+		if config.Dedup.Mode != DedupOff {
+			if original, ok := fingerprints.CheckAndAdd(capture.URL, fingerprint); ok {
+				if config.Dedup.Mode == DedupSkip {
+					stats.Duplicates++
+					logger.Debug("skipped page", "reason", "duplicate", "duplicate_of", original)
+					if err := drop(capture.URL); err != nil {
+						return err
+					}
+					continue
+				}
+				duplicateOf = original
+			}
+		}
+
+		content := Content{
+			URL:         capture.URL,
+			Host:        dom.Hostname(),
+			Language:    res.Language,
+			Title:       res.Title,
+			Content:     raw,
+			Search:      cleaned,
+			Structured:  res.Structured,
+			Date:        res.Date,
+			Author:      res.Author,
+			Fingerprint: int64(fingerprint),
+			DuplicateOf: duplicateOf,
+			Fetch:       capture.Fetch,
+			Archive:     latest.key,
+		}
+
+		if err := emit(content); err != nil {
+			return err
+		}
+		stats.Stored++
+	}
+
+	return nil
+}
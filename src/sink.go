@@ -0,0 +1,150 @@
+package src
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+	FormatWARC    = "warc"
+)
+
+var ErrNoExchange = errors.New("no raw request and response to archive")
+
+// a stored page as handed to sinks
+type Record struct {
+	Content Content
+	// the http exchange as it went over the wire, only kept while crawling with a sink that needs it
+	Request  []byte
+	Response []byte
+}
+
+// somewhere stored pages are written besides the content collection
+type Sink interface {
+	Write(Record) error
+	// flushes anything buffered, a sink can't be written to once it's closed
+	Close() error
+}
+
+// opens a sink writing format to path, paths ending in .gz are gzipped
+func OpenSink(format, path string) (Sink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	gzipped := strings.HasSuffix(path, ".gz")
+
+	var sink Sink
+	switch format {
+	case FormatJSONL:
+		sink = NewJSONLSink(file, gzipped)
+	case FormatParquet:
+		sink, err = NewParquetSink(file)
+	case FormatWARC:
+		sink, err = NewWARCSink(file, path, gzipped)
+	default:
+		err = fmt.Errorf("unknown sink format %s", format)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return sink, nil
+}
+
+// one json object per line
+type JSONLSink struct {
+	file    io.WriteCloser
+	gz      *gzip.Writer
+	encoder *json.Encoder
+}
+
+func NewJSONLSink(w io.WriteCloser, gzipped bool) *JSONLSink {
+	sink := &JSONLSink{file: w}
+
+	var out io.Writer = w
+	if gzipped {
+		sink.gz = gzip.NewWriter(w)
+		out = sink.gz
+	}
+	sink.encoder = json.NewEncoder(out)
+
+	return sink
+}
+
+func (j *JSONLSink) Write(record Record) error {
+	return j.encoder.Encode(record.Content)
+}
+
+func (j *JSONLSink) Close() error {
+	if j.gz != nil {
+		if err := j.gz.Close(); err != nil {
+			j.file.Close()
+			return err
+		}
+	}
+
+	return j.file.Close()
+}
+
+// every sink configured for a run, safe to write to from every site's crawler
+type sinks struct {
+	mu    sync.Mutex
+	sinks []Sink
+	// whether any sink archives the raw exchange, so pages are only fetched raw when they need to be
+	raw bool
+}
+
+func openSinks(configs []SinkConfig) (*sinks, error) {
+	s := &sinks{}
+	for _, config := range configs {
+		sink, err := OpenSink(config.Format, config.Path)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("sink %s: %w", config.Path, err)
+		}
+
+		s.sinks = append(s.sinks, sink)
+		s.raw = s.raw || config.Format == FormatWARC
+	}
+
+	return s, nil
+}
+
+// writes to every sink, carrying on past ones that fail
+func (s *sinks) Write(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := []error{}
+	for _, sink := range s.sinks {
+		if err := sink.Write(record); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *sinks) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := []error{}
+	for _, sink := range s.sinks {
+		errs = append(errs, sink.Close())
+	}
+	s.sinks = nil
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,329 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/junwei890/crawler/utils"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+var sinkContent = []Content{
+	{
+		URL:         "https://example.com/a",
+		Host:        "example.com",
+		Language:    "en",
+		Title:       "A page",
+		Content:     "some content",
+		Search:      "some content",
+		Structured:  []map[string]any{{"@type": "Article"}},
+		Fingerprint: -42,
+		Anchors:     []string{"first", "second"},
+		Fetch: Fetch{
+			Status:    200,
+			MediaType: "text/html",
+			Encoding:  "gzip",
+			WireBytes: 120,
+			Bytes:     400,
+			FetchedAt: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+			LatencyMS: 35,
+			Depth:     1,
+		},
+	},
+	{
+		URL:         "https://example.com/b",
+		Host:        "example.com",
+		Title:       "B page",
+		Content:     "more content",
+		DuplicateOf: "https://example.com/a",
+		Fetch: Fetch{
+			Status:    200,
+			MediaType: "application/pdf",
+			FetchedAt: time.Date(2025, 3, 1, 12, 0, 1, 0, time.UTC),
+			Depth:     2,
+		},
+	},
+}
+
+func TestJSONLSink(t *testing.T) {
+	for i, name := range []string{"pages.jsonl", "pages.jsonl.gz"} {
+		path := filepath.Join(t.TempDir(), name)
+
+		sink, err := OpenSink(FormatJSONL, path)
+		if err != nil {
+			t.Fatalf("error setting up test, unexpected error: %v", err)
+		}
+		for _, content := range sinkContent {
+			if err := sink.Write(Record{Content: content}); err != nil {
+				t.Errorf("JSONLSink: test case %d failed, unexpected error: %v", i+1, err)
+			}
+		}
+		if err := sink.Close(); err != nil {
+			t.Errorf("JSONLSink: test case %d failed, unexpected error: %v", i+1, err)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("error setting up test, unexpected error: %v", err)
+		}
+		defer file.Close()
+
+		var in io.Reader = file
+		if strings.HasSuffix(name, ".gz") {
+			if in, err = gzip.NewReader(file); err != nil {
+				t.Fatalf("JSONLSink: test case %d failed, unexpected error: %v", i+1, err)
+			}
+		}
+
+		result := []Content{}
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			content := Content{}
+			if err := json.Unmarshal(scanner.Bytes(), &content); err != nil {
+				t.Fatalf("JSONLSink: test case %d failed, unexpected error: %v", i+1, err)
+			}
+			result = append(result, content)
+		}
+
+		// the search field isn't exported to json, everything else survives the round trip
+		if len(result) != len(sinkContent) || result[0].Title != sinkContent[0].Title || result[0].Fetch != sinkContent[0].Fetch || result[1].DuplicateOf != sinkContent[1].DuplicateOf {
+			t.Errorf("JSONLSink: test case %d failed, %+v != %+v", i+1, result, sinkContent)
+		}
+	}
+}
+
+// reads the parquet file back, the reader needs something it can open more than once
+type parquetFile struct {
+	*os.File
+}
+
+func (p parquetFile) Open(name string) (source.ParquetFile, error) {
+	if name == "" {
+		name = p.Name()
+	}
+
+	file, err := os.Open(name)
+	return parquetFile{file}, err
+}
+
+func (p parquetFile) Create(name string) (source.ParquetFile, error) {
+	return nil, errors.New("read only")
+}
+
+func TestParquetSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pages.parquet")
+
+	sink, err := OpenSink(FormatParquet, path)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	for _, content := range sinkContent {
+		if err := sink.Write(Record{Content: content}); err != nil {
+			t.Errorf("ParquetSink: test case 1 failed, unexpected error: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("ParquetSink: test case 1 failed, unexpected error: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	defer file.Close()
+
+	pr, err := reader.NewParquetReader(parquetFile{file}, new(parquetRow), 1)
+	if err != nil {
+		t.Fatalf("ParquetSink: test case 2 failed, unexpected error: %v", err)
+	}
+	defer pr.ReadStop()
+
+	result := make([]parquetRow, pr.GetNumRows())
+	if err := pr.Read(&result); err != nil {
+		t.Fatalf("ParquetSink: test case 2 failed, unexpected error: %v", err)
+	}
+
+	for i, content := range sinkContent {
+		expected, err := newParquetRow(content)
+		if err != nil {
+			t.Fatalf("error setting up test, unexpected error: %v", err)
+		}
+
+		if i >= len(result) || !reflect.DeepEqual(result[i], expected) {
+			t.Errorf("ParquetSink: test case %d failed, %+v != %+v", i+3, result, expected)
+		}
+	}
+
+	if result[0].Structured != `[{"@type":"Article"}]` || result[0].FetchedAt != sinkContent[0].Fetch.FetchedAt.UnixMilli() {
+		t.Errorf("ParquetSink: test case 5 failed, unexpected row %+v", result[0])
+	}
+}
+
+type warcRecord struct {
+	headers map[string]string
+	block   []byte
+}
+
+// a minimal reader, enough to check the records are framed and digested properly
+func readWARC(t *testing.T, in io.Reader) []warcRecord {
+	records := []warcRecord{}
+	buffered := bufio.NewReader(in)
+
+	for {
+		version, err := buffered.ReadString('\n')
+		if errors.Is(err, io.EOF) && version == "" {
+			return records
+		}
+		if version != "WARC/1.1\r\n" {
+			t.Fatalf("readWARC failed, unexpected version line %q (%v)", version, err)
+		}
+
+		record := warcRecord{headers: map[string]string{}}
+		for {
+			line, err := buffered.ReadString('\n')
+			if err != nil {
+				t.Fatalf("readWARC failed, unexpected error: %v", err)
+			}
+			if line == "\r\n" {
+				break
+			}
+
+			name, value, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ": ")
+			record.headers[name] = value
+		}
+
+		length, err := strconv.Atoi(record.headers["Content-Length"])
+		if err != nil {
+			t.Fatalf("readWARC failed, unexpected error: %v", err)
+		}
+		record.block = make([]byte, length+4)
+		if _, err := io.ReadFull(buffered, record.block); err != nil {
+			t.Fatalf("readWARC failed, unexpected error: %v", err)
+		}
+		if !bytes.HasSuffix(record.block, []byte("\r\n\r\n")) {
+			t.Fatalf("readWARC failed, record isn't followed by two newlines")
+		}
+		record.block = record.block[:length]
+
+		records = append(records, record)
+	}
+}
+
+func TestWARCSink(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><title>A page</title></html>")
+	}))
+	defer server.Close()
+
+	page, err := utils.GetRawPage(server.URL + "/a")
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	for i, name := range []string{"pages.warc", "pages.warc.gz"} {
+		path := filepath.Join(t.TempDir(), name)
+
+		sink, err := OpenSink(FormatWARC, path)
+		if err != nil {
+			t.Fatalf("error setting up test, unexpected error: %v", err)
+		}
+		if err := sink.Write(Record{Content: sinkContent[0], Request: page.Request, Response: page.Response}); err != nil {
+			t.Errorf("WARCSink: test case %d failed, unexpected error: %v", i+1, err)
+		}
+		if err := sink.Write(Record{Content: sinkContent[1]}); !errors.Is(err, ErrNoExchange) {
+			t.Errorf("WARCSink: test case %d failed, %v != %v", i+1, err, ErrNoExchange)
+		}
+		if err := sink.Close(); err != nil {
+			t.Errorf("WARCSink: test case %d failed, unexpected error: %v", i+1, err)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("error setting up test, unexpected error: %v", err)
+		}
+		defer file.Close()
+
+		var in io.Reader = file
+		if strings.HasSuffix(name, ".gz") {
+			if in, err = gzip.NewReader(file); err != nil {
+				t.Fatalf("WARCSink: test case %d failed, unexpected error: %v", i+1, err)
+			}
+		}
+		records := readWARC(t, in)
+
+		types := []string{}
+		for _, record := range records {
+			types = append(types, record.headers["WARC-Type"])
+
+			if record.headers["WARC-Block-Digest"] != digest(record.block) {
+				t.Errorf("WARCSink: test case %d failed, %s record digest doesn't match its block", i+1, record.headers["WARC-Type"])
+			}
+		}
+		if expected := []string{"warcinfo", "response", "request", "metadata"}; !reflect.DeepEqual(types, expected) {
+			t.Fatalf("WARCSink: test case %d failed, %v != %v", i+1, types, expected)
+		}
+
+		response, request, metadata := records[1], records[2], records[3]
+		if !bytes.Equal(response.block, page.Response) || !bytes.Equal(request.block, page.Request) {
+			t.Errorf("WARCSink: test case %d failed, exchange wasn't archived as fetched", i+1)
+		}
+		if response.headers["WARC-Target-URI"] != sinkContent[0].URL || response.headers["WARC-Date"] != "2025-03-01T12:00:00Z" {
+			t.Errorf("WARCSink: test case %d failed, unexpected response headers %v", i+1, response.headers)
+		}
+		if request.headers["WARC-Concurrent-To"] != response.headers["WARC-Record-ID"] || metadata.headers["WARC-Refers-To"] != response.headers["WARC-Record-ID"] {
+			t.Errorf("WARCSink: test case %d failed, records don't point at the response", i+1)
+		}
+		if response.headers["WARC-Warcinfo-ID"] != records[0].headers["WARC-Record-ID"] {
+			t.Errorf("WARCSink: test case %d failed, records don't point at the warcinfo", i+1)
+		}
+		if !bytes.Contains(metadata.block, []byte("fingerprint: -42\r\n")) {
+			t.Errorf("WARCSink: test case %d failed, unexpected metadata %q", i+1, metadata.block)
+		}
+	}
+}
+
+func TestOpenSinks(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := openSinks([]SinkConfig{{Format: FormatJSONL, Path: filepath.Join(dir, "pages.jsonl")}})
+	if err != nil || s.raw {
+		t.Errorf("OpenSinks: test case 1 failed, raw %t (%v)", s.raw, err)
+	}
+	s.Close()
+
+	s, err = openSinks([]SinkConfig{{Format: FormatJSONL, Path: filepath.Join(dir, "pages.jsonl")}, {Format: FormatWARC, Path: filepath.Join(dir, "pages.warc")}})
+	if err != nil || !s.raw {
+		t.Errorf("OpenSinks: test case 2 failed, raw %t (%v)", s.raw, err)
+	}
+
+	// the jsonl sink still gets the page when the warc sink can't take it
+	if err := s.Write(Record{Content: sinkContent[0]}); !errors.Is(err, ErrNoExchange) {
+		t.Errorf("OpenSinks: test case 3 failed, %v != %v", err, ErrNoExchange)
+	}
+	s.Close()
+
+	written, err := os.ReadFile(filepath.Join(dir, "pages.jsonl"))
+	if err != nil || bytes.Count(written, []byte("\n")) != 1 {
+		t.Errorf("OpenSinks: test case 4 failed, %q (%v)", written, err)
+	}
+
+	if _, err := openSinks([]SinkConfig{{Format: FormatJSONL, Path: filepath.Join(dir, "missing", "pages.jsonl")}}); err == nil {
+		t.Errorf("OpenSinks: test case 5 failed, expected error for a missing directory")
+	}
+}
@@ -0,0 +1,172 @@
+package src
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/junwei890/crawler/utils"
+)
+
+// what went wrong, kept coarse so counts across runs are comparable
+const (
+	ErrClassTimeout    = "timeout"
+	ErrClassDNS        = "dns"
+	ErrClassTLS        = "tls"
+	ErrClassConnection = "connection"
+	ErrClassMediaType  = "media_type"
+	ErrClassTooLarge   = "too_large"
+	ErrClassInvalidURL = "invalid_url"
+	ErrClassParse      = "parse"
+	ErrClassRender     = "render"
+	ErrClassRobots     = "robots"
+	ErrClassStorage    = "storage"
+	ErrClassOther      = "other"
+)
+
+// per site counters, only touched by the goroutine crawling that site
+type Stats struct {
+	Site string `bson:"site"`
+	// pages that came back from the server, whether or not they were stored
+	Fetched int `bson:"fetched"`
+	Stored  int `bson:"stored"`
+	// near duplicates that weren't stored
+	Duplicates int `bson:"duplicates"`
+	Robots     int `bson:"skipped_robots"`
+	OffDomain  int `bson:"skipped_off_domain"`
+	TooShort   int `bson:"skipped_too_short"`
+	// most urls ever waiting in the frontier at once
+	FrontierPeak int `bson:"frontier_peak"`
+	// counts keyed by one of the ErrClass constants, or http_<code> for refused pages
+	Errors map[string]int `bson:"errors"`
+	// bytes as transferred, before decompression
+	WireBytes int64 `bson:"wire_bytes"`
+	// bytes after decompression
+	Bytes          int64         `bson:"bytes"`
+	AverageLatency time.Duration `bson:"average_latency"`
+
+	latency time.Duration
+}
+
+func NewStats(site string) *Stats {
+	return &Stats{
+		Site:   site,
+		Errors: map[string]int{},
+	}
+}
+
+// records a page that was fetched successfully and how long it took
+func (s *Stats) observe(latency time.Duration) {
+	s.Fetched++
+	s.latency += latency
+	s.AverageLatency = s.latency / time.Duration(s.Fetched)
+}
+
+func (s *Stats) fail(class string) {
+	s.Errors[class]++
+}
+
+func (s *Stats) ErrorCount() int {
+	total := 0
+	for _, count := range s.Errors {
+		total += count
+	}
+
+	return total
+}
+
+// logged as a group of fields rather than one long line
+func (s *Stats) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("fetched", s.Fetched),
+		slog.Int("stored", s.Stored),
+		slog.Int("duplicates", s.Duplicates),
+		slog.Int("skipped_robots", s.Robots),
+		slog.Int("skipped_off_domain", s.OffDomain),
+		slog.Int("skipped_too_short", s.TooShort),
+		slog.Int("frontier_peak", s.FrontierPeak),
+		slog.Int("errors", s.ErrorCount()),
+		slog.Int64("wire_bytes", s.WireBytes),
+		slog.Int64("bytes", s.Bytes),
+		slog.Duration("average_latency", s.AverageLatency),
+	)
+}
+
+// sorted class=count pairs, - when nothing failed
+func formatErrors(errs map[string]int) string {
+	if len(errs) == 0 {
+		return "-"
+	}
+
+	pairs := []string{}
+	for _, class := range slices.Sorted(maps.Keys(errs)) {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", class, errs[class]))
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// sorts a failed fetch into an error class
+func classifyError(err error) string {
+	statusErr := &utils.StatusError{}
+	if errors.As(err, &statusErr) {
+		return fmt.Sprintf("http_%d", statusErr.Code)
+	}
+
+	switch {
+	case errors.Is(err, utils.ErrUnsupportedType):
+		return ErrClassMediaType
+	case errors.Is(err, utils.ErrBodyTooLarge):
+		return ErrClassTooLarge
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrClassTimeout
+	}
+
+	netErr := net.Error(nil)
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrClassTimeout
+	}
+
+	dnsErr := &net.DNSError{}
+	if errors.As(err, &dnsErr) {
+		return ErrClassDNS
+	}
+
+	certErr := &tls.CertificateVerificationError{}
+	recordErr := tls.RecordHeaderError{}
+	hostErr := x509.HostnameError{}
+	authorityErr := x509.UnknownAuthorityError{}
+	if errors.As(err, &certErr) || errors.As(err, &recordErr) || errors.As(err, &hostErr) || errors.As(err, &authorityErr) {
+		return ErrClassTLS
+	}
+
+	opErr := &net.OpError{}
+	if errors.As(err, &opErr) {
+		return ErrClassConnection
+	}
+
+	return ErrClassOther
+}
@@ -0,0 +1,174 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/junwei890/crawler/utils"
+)
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "ClassifyError: test case 1",
+			err:      &utils.StatusError{Code: 404},
+			expected: "http_404",
+		},
+		{
+			name:     "ClassifyError: test case 2",
+			err:      fmt.Errorf("%w image/png", utils.ErrUnsupportedType),
+			expected: ErrClassMediaType,
+		},
+		{
+			name:     "ClassifyError: test case 3",
+			err:      fmt.Errorf("%w, more than 10 bytes", utils.ErrBodyTooLarge),
+			expected: ErrClassTooLarge,
+		},
+		{
+			name:     "ClassifyError: test case 4",
+			err:      fmt.Errorf("get: %w", context.DeadlineExceeded),
+			expected: ErrClassTimeout,
+		},
+		{
+			name:     "ClassifyError: test case 5",
+			err:      &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "nowhere.invalid", IsNotFound: true}},
+			expected: ErrClassDNS,
+		},
+		{
+			name:     "ClassifyError: test case 6",
+			err:      &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+			expected: ErrClassConnection,
+		},
+		{
+			name:     "ClassifyError: test case 7",
+			err:      fmt.Errorf("get: %w", x509.UnknownAuthorityError{}),
+			expected: ErrClassTLS,
+		},
+		{
+			name:     "ClassifyError: test case 8",
+			err:      errors.New("something else"),
+			expected: ErrClassOther,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if result := classifyError(testCase.err); result != testCase.expected {
+				t.Errorf("%s failed, %s != %s", testCase.name, result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	stats := NewStats("https://example.com")
+	stats.observe(100 * time.Millisecond)
+	stats.observe(300 * time.Millisecond)
+	stats.fail("http_404")
+	stats.fail("http_404")
+	stats.fail(ErrClassTimeout)
+
+	if stats.Fetched != 2 {
+		t.Errorf("Stats: test case 1 failed, %d != %d", stats.Fetched, 2)
+	}
+	if stats.AverageLatency != 200*time.Millisecond {
+		t.Errorf("Stats: test case 2 failed, %v != %v", stats.AverageLatency, 200*time.Millisecond)
+	}
+	if stats.ErrorCount() != 3 {
+		t.Errorf("Stats: test case 3 failed, %d != %d", stats.ErrorCount(), 3)
+	}
+	if result := formatErrors(stats.Errors); result != "http_404=2 timeout=1" {
+		t.Errorf("Stats: test case 4 failed, %s != %s", result, "http_404=2 timeout=1")
+	}
+	if result := formatErrors(map[string]int{}); result != "-" {
+		t.Errorf("Stats: test case 5 failed, %s != %s", result, "-")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    int64
+		expected string
+	}{
+		{
+			name:     "FormatBytes: test case 1",
+			input:    512,
+			expected: "512 B",
+		},
+		{
+			name:     "FormatBytes: test case 2",
+			input:    1536,
+			expected: "1.5 KiB",
+		},
+		{
+			name:     "FormatBytes: test case 3",
+			input:    10 << 20,
+			expected: "10.0 MiB",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if result := formatBytes(testCase.input); result != testCase.expected {
+				t.Errorf("%s failed, %s != %s", testCase.name, result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestSummaryPrint(t *testing.T) {
+	summary, err := newSummary([]string{"https://a.com", "https://longer.example.com"}, DefaultConfig())
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	summary.EndedAt = summary.StartedAt.Add(90 * time.Second)
+
+	summary.Sites[0].observe(250 * time.Millisecond)
+	summary.Sites[0].Stored = 1
+	summary.Sites[0].WireBytes = 2048
+	summary.Sites[1].fail(ErrClassRobots)
+	summary.Index = IndexStatus{Name: "search_index", Status: IndexReady, Queryable: true}
+
+	if summary.Config["analyzer"] != "lucene.english" {
+		t.Errorf("SummaryPrint: test case 1 failed, %v != %s", summary.Config["analyzer"], "lucene.english")
+	}
+
+	buffer := &bytes.Buffer{}
+	summary.Print(buffer)
+	lines := strings.Split(buffer.String(), "\n")
+
+	if !strings.HasSuffix(lines[0], "(1m30s)") {
+		t.Errorf("SummaryPrint: test case 2 failed, %q missing duration", lines[0])
+	}
+
+	// columns line up, so every value starts where its header does
+	header, first, second := lines[2], lines[3], lines[4]
+	for _, column := range []string{"FETCHED", "DOWNLOADED", "ERRORS"} {
+		at := strings.Index(header, column)
+		if at == -1 || first[at] == ' ' || second[at] == ' ' {
+			t.Errorf("SummaryPrint: test case 3 failed, %s column misaligned:\n%s", column, buffer.String())
+		}
+	}
+
+	if !strings.Contains(first, "2.0 KiB") || !strings.Contains(first, "250ms") {
+		t.Errorf("SummaryPrint: test case 4 failed, %q", first)
+	}
+	if !strings.HasSuffix(second, "robots=1") {
+		t.Errorf("SummaryPrint: test case 5 failed, %q", second)
+	}
+	if !strings.Contains(buffer.String(), "status:    READY") {
+		t.Errorf("SummaryPrint: test case 6 failed, index status missing:\n%s", buffer.String())
+	}
+}
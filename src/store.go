@@ -0,0 +1,117 @@
+package src
+
+import (
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// where each site's crawled pages and links end up once it's done
+type Store interface {
+	// pages already stored under the same url are kept as they are
+	SaveContent(content []Content) error
+	SaveLinks(edges []Edge) error
+}
+
+// the content and links collections
+type mongoStore struct {
+	content *mongo.Collection
+	links   *mongo.Collection
+	metrics *metrics
+}
+
+func NewMongoStore(db *mongo.Database) Store {
+	return &mongoStore{
+		content: db.Collection("content"),
+		links:   db.Collection("links"),
+		metrics: defaultMetrics,
+	}
+}
+
+// if id already exists in the collection don't error and continue inserting
+var unordered = options.InsertMany().SetOrdered(false)
+
+// pages refetched by a resumed or repeated run are already stored, so duplicate keys are fine
+func (m *mongoStore) SaveContent(content []Content) error {
+	documents := make([]any, 0, len(content))
+	for _, page := range content {
+		documents = append(documents, page)
+	}
+
+	if err := insertBatch(m.metrics, m.content, documents, unordered); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	return nil
+}
+
+// edges already stored from a previous run are expected, so duplicate keys are fine
+func (m *mongoStore) SaveLinks(edges []Edge) error {
+	documents := make([]any, 0, len(edges))
+	for _, edge := range edges {
+		documents = append(documents, edge)
+	}
+
+	if err := insertBatch(m.metrics, m.links, documents, unordered); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	return nil
+}
+
+// keeps pages and links in the order they were saved, for tests and trying out configs without a database
+type MemoryStore struct {
+	mu      sync.Mutex
+	content []Content
+	links   []Edge
+	seen    map[string]struct{}
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{seen: map[string]struct{}{}}
+}
+
+func (m *MemoryStore) SaveContent(content []Content) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, page := range content {
+		if _, ok := m.seen["content "+page.URL]; ok {
+			continue
+		}
+		m.seen["content "+page.URL] = struct{}{}
+		m.content = append(m.content, page)
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) SaveLinks(edges []Edge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, edge := range edges {
+		if _, ok := m.seen["link "+edge.ID]; ok {
+			continue
+		}
+		m.seen["link "+edge.ID] = struct{}{}
+		m.links = append(m.links, edge)
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) Content() []Content {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Content{}, m.content...)
+}
+
+func (m *MemoryStore) Links() []Edge {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Edge{}, m.links...)
+}
@@ -0,0 +1,48 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/junwei890/crawler/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMongoStoreDuplicates(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	// an unordered insert that hits stored keys comes back as a bulk write error
+	duplicate := mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"})
+	failure := mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 2, Message: "bad value"})
+
+	mt.Run("content", func(mt *mtest.T) {
+		store := NewMongoStore(mt.DB)
+		page := Content{URL: "https://example.com/"}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		if err := store.SaveContent([]Content{page}); err != nil {
+			t.Fatalf("error setting up test, unexpected error: %v", err)
+		}
+
+		mt.AddMockResponses(duplicate)
+		if err := store.SaveContent([]Content{page}); err != nil {
+			t.Errorf("SaveContent: test case 1 failed, %v != %v", err, nil)
+		}
+
+		mt.AddMockResponses(failure)
+		if err := store.SaveContent([]Content{page}); err == nil || mongo.IsDuplicateKeyError(err) {
+			t.Errorf("SaveContent: test case 2 failed, %v != %v", err, "write error")
+		}
+	})
+
+	mt.Run("links", func(mt *mtest.T) {
+		store := NewMongoStore(mt.DB)
+		edge := NewEdge("https://example.com/", utils.Link{URL: "https://example.com/a"})
+
+		mt.AddMockResponses(duplicate)
+		if err := store.SaveLinks([]Edge{edge}); err != nil {
+			t.Errorf("SaveLinks: test case 1 failed, %v != %v", err, nil)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// what a run did, printed once it's over and stored in crawl_runs
+type Summary struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	StartedAt time.Time          `bson:"started_at"`
+	EndedAt   time.Time          `bson:"ended_at"`
+	Seeds     []string           `bson:"seeds"`
+	// the config as it would appear in crawler.json
+	Config map[string]any `bson:"config"`
+	// in the same order as the seeds
+	Sites []*Stats    `bson:"sites"`
+	Index IndexStatus `bson:"index"`
+	Error string      `bson:"error,omitempty"`
+	// set when the run was one worker's share of a distributed crawl
+	Worker string `bson:"worker,omitempty"`
+}
+
+func newSummary(seeds []string, config Config) (Summary, error) {
+	summary := Summary{
+		ID:        primitive.NewObjectID(),
+		StartedAt: time.Now().UTC(),
+		Seeds:     seeds,
+		Sites:     []*Stats{},
+	}
+
+	// round tripping through json keeps the snapshot's keys in line with the config file
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return summary, err
+	}
+	if err := json.Unmarshal(encoded, &summary.Config); err != nil {
+		return summary, err
+	}
+
+	for _, seed := range seeds {
+		summary.Sites = append(summary.Sites, NewStats(seed))
+	}
+
+	return summary, nil
+}
+
+func recordRun(db *mongo.Database, summary Summary) error {
+	_, err := db.Collection("crawl_runs").InsertOne(context.TODO(), summary)
+	return err
+}
+
+func (s Summary) Print(w io.Writer) {
+	if !s.StartedAt.IsZero() {
+		fmt.Fprintf(w, "run %s: %s to %s (%s)\n", s.ID.Hex(), s.StartedAt.Format(time.RFC3339), s.EndedAt.Format(time.RFC3339), s.EndedAt.Sub(s.StartedAt).Round(time.Second))
+		if s.Worker != "" {
+			fmt.Fprintf(w, "worker %s\n", s.Worker)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(s.Sites) > 0 {
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "SITE\tFETCHED\tSTORED\tDUPLICATES\tROBOTS\tOFF-DOMAIN\tTOO SHORT\tDOWNLOADED\tAVG LATENCY\tERRORS")
+		for _, site := range s.Sites {
+			fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\n",
+				site.Site,
+				site.Fetched,
+				site.Stored,
+				site.Duplicates,
+				site.Robots,
+				site.OffDomain,
+				site.TooShort,
+				formatBytes(site.WireBytes),
+				site.AverageLatency.Round(time.Millisecond),
+				formatErrors(site.Errors),
+			)
+		}
+		table.Flush()
+		fmt.Fprintln(w)
+	}
+
+	// only the last worker to finish builds the index
+	if s.Worker != "" && s.Index.Status == "" {
+		fmt.Fprintln(w, "search index: left to the last worker to finish")
+		return
+	}
+
+	fmt.Fprintln(w, "search index:")
+	fmt.Fprintf(w, "  name:      %s\n", s.Index.Name)
+	fmt.Fprintf(w, "  status:    %s\n", s.Index.Status)
+	fmt.Fprintf(w, "  queryable: %t\n", s.Index.Queryable)
+	if s.Index.Message != "" {
+		fmt.Fprintf(w, "  message:   %s\n", s.Index.Message)
+	}
+}
@@ -0,0 +1,153 @@
+package src
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1" // #nosec G505 -- WARC digests are sha1 by convention, they aren't used for security
+	"encoding/base32"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// WARC/1.1 with a request, response and metadata record per page, gzipped per record when the path
+// ends in .gz so tools like pywb can seek straight to a page
+type WARCSink struct {
+	file    io.WriteCloser
+	gzipped bool
+	// every record points back at the warcinfo record at the start of the file
+	info string
+}
+
+func NewWARCSink(w io.WriteCloser, path string, gzipped bool) (*WARCSink, error) {
+	sink := &WARCSink{file: w, gzipped: gzipped, info: recordID()}
+
+	fields := &bytes.Buffer{}
+	fields.WriteString("software: github.com/junwei890/crawler\r\n")
+	fields.WriteString("format: WARC File Format 1.1\r\n")
+	fields.WriteString("conformsTo: https://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\n")
+
+	err := sink.record([][2]string{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", sink.info},
+		{"WARC-Date", warcDate(time.Now())},
+		{"WARC-Filename", filepath.Base(path)},
+		{"Content-Type", "application/warc-fields"},
+	}, fields.Bytes())
+
+	return sink, err
+}
+
+func (s *WARCSink) Write(record Record) error {
+	if len(record.Request) == 0 || len(record.Response) == 0 {
+		return fmt.Errorf("%w for %s", ErrNoExchange, record.Content.URL)
+	}
+
+	date := warcDate(record.Content.Fetch.FetchedAt)
+	requestID, responseID := recordID(), recordID()
+
+	// the payload digest covers the body alone, so identical pages can be spotted across captures
+	_, payload, _ := bytes.Cut(record.Response, []byte("\r\n\r\n"))
+
+	if err := s.record([][2]string{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Warcinfo-ID", s.info},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", record.Content.URL},
+		{"WARC-Payload-Digest", digest(payload)},
+		{"Content-Type", "application/http;msgtype=response"},
+	}, record.Response); err != nil {
+		return err
+	}
+
+	if err := s.record([][2]string{
+		{"WARC-Type", "request"},
+		{"WARC-Record-ID", requestID},
+		{"WARC-Warcinfo-ID", s.info},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", record.Content.URL},
+		{"WARC-Concurrent-To", responseID},
+		{"Content-Type", "application/http;msgtype=request"},
+	}, record.Request); err != nil {
+		return err
+	}
+
+	// what the crawler made of the page, so an archive can be reprocessed without refetching
+	fields := &bytes.Buffer{}
+	fmt.Fprintf(fields, "title: %s\r\n", warcField(record.Content.Title))
+	if record.Content.Language != "" {
+		fmt.Fprintf(fields, "language: %s\r\n", record.Content.Language)
+	}
+	fmt.Fprintf(fields, "fingerprint: %d\r\n", record.Content.Fingerprint)
+	fmt.Fprintf(fields, "depth: %d\r\n", record.Content.Fetch.Depth)
+	fmt.Fprintf(fields, "fetchTimeMs: %d\r\n", record.Content.Fetch.LatencyMS)
+	if record.Content.DuplicateOf != "" {
+		fmt.Fprintf(fields, "duplicateOf: %s\r\n", record.Content.DuplicateOf)
+	}
+
+	return s.record([][2]string{
+		{"WARC-Type", "metadata"},
+		{"WARC-Record-ID", recordID()},
+		{"WARC-Warcinfo-ID", s.info},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", record.Content.URL},
+		{"WARC-Refers-To", responseID},
+		{"Content-Type", "application/warc-fields"},
+	}, fields.Bytes())
+}
+
+func (s *WARCSink) record(headers [][2]string, block []byte) error {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString("WARC/1.1\r\n")
+	for _, header := range headers {
+		fmt.Fprintf(buffer, "%s: %s\r\n", header[0], header[1])
+	}
+	fmt.Fprintf(buffer, "WARC-Block-Digest: %s\r\n", digest(block))
+	fmt.Fprintf(buffer, "Content-Length: %d\r\n\r\n", len(block))
+	buffer.Write(block)
+	buffer.WriteString("\r\n\r\n")
+
+	if !s.gzipped {
+		_, err := s.file.Write(buffer.Bytes())
+		return err
+	}
+
+	// a gzip member per record, concatenated members still read as one gzip stream
+	gz := gzip.NewWriter(s.file)
+	if _, err := gz.Write(buffer.Bytes()); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+func (s *WARCSink) Close() error {
+	return s.file.Close()
+}
+
+func warcDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// headers can't span lines
+func warcField(value string) string {
+	return string(bytes.Join(bytes.Fields([]byte(value)), []byte(" ")))
+}
+
+func digest(block []byte) string {
+	sum := sha1.Sum(block) // #nosec G401
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// a random version 4 uuid
+func recordID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
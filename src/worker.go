@@ -0,0 +1,231 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// how long a worker waits on the release of a host once it's been told to stop
+const releaseTimeout = 10 * time.Second
+
+// crawls hosts claimed from the lease store until every host is done, any number of workers can run
+// against the same store and the last one to finish aggregates anchors and builds the search index
+func Work(dbURI string, seeds []string, config Config) (summary Summary, err error) {
+	// each worker would only index the pages it crawled itself
+	if config.Search.Backend == BackendLocal {
+		return summary, errors.New("workers can't share the local search index, use the atlas backend")
+	}
+
+	// workers only find each other's hosts through the run they share
+	if config.Workers.Run == "" {
+		return summary, errors.New("workers run has to name the crawl every worker joins")
+	}
+
+	summary, err = newSummary([]string{}, config)
+	if err != nil {
+		return summary, err
+	}
+	summary.Worker = workerID()
+
+	// a worker told to stop hands its hosts back before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(dbURI))
+	if err != nil {
+		return summary, err
+	}
+
+	defer client.Disconnect(context.TODO())
+
+	db := client.Database("crawler")
+
+	defer func() {
+		summary.EndedAt = time.Now().UTC()
+		if err != nil {
+			summary.Error = err.Error()
+		}
+
+		if recordErr := recordRun(db, summary); recordErr != nil {
+			slog.Error("couldn't record run", "run", summary.ID.Hex(), "error", recordErr)
+		}
+	}()
+
+	// joining workers have nothing to seed, so the run is only marked seeded by those that do
+	store := NewLeaseStore(db, config.Workers)
+	if err := store.Seed(ctx, seeds); err != nil {
+		return summary, err
+	}
+
+	r, err := newRun(db, config)
+	if err != nil {
+		return summary, err
+	}
+	defer r.close()
+	r.metrics.crawlerSlots.Set(float64(config.Workers.Concurrency))
+
+	stopMetrics := serveMetrics(config.Metrics.Addr)
+	defer stopMetrics()
+
+	mu := &sync.Mutex{}
+	workLeases(ctx, store, summary.Worker, config.Workers, func(ctx context.Context, lease Lease) {
+		for _, seed := range lease.Seeds {
+			if ctx.Err() != nil {
+				return
+			}
+
+			stats := NewStats(seed)
+			mu.Lock()
+			summary.Seeds = append(summary.Seeds, seed)
+			summary.Sites = append(summary.Sites, stats)
+			mu.Unlock()
+
+			r.metrics.activeCrawlers.Inc()
+			if err := crawler(ctx, seed, r, stats); err != nil {
+				slog.Error("didn't crawl site", "seed", seed, "error", err)
+			}
+			r.metrics.activeCrawlers.Dec()
+		}
+	})
+
+	if ctx.Err() != nil {
+		return summary, fmt.Errorf("worker stopped before every host was crawled: %w", ctx.Err())
+	}
+
+	finishing, err := store.Finish(ctx, summary.Worker)
+	if err != nil || !finishing {
+		return summary, err
+	}
+
+	slog.Info("every host is done, finishing the run", "worker", summary.Worker)
+	summary.Index, err = finishRun(client, db, r)
+
+	return summary, err
+}
+
+// unique across processes on one machine and across machines
+func workerID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "worker"
+	}
+
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// runs config.Concurrency claim loops, returning once every host is done or ctx is cancelled
+func workLeases(ctx context.Context, store LeaseStore, worker string, config Workers, crawl func(context.Context, Lease)) {
+	wg := &sync.WaitGroup{}
+	for range config.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			claimLoop(ctx, store, worker, config, crawl)
+		}()
+	}
+	wg.Wait()
+}
+
+func claimLoop(ctx context.Context, store LeaseStore, worker string, config Workers, crawl func(context.Context, Lease)) {
+	for ctx.Err() == nil {
+		lease, err := store.Claim(ctx, worker, time.Duration(config.TTL))
+		if errors.Is(err, ErrNoLease) {
+			remaining, err := store.Remaining(ctx)
+			if err == nil && remaining == 0 {
+				return
+			}
+
+			// hosts held by other workers come back up for claiming if those workers die
+			pause(ctx, time.Duration(config.Heartbeat))
+			continue
+		}
+		if err != nil {
+			slog.Error("couldn't claim a host", "worker", worker, "error", err)
+			pause(ctx, time.Duration(config.Heartbeat))
+			continue
+		}
+
+		holdLease(ctx, store, lease, config, crawl)
+	}
+}
+
+// crawls the lease's host while renewing it every heartbeat, then releases it
+func holdLease(ctx context.Context, store LeaseStore, lease Lease, config Workers, crawl func(context.Context, Lease)) {
+	logger := slog.Default().With("worker", lease.Worker, "host", lease.Host)
+
+	// cancelled if the lease is lost, so two workers never crawl the same host for long
+	leaseCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lost := make(chan struct{})
+	beating := make(chan struct{})
+	go func() {
+		defer close(beating)
+
+		ticker := time.NewTicker(time.Duration(config.Heartbeat))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+				err := store.Renew(leaseCtx, lease, time.Duration(config.TTL))
+				if errors.Is(err, ErrLeaseLost) {
+					logger.Warn("lost lease, stopping")
+					close(lost)
+					cancel()
+					return
+				}
+				if err != nil && leaseCtx.Err() == nil {
+					logger.Warn("couldn't renew lease", "error", err)
+				}
+			}
+		}
+	}()
+
+	logger.Info("claimed host", "seeds", len(lease.Seeds))
+	crawl(leaseCtx, lease)
+
+	cancel()
+	<-beating
+
+	select {
+	case <-lost:
+		return
+	default:
+	}
+
+	// a worker that's stopping puts the host back rather than leaving it to expire
+	done := ctx.Err() == nil
+
+	releaseCtx, stop := context.WithTimeout(context.Background(), releaseTimeout)
+	defer stop()
+
+	if err := store.Release(releaseCtx, lease, done); err != nil {
+		logger.Error("couldn't release host", "error", err)
+		return
+	}
+	logger.Info("released host", "done", done)
+}
+
+func pause(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
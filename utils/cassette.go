@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// every request made by the crawler goes through this client, swapping its transport records or replays a crawl
+var Client = &http.Client{}
+
+var ErrNotRecorded = errors.New("request not in the cassette")
+
+// one request and what came back, either a response or the error the transport returned
+type Interaction struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	// as it came off the wire, still in any content encoding
+	Body    []byte `json:"body,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Timeout bool   `json:"timeout,omitempty"`
+}
+
+// interactions are stored one per file, named by a hash of the method and url, so the latest recording
+// of a request wins and a cassette can be diffed and edited by hand
+func cassettePath(dir string, req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.String()))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// passes requests on to next and writes every interaction to a cassette directory
+type Recorder struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func NewRecorder(dir string, next http.RoundTripper) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &Recorder{dir: dir, next: next}, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	interaction := Interaction{Method: req.Method, URL: req.URL.String()}
+
+	res, err := r.next.RoundTrip(req)
+	if err != nil {
+		interaction.Error = err.Error()
+		netErr := interface{ Timeout() bool }(nil)
+		interaction.Timeout = errors.As(err, &netErr) && netErr.Timeout()
+
+		if saveErr := r.save(req, interaction); saveErr != nil {
+			return nil, saveErr
+		}
+		return nil, err
+	}
+
+	// the body is read so it can be stored, then handed back as if it came straight from the server,
+	// only a byte past MaxBodySize is kept since that's all the crawler reads before giving up on it
+	body, err := io.ReadAll(io.LimitReader(res.Body, MaxBodySize+1))
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+
+	interaction.Status = res.StatusCode
+	interaction.Header = res.Header
+	interaction.Body = body
+
+	return res, r.save(req, interaction)
+}
+
+// concurrent crawlers never leave half an interaction behind
+func (r *Recorder) save(req *http.Request, interaction Interaction) error {
+	encoded, err := json.MarshalIndent(interaction, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(cassettePath(r.dir, req), encoded)
+}
+
+// answers requests from a cassette directory without touching the network, anything not recorded fails
+type Replayer struct {
+	dir string
+}
+
+func NewReplayer(dir string) (*Replayer, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s isn't a directory", dir)
+	}
+
+	return &Replayer{dir: dir}, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	encoded, err := os.ReadFile(cassettePath(r.dir, req))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s %s", ErrNotRecorded, req.Method, req.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := Interaction{}
+	if err := json.Unmarshal(encoded, &interaction); err != nil {
+		return nil, err
+	}
+
+	if interaction.Error != "" {
+		return nil, &replayedError{message: interaction.Error, timeout: interaction.Timeout}
+	}
+
+	if interaction.Header == nil {
+		interaction.Header = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+		StatusCode:    interaction.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        interaction.Header,
+		Body:          io.NopCloser(bytes.NewReader(interaction.Body)),
+		ContentLength: int64(len(interaction.Body)),
+		Request:       req,
+	}, nil
+}
+
+// a transport error as it was recorded, keeping whether it was a timeout so it's classified the same way
+type replayedError struct {
+	message string
+	timeout bool
+}
+
+func (e *replayedError) Error() string   { return e.message }
+func (e *replayedError) Timeout() bool   { return e.timeout }
+func (e *replayedError) Temporary() bool { return false }
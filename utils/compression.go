@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// sent on every page request, setting it ourselves turns off Go's transparent gzip
+const AcceptEncoding = "gzip, deflate, br, zstd"
+
+// caps the decompressed size of a body so a small compressed response can't blow up in memory
+var MaxBodySize int64 = 10 << 20
+
+// counts bytes as they come off the wire, before any decoding
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+
+	return n, err
+}
+
+// reads a body whose Content-Encoding may list several codings, undoing them in reverse order
+func decodeBody(body io.Reader, contentEncoding string) ([]byte, error) {
+	codings := []string{}
+	for _, coding := range strings.Split(contentEncoding, ",") {
+		if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" && coding != "identity" {
+			codings = append(codings, coding)
+		}
+	}
+
+	reader := body
+	closers := []io.Closer{}
+	defer func() {
+		for _, closer := range closers {
+			closer.Close()
+		}
+	}()
+
+	for i := len(codings) - 1; i >= 0; i-- {
+		switch codings[i] {
+		case "gzip", "x-gzip":
+			gz, err := gzip.NewReader(reader)
+			if err != nil {
+				return []byte{}, err
+			}
+			closers = append(closers, gz)
+			reader = gz
+		case "deflate":
+			deflate, err := newDeflateReader(reader)
+			if err != nil {
+				return []byte{}, err
+			}
+			closers = append(closers, deflate)
+			reader = deflate
+		case "br":
+			reader = brotli.NewReader(reader)
+		case "zstd":
+			zs, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(MaxBodySize)))
+			if err != nil {
+				return []byte{}, err
+			}
+			closers = append(closers, zs.IOReadCloser())
+			reader = zs
+		default:
+			return []byte{}, fmt.Errorf("unsupported content encoding %s", codings[i])
+		}
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, MaxBodySize+1))
+	if err != nil {
+		return []byte{}, err
+	}
+	if int64(len(decoded)) > MaxBodySize {
+		return []byte{}, fmt.Errorf("%w, more than %d bytes", ErrBodyTooLarge, MaxBodySize)
+	}
+
+	return decoded, nil
+}
+
+// deflate should be zlib wrapped, but plenty of servers send raw deflate streams
+func newDeflateReader(reader io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	// a zlib header has CM 8 in the low nibble and is a multiple of 31
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+
+	return flate.NewReader(buffered), nil
+}
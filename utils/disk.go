@@ -0,0 +1,563 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync/atomic"
+)
+
+// on disk seen sets merge their segments once there are more than this many
+const maxSeenSegments = 8
+
+// false positive rate the bloom filter is sized for, a false positive only costs a disk lookup
+const bloomFalsePositives = 0.01
+
+// entries a seen set's own bloom filter is sized for when it isn't handed a shared one
+const defaultBloomExpected = 1000000
+
+// written to a hidden temp file next to path then renamed over it, so a process killed mid write
+// never leaves half a file behind and readers only ever see the old file or the new one
+func WriteFileAtomic(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	if err := os.Rename(temp.Name(), path); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	return nil
+}
+
+func hashURL(url string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(url))
+
+	return hash.Sum64()
+}
+
+// safe to share between seen sets on different goroutines, every bit is set and read atomically
+type BloomFilter struct {
+	bits   []uint64
+	size   uint64
+	hashes uint64
+}
+
+// sized for expected entries at the false positive rate disk seen sets are tuned for
+func NewBloomFilter(expected int) *BloomFilter {
+	return newBloomFilter(expected, bloomFalsePositives)
+}
+
+// sized for n entries, going over n only raises the false positive rate
+func newBloomFilter(n int, p float64) *BloomFilter {
+	n = max(n, 1)
+	size := max(uint64(math.Ceil(-float64(n)*math.Log(p)/(math.Ln2*math.Ln2))), 64)
+	hashes := max(uint64(math.Round(float64(size)/float64(n)*math.Ln2)), 1)
+
+	return &BloomFilter{
+		bits:   make([]uint64, (size+63)/64),
+		size:   size,
+		hashes: hashes,
+	}
+}
+
+// double hashing, a second hash derived from the first stands in for k independent ones
+func (b *BloomFilter) each(h uint64, fn func(bit uint64) bool) bool {
+	step := (h>>33 ^ h*0x9e3779b97f4a7c15) | 1
+	for i := uint64(0); i < b.hashes; i++ {
+		if !fn((h + i*step) % b.size) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (b *BloomFilter) add(h uint64) {
+	b.each(h, func(bit uint64) bool {
+		atomic.OrUint64(&b.bits[bit/64], 1<<(bit%64))
+		return true
+	})
+}
+
+func (b *BloomFilter) test(h uint64) bool {
+	return b.each(h, func(bit uint64) bool {
+		return atomic.LoadUint64(&b.bits[bit/64])&(1<<(bit%64)) != 0
+	})
+}
+
+// a sorted run of url hashes on disk
+type seenSegment struct {
+	file    *os.File
+	entries int64
+}
+
+func (s seenSegment) at(i int64) (uint64, error) {
+	buffer := make([]byte, 8)
+	if _, err := s.file.ReadAt(buffer, i*8); err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(buffer), nil
+}
+
+func (s seenSegment) contains(h uint64) (bool, error) {
+	var err error
+	i := sort.Search(int(s.entries), func(i int) bool {
+		value, readErr := s.at(int64(i))
+		if readErr != nil {
+			err = readErr
+			return true
+		}
+		return value >= h
+	})
+	if err != nil {
+		return false, err
+	}
+	if int64(i) == s.entries {
+		return false, nil
+	}
+
+	value, err := s.at(int64(i))
+	return value == h, err
+}
+
+// a seen set that keeps a bounded window of url hashes in memory and spills the rest to sorted
+// segments on disk, a bloom filter in front of the segments means unseen urls rarely touch disk,
+// urls are stored as 64 bit hashes so two urls colliding is possible but vanishingly rare
+type DiskSeen struct {
+	dir      string
+	window   int
+	memory   map[uint64]struct{}
+	bloom    *BloomFilter
+	segments []seenSegment
+	count    int
+	next     int
+	err      error
+}
+
+// window is how many hashes are held in memory before a spill, the bloom filter can be shared with
+// other seen sets, their entries only show up as false positives that cost a disk lookup, a nil one
+// gets the set a filter of its own
+func NewDiskSeen(dir string, window int, bloom *BloomFilter) (*DiskSeen, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	if bloom == nil {
+		bloom = NewBloomFilter(defaultBloomExpected)
+	}
+
+	return &DiskSeen{
+		dir:    dir,
+		window: max(window, 1),
+		memory: map[uint64]struct{}{},
+		bloom:  bloom,
+	}, nil
+}
+
+func (d *DiskSeen) Add(url string) bool {
+	h := hashURL(url)
+	if d.has(h) {
+		return true
+	}
+
+	d.memory[h] = struct{}{}
+	d.bloom.add(h)
+	d.count++
+
+	// a failed spill keeps the hashes in memory and is retried on the next add
+	if len(d.memory) >= d.window {
+		d.fail(d.flush())
+	}
+
+	return false
+}
+
+func (d *DiskSeen) Has(url string) bool {
+	return d.has(hashURL(url))
+}
+
+func (d *DiskSeen) Len() int {
+	return d.count
+}
+
+// the first disk error hit, reads that failed were treated as unseen
+func (d *DiskSeen) Err() error {
+	return d.err
+}
+
+// closes and removes every segment
+func (d *DiskSeen) Close() error {
+	for _, segment := range d.segments {
+		segment.file.Close()
+	}
+	d.segments = nil
+
+	if err := os.RemoveAll(d.dir); err != nil && d.err == nil {
+		d.err = err
+	}
+
+	return d.err
+}
+
+func (d *DiskSeen) fail(err error) {
+	if err != nil && d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *DiskSeen) has(h uint64) bool {
+	if _, ok := d.memory[h]; ok {
+		return true
+	}
+	if !d.bloom.test(h) {
+		return false
+	}
+
+	for _, segment := range d.segments {
+		found, err := segment.contains(h)
+		d.fail(err)
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *DiskSeen) create() (*os.File, error) {
+	path := filepath.Join(d.dir, fmt.Sprintf("seen-%08d", d.next))
+	d.next++
+
+	return os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o600)
+}
+
+func (d *DiskSeen) flush() error {
+	hashes := make([]uint64, 0, len(d.memory))
+	for h := range d.memory {
+		hashes = append(hashes, h)
+	}
+	slices.Sort(hashes)
+
+	file, err := d.create()
+	if err != nil {
+		return err
+	}
+
+	buffered := bufio.NewWriter(file)
+	for _, h := range hashes {
+		if err := binary.Write(buffered, binary.BigEndian, h); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	d.segments = append(d.segments, seenSegment{file: file, entries: int64(len(hashes))})
+	clear(d.memory)
+
+	if len(d.segments) > maxSeenSegments {
+		return d.merge()
+	}
+
+	return nil
+}
+
+// merges every segment into one, streaming so memory stays flat however big they are
+func (d *DiskSeen) merge() error {
+	file, err := d.create()
+	if err != nil {
+		return err
+	}
+
+	readers := []*bufio.Reader{}
+	heads := []uint64{}
+	for _, segment := range d.segments {
+		reader := bufio.NewReader(io.NewSectionReader(segment.file, 0, segment.entries*8))
+
+		head := uint64(0)
+		if err := binary.Read(reader, binary.BigEndian, &head); err != nil {
+			continue
+		}
+
+		readers = append(readers, reader)
+		heads = append(heads, head)
+	}
+
+	abort := func(err error) error {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	buffered := bufio.NewWriter(file)
+	entries := int64(0)
+	for len(readers) > 0 {
+		smallest := 0
+		for i, head := range heads {
+			if head < heads[smallest] {
+				smallest = i
+			}
+		}
+
+		if err := binary.Write(buffered, binary.BigEndian, heads[smallest]); err != nil {
+			return abort(err)
+		}
+		entries++
+
+		err := binary.Read(readers[smallest], binary.BigEndian, &heads[smallest])
+		if errors.Is(err, io.EOF) {
+			readers = slices.Delete(readers, smallest, smallest+1)
+			heads = slices.Delete(heads, smallest, smallest+1)
+		} else if err != nil {
+			return abort(err)
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return abort(err)
+	}
+
+	for _, segment := range d.segments {
+		segment.file.Close()
+		os.Remove(segment.file.Name())
+	}
+	d.segments = []seenSegment{{file: file, entries: entries}}
+
+	return nil
+}
+
+// items spilled to disk a segment at a time, each segment is written once and read back whole
+type itemLog struct {
+	dir      string
+	segments []string
+	next     int
+}
+
+func (l *itemLog) write(items []Item) error {
+	path := filepath.Join(l.dir, fmt.Sprintf("items-%08d", l.next))
+	l.next++
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	buffered := bufio.NewWriter(file)
+	if err := gob.NewEncoder(buffered).Encode(items); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	l.segments = append(l.segments, path)
+	return nil
+}
+
+// reads the oldest or newest segment back and deletes it
+func (l *itemLog) read(newest bool) ([]Item, error) {
+	i := 0
+	if newest {
+		i = len(l.segments) - 1
+	}
+	path := l.segments[i]
+	l.segments = slices.Delete(l.segments, i, i+1)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+	defer file.Close()
+
+	items := []Item{}
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// a breadth first frontier holding at most two windows of items in memory, one being read
+// from and one being written to, with everything in between in segments on disk
+type DiskFIFO struct {
+	log    itemLog
+	window int
+	head   []Item
+	tail   []Item
+	size   int
+	err    error
+}
+
+func NewDiskFIFO(dir string, window int) (*DiskFIFO, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &DiskFIFO{log: itemLog{dir: dir}, window: max(window, 1)}, nil
+}
+
+func (f *DiskFIFO) Push(item Item) {
+	f.tail = append(f.tail, item)
+	f.size++
+
+	// a failed spill keeps the items in memory, the error comes back from the next pop
+	if len(f.tail) >= f.window {
+		if err := f.log.write(f.tail); err != nil {
+			f.fail(err)
+			return
+		}
+		f.tail = nil
+	}
+}
+
+// returns the first disk error hit, if any, so the crawl stops instead of silently losing urls
+func (f *DiskFIFO) Pop() (Item, error) {
+	if f.err != nil {
+		return Item{}, f.err
+	}
+
+	if len(f.head) == 0 {
+		if len(f.log.segments) > 0 {
+			head, err := f.log.read(false)
+			if err != nil {
+				f.fail(err)
+				return Item{}, err
+			}
+			f.head = head
+		} else {
+			f.head, f.tail = f.tail, nil
+		}
+	}
+
+	if len(f.head) == 0 {
+		return Item{}, ErrFrontierEmpty
+	}
+
+	item := f.head[0]
+	f.head = f.head[1:]
+	f.size--
+
+	return item, nil
+}
+
+func (f *DiskFIFO) Len() int {
+	return f.size
+}
+
+func (f *DiskFIFO) Close() error {
+	return os.RemoveAll(f.log.dir)
+}
+
+func (f *DiskFIFO) fail(err error) {
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// a depth first frontier, once the stack holds two windows the bottom one is spilled to disk
+// and it's read back when the stack above it has been popped
+type DiskLIFO struct {
+	log    itemLog
+	window int
+	stack  []Item
+	size   int
+	err    error
+}
+
+func NewDiskLIFO(dir string, window int) (*DiskLIFO, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &DiskLIFO{log: itemLog{dir: dir}, window: max(window, 1)}, nil
+}
+
+func (l *DiskLIFO) Push(item Item) {
+	l.stack = append(l.stack, item)
+	l.size++
+
+	if len(l.stack) >= 2*l.window {
+		if err := l.log.write(l.stack[:l.window]); err != nil {
+			l.fail(err)
+			return
+		}
+		l.stack = slices.Clone(l.stack[l.window:])
+	}
+}
+
+func (l *DiskLIFO) Pop() (Item, error) {
+	if l.err != nil {
+		return Item{}, l.err
+	}
+
+	if len(l.stack) == 0 && len(l.log.segments) > 0 {
+		stack, err := l.log.read(true)
+		if err != nil {
+			l.fail(err)
+			return Item{}, err
+		}
+		l.stack = stack
+	}
+
+	if len(l.stack) == 0 {
+		return Item{}, ErrFrontierEmpty
+	}
+
+	last := len(l.stack) - 1
+	item := l.stack[last]
+	l.stack[last] = Item{}
+	l.stack = l.stack[:last]
+	l.size--
+
+	return item, nil
+}
+
+func (l *DiskLIFO) Len() int {
+	return l.size
+}
+
+func (l *DiskLIFO) Close() error {
+	return os.RemoveAll(l.log.dir)
+}
+
+func (l *DiskLIFO) fail(err error) {
+	if l.err == nil {
+		l.err = err
+	}
+}
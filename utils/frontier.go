@@ -0,0 +1,278 @@
+package utils
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"regexp"
+)
+
+var ErrFrontierEmpty = errors.New("can't pop from an empty frontier")
+
+// a url waiting to be crawled
+type Item struct {
+	URL string
+	// links away from the seed
+	Depth int
+	// times the url was pushed, a count of inlinks from pages crawled so far
+	Inlinks int
+}
+
+// decides which url gets crawled next
+type Frontier interface {
+	Push(Item)
+	Pop() (Item, error)
+	Len() int
+}
+
+// first in first out, a breadth first crawl
+type FIFO struct {
+	items []Item
+	head  int
+	size  int
+}
+
+func NewFIFO() *FIFO {
+	return &FIFO{items: make([]Item, 16)}
+}
+
+// a ring buffer, so pushes and pops are O(1) and popped slots get reused
+func (f *FIFO) Push(item Item) {
+	if f.size == len(f.items) {
+		grown := make([]Item, len(f.items)*2)
+		n := copy(grown, f.items[f.head:])
+		copy(grown[n:], f.items[:f.head])
+		f.items = grown
+		f.head = 0
+	}
+
+	f.items[(f.head+f.size)%len(f.items)] = item
+	f.size++
+}
+
+func (f *FIFO) Pop() (Item, error) {
+	if f.size == 0 {
+		return Item{}, ErrFrontierEmpty
+	}
+
+	item := f.items[f.head]
+	f.items[f.head] = Item{}
+	f.head = (f.head + 1) % len(f.items)
+	f.size--
+
+	return item, nil
+}
+
+func (f *FIFO) Len() int {
+	return f.size
+}
+
+// last in first out, a depth first crawl without recursion
+type LIFO struct {
+	items []Item
+}
+
+func NewLIFO() *LIFO {
+	return &LIFO{}
+}
+
+func (l *LIFO) Push(item Item) {
+	l.items = append(l.items, item)
+}
+
+func (l *LIFO) Pop() (Item, error) {
+	if len(l.items) == 0 {
+		return Item{}, ErrFrontierEmpty
+	}
+
+	last := len(l.items) - 1
+	item := l.items[last]
+	l.items[last] = Item{}
+	l.items = l.items[:last]
+
+	return item, nil
+}
+
+func (l *LIFO) Len() int {
+	return len(l.items)
+}
+
+// how valuable a url is, higher gets crawled first
+type Scorer func(Item) float64
+
+// highest score first, urls that tie come out in the order they were first pushed
+type Priority struct {
+	score Scorer
+	queue priorityQueue
+	// position in the heap of every queued url, keyed by the normalised url
+	index map[string]*entry
+	count int
+}
+
+type entry struct {
+	item  Item
+	score float64
+	order int
+	at    int
+}
+
+func NewPriority(score Scorer) *Priority {
+	return &Priority{
+		score: score,
+		index: map[string]*entry{},
+	}
+}
+
+func priorityKey(url string) string {
+	if normURL, err := Normalize(url); err == nil {
+		return normURL
+	}
+
+	return url
+}
+
+// pushing a url that's already queued bumps it instead of queueing it twice
+func (p *Priority) Push(item Item) {
+	if p.Bump(item) {
+		return
+	}
+
+	item.Inlinks = max(item.Inlinks, 1)
+	e := &entry{item: item, score: p.score(item), order: p.count}
+	p.count++
+
+	p.index[priorityKey(item.URL)] = e
+	heap.Push(&p.queue, e)
+}
+
+// counts another inlink to a queued url and rescores it in O(log n), reporting whether it was queued
+func (p *Priority) Bump(item Item) bool {
+	existing, ok := p.index[priorityKey(item.URL)]
+	if !ok {
+		return false
+	}
+
+	existing.item.Inlinks++
+	existing.item.Depth = min(existing.item.Depth, item.Depth)
+	existing.score = p.score(existing.item)
+	heap.Fix(&p.queue, existing.at)
+
+	return true
+}
+
+func (p *Priority) Pop() (Item, error) {
+	if p.queue.Len() == 0 {
+		return Item{}, ErrFrontierEmpty
+	}
+
+	e := heap.Pop(&p.queue).(*entry)
+	delete(p.index, priorityKey(e.item.URL))
+
+	return e.item, nil
+}
+
+func (p *Priority) Len() int {
+	return p.queue.Len()
+}
+
+type priorityQueue []*entry
+
+func (q priorityQueue) Len() int {
+	return len(q)
+}
+
+func (q priorityQueue) Less(i, j int) bool {
+	if q[i].score != q[j].score {
+		return q[i].score > q[j].score
+	}
+
+	return q[i].order < q[j].order
+}
+
+func (q priorityQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].at = i
+	q[j].at = j
+}
+
+func (q *priorityQueue) Push(x any) {
+	e := x.(*entry)
+	e.at = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *priorityQueue) Pop() any {
+	old := *q
+	last := len(old) - 1
+
+	e := old[last]
+	old[last] = nil
+	*q = old[:last]
+
+	return e
+}
+
+type Weighted struct {
+	Scorer Scorer
+	Weight float64
+}
+
+// sums the weighted scores, so one strategy can mix several signals
+func Combine(scorers ...Weighted) Scorer {
+	return func(item Item) float64 {
+		total := 0.0
+		for _, weighted := range scorers {
+			total += weighted.Weight * weighted.Scorer(item)
+		}
+
+		return total
+	}
+}
+
+// the priority a sitemap gives the url, 0 for urls not in any sitemap
+func SitemapScorer(urls []SitemapURL) Scorer {
+	priorities := map[string]float64{}
+	for _, url := range urls {
+		if normURL, err := Normalize(url.Loc); err == nil {
+			priorities[normURL] = max(priorities[normURL], url.Priority)
+		}
+	}
+
+	return func(item Item) float64 {
+		normURL, err := Normalize(item.URL)
+		if err != nil {
+			return 0
+		}
+
+		return priorities[normURL]
+	}
+}
+
+// more linked to pages score higher, with diminishing returns
+func InlinkScorer(item Item) float64 {
+	return math.Log1p(float64(item.Inlinks))
+}
+
+// shallower pages score higher
+func DepthScorer(item Item) float64 {
+	return -float64(item.Depth)
+}
+
+type Pattern struct {
+	Match  *regexp.Regexp
+	Weight float64
+}
+
+// adds up the weights of every pattern the url matches, negative weights push pages back
+func PatternScorer(patterns []Pattern) Scorer {
+	return func(item Item) float64 {
+		total := 0.0
+		for _, pattern := range patterns {
+			if pattern.Match.MatchString(item.URL) {
+				total += pattern.Weight
+			}
+		}
+
+		return total
+	}
+}
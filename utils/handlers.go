@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// turns a fetched body of a particular media type into a Response
+type Handler func(domain *url.URL, page []byte) (Response, error)
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = map[string]Handler{
+		"text/html":             ParseHTML,
+		"application/xhtml+xml": ParseHTML,
+		"text/plain":            ParseText,
+		"application/pdf":       ParsePDF,
+	}
+)
+
+// registers or replaces the handler for a media type, safe to call while crawling
+func RegisterHandler(mediaType string, handler Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+
+	handlers[strings.ToLower(mediaType)] = handler
+}
+
+func getHandler(mediaType string) (Handler, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+
+	handler, ok := handlers[strings.ToLower(mediaType)]
+	return handler, ok
+}
+
+// dispatches a page to the handler registered for its media type
+func Parse(domain *url.URL, page Page) (Response, error) {
+	handler, ok := getHandler(page.MediaType)
+	if !ok {
+		return Response{}, fmt.Errorf("%w %s", ErrUnsupportedType, page.MediaType)
+	}
+
+	return handler(domain, page.Body)
+}
+
+var plainLinks = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// paragraphs are separated by blank lines, the first line doubles as the title
+func ParseText(domain *url.URL, page []byte) (Response, error) {
+	response := Response{}
+	paragraph := []string{}
+	seen := map[string]struct{}{}
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			response.Content = append(response.Content, strings.Join(paragraph, " "))
+			paragraph = []string{}
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(page))
+	scanner.Buffer(make([]byte, 0, 64*1024), int(MaxBodySize))
+	for scanner.Scan() {
+		line := strings.Join(strings.Fields(scanner.Text()), " ")
+		if line == "" {
+			flush()
+			continue
+		}
+
+		if response.Title == "" {
+			response.Title = line
+		}
+		paragraph = append(paragraph, line)
+
+		for _, link := range plainLinks.FindAllString(line, -1) {
+			link = strings.TrimRight(link, ".,;:!?)]}'")
+			if _, ok := seen[link]; !ok {
+				seen[link] = struct{}{}
+				response.Links = append(response.Links, Link{URL: link, Position: len(response.Links)})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return response, err
+	}
+	flush()
+
+	return response, nil
+}
+
+// pure Go text extraction, one entry per line of text, links come from URI annotations
+func ParsePDF(domain *url.URL, page []byte) (response Response, err error) {
+	// the pdf package panics on malformed input rather than returning errors
+	defer func() {
+		if r := recover(); r != nil {
+			response = Response{}
+			err = fmt.Errorf("malformed pdf: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(page), int64(len(page)))
+	if err != nil {
+		return response, err
+	}
+
+	response.Title = strings.Join(strings.Fields(reader.Trailer().Key("Info").Key("Title").Text()), " ")
+	seen := map[string]struct{}{}
+
+	for i := 1; i <= reader.NumPage(); i++ {
+		p := reader.Page(i)
+		if p.V.IsNull() {
+			continue
+		}
+
+		response.Content = append(response.Content, pdfLines(p.Content().Text)...)
+
+		annots := p.V.Key("Annots")
+		for j := 0; j < annots.Len(); j++ {
+			uri := annots.Index(j).Key("A").Key("URI").Text()
+			if uri == "" {
+				continue
+			}
+
+			structure, err := url.Parse(uri)
+			if err != nil {
+				continue
+			}
+
+			link := domain.ResolveReference(structure).String()
+			if _, ok := seen[link]; !ok {
+				seen[link] = struct{}{}
+				response.Links = append(response.Links, Link{URL: link, Position: len(response.Links)})
+			}
+		}
+	}
+
+	if response.Title == "" && len(response.Content) > 0 {
+		response.Title = response.Content[0]
+	}
+
+	return response, nil
+}
+
+// glyphs come out one at a time, they're stitched back into lines by their baseline,
+// with a space wherever the gap to the previous glyph is wider than a fraction of the font size
+func pdfLines(texts []pdf.Text) []string {
+	lines := []string{}
+	current := strings.Builder{}
+	lastY, lastEnd := 0.0, 0.0
+
+	flush := func() {
+		if line := strings.Join(strings.Fields(current.String()), " "); line != "" {
+			lines = append(lines, line)
+		}
+		current.Reset()
+	}
+
+	for i, text := range texts {
+		if i > 0 && math.Abs(text.Y-lastY) > text.FontSize/2 {
+			flush()
+		} else if i > 0 && text.X-lastEnd > text.FontSize*0.2 {
+			current.WriteString(" ")
+		}
+
+		current.WriteString(text.S)
+		lastY, lastEnd = text.Y, text.X+text.W
+	}
+	flush()
+
+	return lines
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// settings for turning extracted text into a search friendly form, raw text is left untouched
+type Normaliser struct {
+	Lowercase        bool `json:"lowercase"`
+	StripPunctuation bool `json:"strip_punctuation"`
+	FoldDiacritics   bool `json:"fold_diacritics"`
+}
+
+func (n Normaliser) Normalise(text string) string {
+	text = norm.NFC.String(text)
+
+	if n.FoldDiacritics {
+		// decompose, drop the combining marks, then recompose, so "café" becomes "cafe"
+		folder := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+		folded, _, err := transform.String(folder, text)
+		if err == nil {
+			text = folded
+		}
+	}
+
+	if n.Lowercase {
+		text = strings.ToLower(text)
+	}
+
+	if n.StripPunctuation {
+		text = stripPunctuation(text)
+	}
+
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// letters, numbers and marks of any script are kept, everything else becomes a space,
+// apart from separators inside numbers like 3.14 or 1,000
+func stripPunctuation(text string) string {
+	input := []rune(text)
+	output := make([]rune, 0, len(input))
+
+	for i, r := range input {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r) {
+			output = append(output, r)
+			continue
+		}
+
+		if (r == '.' || r == ',') && i > 0 && i < len(input)-1 && unicode.IsDigit(input[i-1]) && unicode.IsDigit(input[i+1]) {
+			output = append(output, r)
+			continue
+		}
+
+		output = append(output, ' ')
+	}
+
+	return string(output)
+}
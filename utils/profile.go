@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// css selectors for where things are on the pages of a site we know, empty selectors fall back to
+// what ParseHTML finds on its own
+type Profile struct {
+	// regular expression matched against the page's full url
+	Match string `json:"match"`
+	Title string `json:"title"`
+	// every element matched is one piece of content, in place of the text in <p> tags
+	Body   string `json:"body"`
+	Date   string `json:"date"`
+	Author string `json:"author"`
+	// elements dropped before anything else is read, like share buttons or comments
+	Exclude string `json:"exclude"`
+	// only links inside these elements are followed, the rest are still kept for the link graph
+	Links string `json:"links"`
+}
+
+type compiledProfile struct {
+	match   *regexp.Regexp
+	title   cascadia.Matcher
+	body    cascadia.Matcher
+	date    cascadia.Matcher
+	author  cascadia.Matcher
+	exclude cascadia.Matcher
+	links   cascadia.Matcher
+}
+
+// profiles ready to be matched against pages, the first whose pattern matches a page's url is used
+type Profiles []compiledProfile
+
+func CompileProfiles(profiles []Profile) (Profiles, error) {
+	compiled := Profiles{}
+
+	for _, profile := range profiles {
+		match, err := regexp.Compile(profile.Match)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", profile.Match, err)
+		}
+
+		result := compiledProfile{match: match}
+		for _, selector := range []struct {
+			css    string
+			target *cascadia.Matcher
+		}{
+			{profile.Title, &result.title},
+			{profile.Body, &result.body},
+			{profile.Date, &result.date},
+			{profile.Author, &result.author},
+			{profile.Exclude, &result.exclude},
+			{profile.Links, &result.links},
+		} {
+			if selector.css == "" {
+				continue
+			}
+
+			parsed, err := cascadia.ParseGroup(selector.css)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: selector %q: %w", profile.Match, selector.css, err)
+			}
+			*selector.target = parsed
+		}
+
+		compiled = append(compiled, result)
+	}
+
+	return compiled, nil
+}
+
+func (p Profiles) match(rawURL string) *compiledProfile {
+	for i := range p {
+		if p[i].match.MatchString(rawURL) {
+			return &p[i]
+		}
+	}
+
+	return nil
+}
+
+// parses html pages with the profile matching their url, anything else goes to its usual handler
+func (p Profiles) Parse(domain *url.URL, page Page) (Response, error) {
+	profile := p.match(page.URL)
+	if profile == nil || (page.MediaType != "text/html" && page.MediaType != "application/xhtml+xml") {
+		return Parse(domain, page)
+	}
+
+	return parseProfile(domain, page.Body, profile)
+}
+
+// excluded elements are cut from the tree first, so they're missing from the content, links and
+// structured data alike, then the selectors replace what ParseHTML made of the rest
+func parseProfile(domain *url.URL, page []byte, profile *compiledProfile) (Response, error) {
+	root, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return Response{}, err
+	}
+
+	if profile.exclude != nil {
+		for _, node := range cascadia.QueryAll(root, profile.exclude) {
+			if node.Parent != nil {
+				node.Parent.RemoveChild(node)
+			}
+		}
+	}
+
+	pruned := &bytes.Buffer{}
+	if err := html.Render(pruned, root); err != nil {
+		return Response{}, err
+	}
+
+	response, err := ParseHTML(domain, pruned.Bytes())
+	if err != nil {
+		return response, err
+	}
+
+	if profile.title != nil {
+		if node := cascadia.Query(root, profile.title); node != nil {
+			response.Title = nodeText(node)
+		}
+	}
+
+	if profile.body != nil {
+		response.Content = []string{}
+		for _, node := range cascadia.QueryAll(root, profile.body) {
+			if text := nodeText(node); text != "" {
+				response.Content = append(response.Content, text)
+			}
+		}
+	}
+
+	if profile.date != nil {
+		if node := cascadia.Query(root, profile.date); node != nil {
+			response.Date = attrOrText(node, "datetime", "content")
+		}
+	}
+
+	if profile.author != nil {
+		if node := cascadia.Query(root, profile.author); node != nil {
+			response.Author = attrOrText(node, "content")
+		}
+	}
+
+	if profile.links != nil {
+		followed := map[string]struct{}{}
+		for _, area := range cascadia.QueryAll(root, profile.links) {
+			for _, link := range linksUnder(domain, area) {
+				followed[link] = struct{}{}
+			}
+		}
+
+		for i, link := range response.Links {
+			if _, ok := followed[link.URL]; !ok {
+				response.Links[i].Unfollowed = true
+			}
+		}
+	}
+
+	return response, nil
+}
+
+// elements that sit inside a line of text, everything else starts a new one
+var inline = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.B: true, atom.Cite: true, atom.Code: true, atom.Em: true,
+	atom.I: true, atom.Mark: true, atom.Q: true, atom.Small: true, atom.Span: true, atom.Strong: true,
+	atom.Sub: true, atom.Sup: true, atom.Time: true, atom.U: true,
+}
+
+// whitespace collapsed text under a node, leaving out scripts and styles
+func nodeText(node *html.Node) string {
+	text := strings.Builder{}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style) {
+			return
+		}
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+		}
+
+		block := n.Type == html.ElementNode && !inline[n.DataAtom]
+		if block {
+			text.WriteString(" ")
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		if block {
+			text.WriteString(" ")
+		}
+	}
+	walk(node)
+
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// the first of attrs the node has, like a <time>'s datetime or a <meta>'s content, otherwise its text
+func attrOrText(node *html.Node, attrs ...string) string {
+	for _, key := range attrs {
+		for _, attr := range node.Attr {
+			if attr.Key == key && strings.TrimSpace(attr.Val) != "" {
+				return strings.TrimSpace(attr.Val)
+			}
+		}
+	}
+
+	return nodeText(node)
+}
+
+// hrefs of the node and any links under it, resolved the same way ParseHTML resolves them
+func linksUnder(domain *url.URL, node *html.Node) []string {
+	links := []string{}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A {
+			for _, attr := range n.Attr {
+				if attr.Key != "href" {
+					continue
+				}
+
+				structure, err := url.Parse(attr.Val)
+				if err != nil {
+					continue
+				}
+
+				fullURL := attr.Val
+				if structure.Hostname() == "" {
+					fullURL = domain.ResolveReference(structure).String()
+				}
+				links = append(links, fullURL)
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	return links
+}
@@ -0,0 +1,254 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// asked before a rendered page sends a request of its own, an error blocks the request
+type RequestGate func(ctx context.Context, rawURL string) error
+
+// loads a page the way a browser would and returns the html its scripts leave behind
+type Renderer interface {
+	// the page is handed to the browser as it was fetched rather than fetched again, every other
+	// request it makes has to get through gate and is then sent through Client
+	Render(ctx context.Context, fetched Page, gate RequestGate) ([]byte, error)
+	Close() error
+}
+
+// renders pages in tabs of one headless chrome, talking to it over the devtools protocol
+type ChromeRenderer struct {
+	browser context.Context
+	cancel  context.CancelFunc
+	// a slot is taken for every tab open at once
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+// starts chrome from execPath, or wherever it's found on the system when empty, rendering at most
+// concurrency pages at once and giving up on a page after timeout
+func NewChromeRenderer(execPath string, concurrency int, timeout time.Duration) (*ChromeRenderer, error) {
+	if concurrency < 1 {
+		return nil, errors.New("render concurrency has to be at least 1")
+	}
+
+	options := chromedp.DefaultExecAllocatorOptions[:]
+	if execPath != "" {
+		options = append(options, chromedp.ExecPath(execPath))
+	}
+
+	allocator, cancelAllocator := chromedp.NewExecAllocator(context.Background(), options...)
+	browser, cancelBrowser := chromedp.NewContext(allocator)
+	cancel := func() {
+		cancelBrowser()
+		cancelAllocator()
+	}
+
+	// running nothing is enough to launch the browser, so a missing chrome is caught here
+	if err := chromedp.Run(browser); err != nil {
+		cancel()
+		return nil, fmt.Errorf("starting chrome: %w", err)
+	}
+
+	return &ChromeRenderer{
+		browser: browser,
+		cancel:  cancel,
+		slots:   make(chan struct{}, concurrency),
+		timeout: timeout,
+	}, nil
+}
+
+// waits for a free slot, then for the page's network to go idle before reading its dom
+func (c *ChromeRenderer) Render(ctx context.Context, fetched Page, gate RequestGate) ([]byte, error) {
+	rawURL := fetched.URL
+
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		<-c.slots
+	}()
+
+	tab, cancelTab := chromedp.NewContext(c.browser)
+	defer cancelTab()
+	tab, cancelTimeout := context.WithTimeout(tab, c.timeout)
+	defer cancelTimeout()
+
+	// stops the tab if the crawl is stopped while the page is loading
+	stop := context.AfterFunc(ctx, cancelTab)
+	defer stop()
+
+	// chrome says a document's network is idle once it's gone 500ms without a request, these come in
+	// for every document the tab loads so only the one navigated to is waited on
+	mu := sync.Mutex{}
+	idle := map[cdp.LoaderID]bool{}
+	signal := make(chan struct{}, 1)
+	served := &atomic.Bool{}
+	chromedp.ListenTarget(tab, func(ev any) {
+		switch event := ev.(type) {
+		case *page.EventLifecycleEvent:
+			if event.Name != "networkIdle" {
+				return
+			}
+
+			mu.Lock()
+			idle[event.LoaderID] = true
+			mu.Unlock()
+
+			select {
+			case signal <- struct{}{}:
+			default:
+			}
+		case *fetch.EventRequestPaused:
+			// answered off the event loop, which answering has to wait on
+			go answer(tab, event, fetched, gate, served)
+		}
+	})
+
+	loader := cdp.LoaderID("")
+	err := chromedp.Run(tab,
+		page.SetLifecycleEventsEnabled(true),
+		// every request the tab makes is paused until answered
+		fetch.Enable(),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			_, loaderID, errorText, _, err := page.Navigate(rawURL).Do(ctx)
+			if err != nil {
+				return err
+			}
+			if errorText != "" {
+				return fmt.Errorf("loading %s: %s", rawURL, errorText)
+			}
+
+			loader = loaderID
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		mu.Lock()
+		done := idle[loader]
+		mu.Unlock()
+		if done {
+			break
+		}
+
+		select {
+		case <-signal:
+		case <-tab.Done():
+			return nil, fmt.Errorf("waiting for %s to go idle: %w", rawURL, context.Cause(tab))
+		}
+	}
+
+	html := ""
+	if err := chromedp.Run(tab, chromedp.OuterHTML("html", &html, chromedp.ByQuery)); err != nil {
+		return nil, err
+	}
+
+	return []byte(html), nil
+}
+
+// the first document the tab asks for is the page as it was fetched, anything else gets through
+// the gate and is sent like the crawler's own requests, failures are left for the tab to deal with
+func answer(tab context.Context, event *fetch.EventRequestPaused, fetched Page, gate RequestGate, served *atomic.Bool) {
+	executor := cdp.WithExecutor(tab, chromedp.FromContext(tab).Target)
+
+	status, header, body := fetched.Status, fetched.Header, fetched.Original
+	if event.ResourceType != network.ResourceTypeDocument || !served.CompareAndSwap(false, true) {
+		if err := gate(tab, event.Request.URL); err != nil {
+			fetch.FailRequest(event.RequestID, network.ErrorReasonBlockedByClient).Do(executor)
+			return
+		}
+
+		res, err := send(tab, event.Request, gate)
+		if err != nil {
+			fetch.FailRequest(event.RequestID, network.ErrorReasonFailed).Do(executor)
+			return
+		}
+		status, header, body = res.Status, res.Header, res.Body
+	}
+
+	// bodies are handed over decoded, so the encoding and length they came with no longer hold
+	headers := []*fetch.HeaderEntry{}
+	for name, values := range header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Encoding", "Content-Length", "Transfer-Encoding":
+			continue
+		}
+		for _, value := range values {
+			headers = append(headers, &fetch.HeaderEntry{Name: name, Value: value})
+		}
+	}
+
+	fetch.FulfillRequest(event.RequestID, int64(status)).
+		WithResponseHeaders(headers).
+		WithBody(base64.StdEncoding.EncodeToString(body)).
+		Do(executor)
+}
+
+// sends a request the browser wanted through Client, redirects have to get through the gate too
+func send(ctx context.Context, request *network.Request, gate RequestGate) (Page, error) {
+	body := []byte{}
+	for _, entry := range request.PostDataEntries {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Bytes)
+		if err != nil {
+			return Page{}, err
+		}
+		body = append(body, decoded...)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, request.Method, request.URL, bytes.NewReader(body))
+	if err != nil {
+		return Page{}, err
+	}
+	for name, value := range request.Headers {
+		if value, ok := value.(string); ok {
+			req.Header.Set(name, value)
+		}
+	}
+	req.Header.Set("Accept-Encoding", AcceptEncoding)
+
+	client := *Client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return gate(req.Context(), req.URL.String())
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return Page{}, err
+	}
+	defer res.Body.Close()
+
+	decoded, err := decodeBody(res.Body, res.Header.Get("Content-Encoding"))
+	if err != nil {
+		return Page{}, err
+	}
+
+	return Page{URL: request.URL, Body: decoded, Status: res.StatusCode, Header: res.Header}, nil
+}
+
+// closes every tab and the browser
+func (c *ChromeRenderer) Close() error {
+	c.cancel()
+	return nil
+}
@@ -0,0 +1,34 @@
+package utils
+
+// urls that have already been queued or crawled
+type SeenSet interface {
+	// adds the url, reporting whether it had already been added
+	Add(url string) bool
+	Has(url string) bool
+	Len() int
+}
+
+// a seen set kept entirely in memory
+type MemorySeen map[string]struct{}
+
+func NewMemorySeen() MemorySeen {
+	return MemorySeen{}
+}
+
+func (m MemorySeen) Add(url string) bool {
+	if _, ok := m[url]; ok {
+		return true
+	}
+
+	m[url] = struct{}{}
+	return false
+}
+
+func (m MemorySeen) Has(url string) bool {
+	_, ok := m[url]
+	return ok
+}
+
+func (m MemorySeen) Len() int {
+	return len(m)
+}
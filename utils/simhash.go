@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"sync"
+)
+
+// words per shingle, short texts fall back to single words
+const shingleSize = 3
+
+// 64 bit simhash over word shingles, similar texts land a small hamming distance apart
+func SimHash(text string) uint64 {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return 0
+	}
+
+	size := shingleSize
+	if len(words) < size {
+		size = 1
+	}
+
+	weights := [64]int{}
+	for i := 0; i+size <= len(words); i++ {
+		hasher := fnv.New64a()
+		hasher.Write([]byte(strings.Join(words[i:i+size], " ")))
+		hash := hasher.Sum64()
+
+		for bit := range 64 {
+			if hash&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	fingerprint := uint64(0)
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+
+	return fingerprint
+}
+
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+type fingerprint struct {
+	url   string
+	value uint64
+}
+
+// finds fingerprints within a hamming distance without comparing against everything,
+// splitting into distance+1 bands means any match shares at least one band exactly
+type FingerprintIndex struct {
+	mu          sync.Mutex
+	maxDistance int
+	bands       []map[uint64][]fingerprint
+}
+
+func NewFingerprintIndex(maxDistance int) *FingerprintIndex {
+	maxDistance = max(0, min(maxDistance, 63))
+
+	bands := make([]map[uint64][]fingerprint, maxDistance+1)
+	for i := range bands {
+		bands[i] = map[uint64][]fingerprint{}
+	}
+
+	return &FingerprintIndex{
+		maxDistance: maxDistance,
+		bands:       bands,
+	}
+}
+
+func (f *FingerprintIndex) band(value uint64, i int) uint64 {
+	width := 64 / len(f.bands)
+	start := i * width
+
+	// the last band soaks up the leftover bits
+	if i == len(f.bands)-1 {
+		return value >> start
+	}
+
+	return (value >> start) & (1<<width - 1)
+}
+
+// returns the url of a near duplicate if there is one, otherwise remembers this fingerprint
+func (f *FingerprintIndex) CheckAndAdd(url string, value uint64) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, band := range f.bands {
+		for _, candidate := range band[f.band(value, i)] {
+			if HammingDistance(candidate.value, value) <= f.maxDistance {
+				return candidate.url, true
+			}
+		}
+	}
+
+	for i, band := range f.bands {
+		key := f.band(value, i)
+		band[key] = append(band[key], fingerprint{url: url, value: value})
+	}
+
+	return "", false
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// sitemaps followed per site, including those listed in sitemap indexes
+const maxSitemaps = 50
+
+// priority given to sitemap entries that don't set one, as the protocol specifies
+const defaultSitemapPriority = 0.5
+
+type SitemapURL struct {
+	Loc      string
+	Priority float64
+}
+
+type sitemapDocument struct {
+	XMLName xml.Name
+	URLs    []struct {
+		Loc      string `xml:"loc"`
+		Priority string `xml:"priority"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// parses a urlset or a sitemap index, returning the page urls and any nested sitemaps
+func ParseSitemap(page []byte) ([]SitemapURL, []string, error) {
+	doc := sitemapDocument{}
+	if err := xml.Unmarshal(page, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	urls := []SitemapURL{}
+	for _, entry := range doc.URLs {
+		loc := strings.TrimSpace(entry.Loc)
+		if loc == "" {
+			continue
+		}
+
+		priority, err := strconv.ParseFloat(strings.TrimSpace(entry.Priority), 64)
+		if err != nil || priority < 0 || priority > 1 {
+			priority = defaultSitemapPriority
+		}
+
+		urls = append(urls, SitemapURL{Loc: loc, Priority: priority})
+	}
+
+	children := []string{}
+	for _, sitemap := range doc.Sitemaps {
+		if loc := strings.TrimSpace(sitemap.Loc); loc != "" {
+			children = append(children, loc)
+		}
+	}
+
+	return urls, children, nil
+}
+
+// fetches every url listed in the site's sitemaps, falling back to /sitemap.xml when robots.txt
+// doesn't point at any, sitemaps that can't be fetched or parsed are skipped, each one waits its
+// turn on the site's throttle and whatever was found is returned once ctx is done
+func GetSitemaps(ctx context.Context, rawURL string, locations []string, throttle *Throttle) []SitemapURL {
+	if len(locations) == 0 {
+		locations = []string{fmt.Sprintf("%s/sitemap.xml", strings.TrimRight(rawURL, "/"))}
+	}
+
+	urls := []SitemapURL{}
+	seen := map[string]struct{}{}
+
+	for fetched := 0; len(locations) > 0 && fetched < maxSitemaps; fetched++ {
+		location := locations[0]
+		locations = locations[1:]
+
+		if _, ok := seen[location]; ok {
+			continue
+		}
+		seen[location] = struct{}{}
+
+		if err := throttle.Wait(ctx); err != nil {
+			break
+		}
+
+		page, err := getSitemap(ctx, location)
+		if err != nil {
+			continue
+		}
+
+		found, children, err := ParseSitemap(page)
+		if err != nil {
+			continue
+		}
+
+		urls = append(urls, found...)
+		locations = append(locations, children...)
+	}
+
+	return urls
+}
+
+func getSitemap(ctx context.Context, location string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return []byte{}, err
+	}
+	req.Header.Set("Accept-Encoding", AcceptEncoding)
+
+	res, err := Client.Do(req)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return []byte{}, &StatusError{Code: res.StatusCode}
+	}
+
+	page, err := decodeBody(res.Body, res.Header.Get("Content-Encoding"))
+	if err != nil {
+		return []byte{}, err
+	}
+
+	// sitemap.xml.gz files are usually served as plain gzip files rather than gzip encoded
+	if bytes.HasPrefix(page, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(bytes.NewReader(page))
+		if err != nil {
+			return []byte{}, err
+		}
+		defer gz.Close()
+
+		page, err = io.ReadAll(io.LimitReader(gz, MaxBodySize+1))
+		if err != nil {
+			return []byte{}, err
+		}
+		if int64(len(page)) > MaxBodySize {
+			return []byte{}, fmt.Errorf("%w, more than %d bytes", ErrBodyTooLarge, MaxBodySize)
+		}
+	}
+
+	return page, nil
+}
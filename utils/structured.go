@@ -0,0 +1,354 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// structured data is normalised into flat maps, "@source" records where it came from
+// and "@type" holds the schema.org type without its vocabulary prefix
+const (
+	SourceJSONLD    = "json-ld"
+	SourceMicrodata = "microdata"
+	SourceOpenGraph = "opengraph"
+)
+
+// tags that never get an end tag, so never go on the microdata stack
+var voidElements = map[atom.Atom]struct{}{
+	atom.Area:   {},
+	atom.Base:   {},
+	atom.Br:     {},
+	atom.Col:    {},
+	atom.Embed:  {},
+	atom.Hr:     {},
+	atom.Img:    {},
+	atom.Input:  {},
+	atom.Link:   {},
+	atom.Meta:   {},
+	atom.Source: {},
+	atom.Track:  {},
+	atom.Wbr:    {},
+}
+
+type microFrame struct {
+	tag   string
+	depth int
+	prop  string
+	item  map[string]any
+	value string
+	fixed bool
+	text  []string
+}
+
+type structuredParser struct {
+	domain    *url.URL
+	items     []map[string]any
+	openGraph map[string]any
+	stack     []*microFrame
+	ldJSON    bool
+	script    strings.Builder
+}
+
+func newStructuredParser(domain *url.URL) *structuredParser {
+	return &structuredParser{
+		domain:    domain,
+		openGraph: map[string]any{},
+	}
+}
+
+func (s *structuredParser) start(t html.Token, selfClosing bool) {
+	if t.DataAtom == atom.Script && getAttr(t, "type") == "application/ld+json" {
+		s.ldJSON = true
+		s.script.Reset()
+		return
+	}
+
+	if t.DataAtom == atom.Meta {
+		if property := getAttr(t, "property"); property != "" {
+			s.addOpenGraph(property, getAttr(t, "content"))
+		}
+	}
+
+	_, void := voidElements[t.DataAtom]
+	_, scope := attrPresent(t, "itemscope")
+	prop := getAttr(t, "itemprop")
+	if !scope && prop == "" {
+		// an element with a frame of its own is closed by its end tag, so only the rest nest in
+		// frames of the same tag, and only if an end tag is coming
+		if !void && !selfClosing {
+			for _, frame := range s.stack {
+				if frame.tag == t.Data {
+					frame.depth++
+				}
+			}
+		}
+		return
+	}
+
+	frame := &microFrame{
+		tag:  t.Data,
+		prop: prop,
+	}
+
+	if scope {
+		frame.item = map[string]any{"@source": SourceMicrodata}
+		if itemType := getAttr(t, "itemtype"); itemType != "" {
+			frame.item["@type"] = schemaType(itemType)
+		}
+	} else {
+		frame.value, frame.fixed = s.propValue(t)
+	}
+
+	if void || selfClosing {
+		s.close(frame)
+		return
+	}
+
+	s.stack = append(s.stack, frame)
+}
+
+func (s *structuredParser) end(t html.Token) {
+	if t.DataAtom == atom.Script && s.ldJSON {
+		s.ldJSON = false
+		s.addJSONLD([]byte(s.script.String()))
+		return
+	}
+
+	for i := len(s.stack) - 1; i >= 0; i-- {
+		if s.stack[i].tag != t.Data {
+			continue
+		}
+
+		if s.stack[i].depth > 0 {
+			s.stack[i].depth--
+			return
+		}
+
+		// anything left open above the matching frame gets closed along with it
+		for j := len(s.stack) - 1; j >= i; j-- {
+			frame := s.stack[j]
+			s.stack = s.stack[:j]
+			s.close(frame)
+		}
+
+		return
+	}
+}
+
+func (s *structuredParser) text(data string) {
+	if s.ldJSON {
+		s.script.WriteString(data)
+		return
+	}
+
+	for _, frame := range s.stack {
+		if frame.item == nil && !frame.fixed {
+			frame.text = append(frame.text, data)
+		}
+	}
+}
+
+// closes off a microdata frame, attaching it to the nearest enclosing item
+func (s *structuredParser) close(frame *microFrame) {
+	var value any
+	if frame.item != nil {
+		value = frame.item
+	} else if frame.fixed {
+		value = frame.value
+	} else {
+		value = strings.Join(strings.Fields(strings.Join(frame.text, " ")), " ")
+	}
+
+	var parent map[string]any
+	for i := len(s.stack) - 1; i >= 0; i-- {
+		if s.stack[i].item != nil {
+			parent = s.stack[i].item
+			break
+		}
+	}
+
+	if frame.prop == "" || parent == nil {
+		if frame.item != nil {
+			s.items = append(s.items, frame.item)
+		}
+		return
+	}
+
+	// itemprop can hold several space separated names
+	for _, name := range strings.Fields(frame.prop) {
+		addValue(parent, name, value)
+	}
+}
+
+// some elements carry their value in an attribute rather than their text
+func (s *structuredParser) propValue(t html.Token) (string, bool) {
+	switch t.DataAtom {
+	case atom.Meta:
+		return getAttr(t, "content"), true
+	case atom.A, atom.Area, atom.Link:
+		return s.resolve(getAttr(t, "href")), true
+	case atom.Img, atom.Audio, atom.Video, atom.Source, atom.Embed, atom.Iframe, atom.Track:
+		return s.resolve(getAttr(t, "src")), true
+	case atom.Object:
+		return s.resolve(getAttr(t, "data")), true
+	case atom.Time:
+		if datetime, ok := attrPresent(t, "datetime"); ok {
+			return datetime, true
+		}
+	case atom.Data, atom.Meter:
+		return getAttr(t, "value"), true
+	}
+
+	if content, ok := attrPresent(t, "content"); ok {
+		return content, true
+	}
+
+	return "", false
+}
+
+func (s *structuredParser) resolve(rawURL string) string {
+	structure, err := url.Parse(rawURL)
+	if err != nil || s.domain == nil {
+		return rawURL
+	}
+
+	return s.domain.ResolveReference(structure).String()
+}
+
+func (s *structuredParser) addOpenGraph(property, content string) {
+	prefix, name, ok := strings.Cut(property, ":")
+	if !ok || name == "" {
+		return
+	}
+
+	switch prefix {
+	case "og":
+		addValue(s.openGraph, name, content)
+	case "article", "book", "product", "profile", "music", "video":
+		addValue(s.openGraph, property, content)
+	}
+}
+
+func (s *structuredParser) addJSONLD(raw []byte) {
+	var decoded any
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		// malformed blocks are common in the wild, skip them rather than failing the page
+		return
+	}
+
+	for _, item := range flattenJSONLD(decoded) {
+		delete(item, "@context")
+		if itemType, ok := item["@type"]; ok {
+			item["@type"] = normaliseType(itemType)
+		}
+		item["@source"] = SourceJSONLD
+
+		s.items = append(s.items, item)
+	}
+}
+
+// results in document order, with open graph last since it's gathered across the head
+func (s *structuredParser) results() []map[string]any {
+	// unclosed microdata at EOF still counts
+	for len(s.stack) > 0 {
+		frame := s.stack[len(s.stack)-1]
+		s.stack = s.stack[:len(s.stack)-1]
+		s.close(frame)
+	}
+
+	items := s.items
+	if len(s.openGraph) > 0 {
+		s.openGraph["@source"] = SourceOpenGraph
+		if ogType, ok := s.openGraph["type"].(string); ok {
+			s.openGraph["@type"] = ogType
+		}
+		items = append(items, s.openGraph)
+	}
+
+	return items
+}
+
+// json-ld can be a single object, an array of objects or a @graph of objects
+func flattenJSONLD(decoded any) []map[string]any {
+	items := []map[string]any{}
+
+	switch value := decoded.(type) {
+	case []any:
+		for _, element := range value {
+			items = append(items, flattenJSONLD(element)...)
+		}
+	case map[string]any:
+		if graph, ok := value["@graph"]; ok {
+			items = append(items, flattenJSONLD(graph)...)
+		} else {
+			items = append(items, value)
+		}
+	}
+
+	return items
+}
+
+func normaliseType(itemType any) any {
+	switch value := itemType.(type) {
+	case string:
+		return schemaType(value)
+	case []any:
+		types := []any{}
+		for _, element := range value {
+			types = append(types, normaliseType(element))
+		}
+		return types
+	}
+
+	return itemType
+}
+
+// "https://schema.org/Article" and "schema:Article" both become "Article"
+func schemaType(itemType string) string {
+	fields := strings.Fields(itemType)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	first := strings.TrimRight(fields[0], "/")
+	if i := strings.LastIndexAny(first, "/#:"); i != -1 {
+		return first[i+1:]
+	}
+
+	return first
+}
+
+// repeated properties turn into a list instead of overwriting each other
+func addValue(item map[string]any, key string, value any) {
+	existing, ok := item[key]
+	if !ok {
+		item[key] = value
+		return
+	}
+
+	if list, ok := existing.([]any); ok {
+		item[key] = append(list, value)
+		return
+	}
+
+	item[key] = []any{existing, value}
+}
+
+func getAttr(t html.Token, key string) string {
+	value, _ := attrPresent(t, key)
+	return value
+}
+
+func attrPresent(t html.Token, key string) (string, bool) {
+	for _, attr := range t.Attr {
+		if attr.Key == key {
+			return strings.TrimSpace(attr.Val), true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// spaces requests to a host out by its crawl delay, kept between the starts of requests however
+// long each one took, requests made at once take turns
+type Throttle struct {
+	mu    sync.Mutex
+	delay time.Duration
+	next  time.Time
+}
+
+func NewThrottle(delay time.Duration) *Throttle {
+	return &Throttle{delay: delay}
+}
+
+// blocks until it's the caller's turn to send a request, or until ctx is done
+func (t *Throttle) Wait(ctx context.Context) error {
+	t.mu.Lock()
+	turn := time.Now()
+	if turn.Before(t.next) {
+		turn = t.next
+	}
+	t.next = turn.Add(t.delay)
+	t.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(turn))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
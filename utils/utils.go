@@ -8,14 +8,15 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
 	"path"
-	"slices"
 	"strconv"
 	"strings"
 
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
 )
 
 func Normalize(rawURL string) (string, error) {
@@ -27,46 +28,193 @@ func Normalize(rawURL string) (string, error) {
 	return structure.Host + strings.TrimRight(structure.Path, "/"), nil
 }
 
-func GetHTML(rawURL string) ([]byte, error) {
-	client := &http.Client{}
+var (
+	ErrUnsupportedType = errors.New("unsupported media type")
+	ErrBodyTooLarge    = errors.New("body too large")
+)
+
+// a page the server refused with a 4xx status code
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d status code returned", e.Code)
+}
+
+// a fetched page along with how many bytes it took to get here
+type Page struct {
+	// the url the page was requested at, before any redirects
+	URL  string
+	Body []byte
+	// the body before it was transcoded to UTF-8, the same as Body for anything that isn't text
+	Original  []byte
+	Status    int
+	MediaType string
+	Encoding  string
+	WireBytes int64
+	Header    http.Header
+	// the request and response as they went over the wire, only kept by GetRawPage
+	Request  []byte
+	Response []byte
+}
+
+// fetches any page whose media type has a registered handler
+func GetPage(rawURL string) (Page, error) {
+	return getPage(rawURL, false)
+}
 
-	res, err := client.Get(rawURL)
+// fetches a text/html page, anything else is refused as it was before handlers were added
+//
+// Deprecated: use GetPage, which also fetches the other media types there are handlers for.
+func GetHTML(rawURL string) ([]byte, error) {
+	page, err := GetPage(rawURL)
 	if err != nil {
 		return []byte{}, err
 	}
+
+	if page.MediaType != "text/html" {
+		return []byte{}, errors.New("content not text/html")
+	}
+
+	return page.Body, nil
+}
+
+// fetches a page like GetPage, also keeping the raw request and the response as received for archiving,
+// the body in Response is still content encoded and the status line and headers are as go parsed them
+func GetRawPage(rawURL string) (Page, error) {
+	return getPage(rawURL, true)
+}
+
+func getPage(rawURL string, raw bool) (Page, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return Page{}, err
+	}
+	req.Header.Set("Accept-Encoding", AcceptEncoding)
+
+	res, err := Client.Do(req)
+	if err != nil {
+		return Page{}, err
+	}
 	defer res.Body.Close()
 
 	// handling a response with bad status code
 	if res.StatusCode >= 400 && res.StatusCode < 500 {
-		return []byte{}, fmt.Errorf("%d status code returned", res.StatusCode)
+		return Page{}, &StatusError{Code: res.StatusCode}
 	}
 
 	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
 	if err != nil {
-		return []byte{}, err
+		return Page{}, err
 	}
-	if mediaType != "text/html" {
-		return []byte{}, errors.New("content not text/html")
+	if _, ok := getHandler(mediaType); !ok {
+		return Page{}, fmt.Errorf("%w %s", ErrUnsupportedType, mediaType)
 	}
 
-	page, err := io.ReadAll(res.Body)
+	wire := &countingReader{reader: res.Body}
+	received := &bytes.Buffer{}
+	if raw {
+		wire.reader = io.TeeReader(res.Body, received)
+	}
+	encoding := res.Header.Get("Content-Encoding")
+
+	original, err := decodeBody(wire, encoding)
+	if err != nil {
+		return Page{}, err
+	}
+
+	page, err := TranscodeText(original, mediaType, res.Header.Get("Content-Type"))
+	if err != nil {
+		return Page{}, err
+	}
+
+	fetched := Page{
+		URL:       rawURL,
+		Body:      page,
+		Original:  original,
+		Status:    res.StatusCode,
+		MediaType: mediaType,
+		Encoding:  encoding,
+		WireBytes: wire.count,
+		Header:    res.Header,
+	}
+
+	if raw {
+		fetched.Request, err = httputil.DumpRequestOut(req, false)
+		if err != nil {
+			return Page{}, err
+		}
+
+		// go drops transfer-encoding from the headers once it's dechunked the body, so the two still agree
+		response := &bytes.Buffer{}
+		fmt.Fprintf(response, "%s %s\r\n", res.Proto, res.Status)
+		if err := res.Header.Write(response); err != nil {
+			return Page{}, err
+		}
+		response.WriteString("\r\n")
+		response.Write(received.Bytes())
+
+		fetched.Response = response.Bytes()
+	}
+
+	return fetched, nil
+}
+
+// only text gets transcoded, binary formats like pdf are passed through untouched
+func TranscodeText(page []byte, mediaType, contentType string) ([]byte, error) {
+	if !strings.HasPrefix(mediaType, "text/") && !strings.HasSuffix(mediaType, "+xml") {
+		return page, nil
+	}
+
+	return ToUTF8(page, contentType)
+}
+
+// transcodes a page to UTF-8, the encoding is sniffed from a BOM, the Content-Type charset
+// parameter or a <meta> charset declaration, in that order
+func ToUTF8(page []byte, contentType string) ([]byte, error) {
+	encoding, _, _ := charset.DetermineEncoding(page, contentType)
+
+	decoded, err := encoding.NewDecoder().Bytes(page)
 	if err != nil {
 		return []byte{}, err
 	}
 
-	return page, nil
+	return bytes.TrimPrefix(decoded, []byte("\uFEFF")), nil
+}
+
+type Link struct {
+	URL string
+	// text between the <a> tags, empty for links found outside of html
+	Text string
+	// rel attribute values like nofollow, sponsored or ugc
+	Rel []string
+	// order the link appeared in on the page, starting at 0, repeats of a url already found aren't counted
+	Position int
+	// outside what a profile's links selector picks, so it's in the link graph but never crawled
+	Unfollowed bool
 }
 
 type Response struct {
-	Title   string
-	Content []string
-	Links   []string
+	Title string
+	// from the lang attribute on <html>, empty if the page doesn't declare one
+	Language   string
+	Content    []string
+	Links      []Link
+	Structured []map[string]any
+	// only found by an extraction profile, as written on the page
+	Date   string
+	Author string
 }
 
 func ParseHTML(domain *url.URL, page []byte) (Response, error) {
 	response := Response{}
 	skip := true
 	title := false
+	anchor := -1
+	// link urls already on the page, a map so link dense pages don't go quadratic
+	seen := map[string]struct{}{}
+	structured := newStructuredParser(domain)
 
 	// tokenizing is better than recursive dives into divs
 	tokens := html.NewTokenizer(bytes.NewReader(page))
@@ -85,6 +233,11 @@ func ParseHTML(domain *url.URL, page []byte) (Response, error) {
 
 		if tn == html.TextToken {
 			t := tokens.Token()
+			structured.text(t.Data)
+
+			if anchor != -1 {
+				response.Links[anchor].Text = strings.Join(strings.Fields(response.Links[anchor].Text+" "+t.Data), " ")
+			}
 
 			if title {
 				response.Title = strings.Join(strings.Fields(t.Data), " ")
@@ -94,7 +247,7 @@ func ParseHTML(domain *url.URL, page []byte) (Response, error) {
 				continue
 			}
 
-			clean := strings.ToLower(strings.Join(strings.Fields(t.Data), " "))
+			clean := strings.Join(strings.Fields(t.Data), " ")
 			if clean != "" {
 				response.Content = append(response.Content, clean)
 			}
@@ -102,8 +255,19 @@ func ParseHTML(domain *url.URL, page []byte) (Response, error) {
 			continue
 		}
 
+		if tn == html.SelfClosingTagToken {
+			structured.start(tokens.Token(), true)
+			continue
+		}
+
 		if tn == html.StartTagToken {
 			t := tokens.Token()
+			structured.start(t, false)
+
+			if t.Data == "html" && t.DataAtom == atom.Html {
+				response.Language = strings.ToLower(getAttr(t, "lang"))
+				continue
+			}
 
 			if t.Data == "p" && t.DataAtom == atom.P {
 				skip = false
@@ -116,6 +280,8 @@ func ParseHTML(domain *url.URL, page []byte) (Response, error) {
 			}
 
 			if t.Data == "a" && t.DataAtom == atom.A {
+				rel := strings.Fields(strings.ToLower(getAttr(t, "rel")))
+
 				for _, attr := range t.Attr {
 					if attr.Key == "href" {
 						structure, err := url.Parse(attr.Val)
@@ -123,15 +289,20 @@ func ParseHTML(domain *url.URL, page []byte) (Response, error) {
 							continue
 						}
 
+						fullURL := attr.Val
 						if structure.Hostname() == "" {
-							fullURL := domain.ResolveReference(structure).String()
-							if comp := slices.Contains(response.Links, fullURL); !comp {
-								response.Links = append(response.Links, fullURL)
-							}
-						} else {
-							if comp := slices.Contains(response.Links, attr.Val); !comp {
-								response.Links = append(response.Links, attr.Val)
-							}
+							fullURL = domain.ResolveReference(structure).String()
+						}
+
+						// anchor text is only kept for the first link to a url
+						if _, ok := seen[fullURL]; !ok {
+							seen[fullURL] = struct{}{}
+							response.Links = append(response.Links, Link{
+								URL:      fullURL,
+								Rel:      rel,
+								Position: len(response.Links),
+							})
+							anchor = len(response.Links) - 1
 						}
 					}
 				}
@@ -140,6 +311,7 @@ func ParseHTML(domain *url.URL, page []byte) (Response, error) {
 
 		if tn == html.EndTagToken {
 			t := tokens.Token()
+			structured.end(t)
 
 			if t.Data == "p" && t.DataAtom == atom.P {
 				skip = true
@@ -150,8 +322,14 @@ func ParseHTML(domain *url.URL, page []byte) (Response, error) {
 				title = false
 				continue
 			}
+
+			if t.Data == "a" && t.DataAtom == atom.A {
+				anchor = -1
+				continue
+			}
 		}
 	}
+	response.Structured = structured.results()
 
 	return response, nil
 }
@@ -159,8 +337,7 @@ func ParseHTML(domain *url.URL, page []byte) (Response, error) {
 func GetRobots(rawURL string) ([]byte, error) {
 	route := fmt.Sprintf("%s/robots.txt", strings.TrimRight(rawURL, "/"))
 
-	client := &http.Client{}
-	res, err := client.Get(route)
+	res, err := Client.Get(route)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -194,6 +371,8 @@ type Rules struct {
 	Allowed    []string
 	Disallowed []string
 	Delay      int
+	// sitemap locations, these apply whatever the user agent
+	Sitemaps []string
 }
 
 func ParseRobots(normURL string, textFile []byte) (Rules, error) {
@@ -207,10 +386,19 @@ func ParseRobots(normURL string, textFile []byte) (Rules, error) {
 			continue
 		}
 
-		line := strings.Split(scanner.Text(), ":")
+		// only split on the first colon, sitemap values are full urls
+		line := strings.SplitN(scanner.Text(), ":", 2)
+		if len(line) < 2 {
+			continue
+		}
 		key := strings.TrimSpace(line[0])
 		value := strings.TrimSpace(line[1])
 
+		if strings.EqualFold(key, "Sitemap") && value != "" {
+			rules.Sitemaps = append(rules.Sitemaps, value)
+			continue
+		}
+
 		if key == "User-agent" {
 			if value == "*" {
 				applicable = true
@@ -243,26 +431,35 @@ func ParseRobots(normURL string, textFile []byte) (Rules, error) {
 	return rules, nil
 }
 
-func CheckAbility(visited map[string]struct{}, rules Rules, normURL string) bool {
-	if _, ok := visited[normURL]; ok {
+func CheckAbility(visited SeenSet, rules Rules, normURL string) bool {
+	if visited.Add(normURL) {
 		return false
-	} else {
-		visited[normURL] = struct{}{}
 	}
 
+	return Allowed(rules, normURL)
+}
+
+// whether robots.txt lets us crawl a normalised url, whether or not it's been seen
+func Allowed(rules Rules, normURL string) bool {
 	green := true
 	disallowedOn := ""
 	allowedOn := ""
 
+	// the root normalises to the bare host, but rules for it end in a slash
+	route := normURL
+	if !strings.Contains(route, "/") {
+		route += "/"
+	}
+
 	// if a route matches under allowed and disallowed, the longer match is final
 	for _, url := range rules.Disallowed {
-		match, err := path.Match(url, normURL)
+		match, err := path.Match(url, route)
 		if err != nil {
 			continue
 		}
 
 		if !match {
-			match = strings.HasPrefix(normURL, url)
+			match = strings.HasPrefix(route, url)
 		}
 		if match {
 			disallowedOn = url
@@ -272,13 +469,13 @@ func CheckAbility(visited map[string]struct{}, rules Rules, normURL string) bool
 	}
 
 	for _, url := range rules.Allowed {
-		match, err := path.Match(url, normURL)
+		match, err := path.Match(url, route)
 		if err != nil {
 			continue
 		}
 
 		if !match {
-			match = strings.HasPrefix(normURL, url)
+			match = strings.HasPrefix(route, url)
 		}
 		if match {
 			allowedOn = url
@@ -331,8 +528,10 @@ func (q *Queue) Dequeue() (string, error) {
 		return "", errors.New("can't pop from an empty queue")
 	}
 
+	// reslicing instead of shifting everything down keeps this O(1)
 	popped := (*q)[0]
-	*q = slices.Delete(*q, 0, 1)
+	(*q)[0] = ""
+	*q = (*q)[1:]
 
 	return popped, nil
 }
@@ -1,12 +1,33 @@
 package utils
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
 )
 
 func TestNormalize(t *testing.T) {
@@ -96,38 +117,39 @@ func TestParseHTML(t *testing.T) {
 		domain: domain,
 		page:   page,
 		expected: Response{
-			Title: "Mixed Links Example",
+			Title:    "Mixed Links Example",
+			Language: "en",
 			Content: []string{
-				"home",
+				"Home",
 				"|",
-				"services",
+				"Services",
 				"|",
-				"github",
-				"this site has a mix of internal and external links for demonstration purposes.",
-				"learn more",
+				"GitHub",
+				"This site has a mix of internal and external links for demonstration purposes.",
+				"Learn more",
 				"about us",
 				"or check out our",
 				"portfolio",
 				".",
-				"visit our",
+				"Visit our",
 				"documentation",
 				"or read the latest",
 				"tech news",
 				".",
-				"questions? reach out via our",
+				"Questions? Reach out via our",
 				"contact page",
 				".",
-				"© 2025 mixed link example",
+				"© 2025 Mixed Link Example",
 			},
-			Links: []string{
-				"https://www.google.com/",
-				"https://www.google.com/services",
-				"https://www.github.com",
-				"https://www.google.com/about",
-				"https://www.example.com/portfolio",
-				"https://www.google.com/docs",
-				"https://news.ycombinator.com",
-				"https://www.google.com/contact",
+			Links: []Link{
+				{URL: "https://www.google.com/", Text: "Home", Rel: []string{}, Position: 0},
+				{URL: "https://www.google.com/services", Text: "Services", Rel: []string{}, Position: 1},
+				{URL: "https://www.github.com", Text: "GitHub", Rel: []string{}, Position: 2},
+				{URL: "https://www.google.com/about", Text: "about us", Rel: []string{}, Position: 3},
+				{URL: "https://www.example.com/portfolio", Text: "portfolio", Rel: []string{}, Position: 4},
+				{URL: "https://www.google.com/docs", Text: "documentation", Rel: []string{}, Position: 5},
+				{URL: "https://news.ycombinator.com", Text: "tech news", Rel: []string{}, Position: 6},
+				{URL: "https://www.google.com/contact", Text: "contact page", Rel: []string{}, Position: 7},
 			},
 		},
 	}
@@ -143,16 +165,166 @@ func TestParseHTML(t *testing.T) {
 			t.Errorf("%s failed, %s != %s", testCase.name, result.Title, testCase.expected.Title)
 		}
 
+		if result.Language != testCase.expected.Language {
+			t.Errorf("%s failed, %s != %s", testCase.name, result.Language, testCase.expected.Language)
+		}
+
 		if comp := slices.Equal(result.Content, testCase.expected.Content); !comp {
 			t.Errorf("%s failed, %v != %v", testCase.name, result.Content, testCase.expected.Content)
 		}
 
-		if comp := slices.Equal(result.Links, testCase.expected.Links); !comp {
+		if comp := reflect.DeepEqual(result.Links, testCase.expected.Links); !comp {
 			t.Errorf("%s failed, %v != %v", testCase.name, result.Links, testCase.expected.Links)
 		}
 	})
 }
 
+func TestParseHTMLLinks(t *testing.T) {
+	page, err := os.ReadFile("./test_files/links.html")
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+
+	domain, err := url.Parse("https://www.example.com")
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+
+	// rel values are lowercased, and repeats keep the first link's text without taking up a position
+	expected := []Link{
+		{URL: "https://www.example.com/", Text: "Home", Rel: []string{}, Position: 0},
+		{URL: "https://www.example.com/blog", Text: "Blog", Rel: []string{"bookmark"}, Position: 1},
+		{URL: "https://sponsor.example.com", Text: "sponsor", Rel: []string{"sponsored", "nofollow"}, Position: 2},
+		{URL: "https://forum.example.com/post", Text: "forum post", Rel: []string{"ugc"}, Position: 3},
+		{URL: "https://www.example.com/about", Text: "about page", Rel: []string{}, Position: 4},
+	}
+
+	result, err := ParseHTML(domain, page)
+	if err != nil {
+		t.Errorf("ParseHTML links: test case 1 failed, unexpected error: %v", err)
+	}
+
+	if comp := reflect.DeepEqual(result.Links, expected); !comp {
+		t.Errorf("ParseHTML links: test case 2 failed, %v != %v", result.Links, expected)
+	}
+}
+
+func TestParseStructured(t *testing.T) {
+	page, err := os.ReadFile("./test_files/structured.html")
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+
+	domain, err := url.Parse("https://www.google.com")
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+
+	testCase := struct {
+		name     string
+		domain   *url.URL
+		page     []byte
+		expected []map[string]any
+	}{
+		name:   "ParseHTML: structured data test case 1",
+		domain: domain,
+		page:   page,
+		expected: []map[string]any{
+			{
+				"@source":  SourceJSONLD,
+				"@type":    "Article",
+				"headline": "Structured Data Example",
+				"author": map[string]any{
+					"@type": "Person",
+					"name":  "Jane Doe",
+				},
+			},
+			{
+				"@source": SourceJSONLD,
+				"@type":   "FAQPage",
+				"name":    "Questions",
+			},
+			{
+				"@source": SourceJSONLD,
+				"@type":   []any{"Product", "Thing"},
+				"name":    "Widget",
+			},
+			{
+				"@source": SourceMicrodata,
+				"@type":   "Product",
+				"name":    "Widget Pro",
+				"image":   "https://www.google.com/widget.png",
+				"url":     "https://www.google.com/widget",
+				"offers": map[string]any{
+					"@source":       SourceMicrodata,
+					"@type":         "Offer",
+					"priceCurrency": "USD",
+					"price":         "19.99",
+				},
+				"description": "A very useful widget.",
+			},
+			{
+				"@source":        SourceOpenGraph,
+				"@type":          "article",
+				"title":          "Structured Data Example",
+				"type":           "article",
+				"image":          []any{"https://www.google.com/a.png", "https://www.google.com/b.png"},
+				"article:author": "Jane Doe",
+			},
+		},
+	}
+
+	t.Run(testCase.name, func(t *testing.T) {
+		result, err := ParseHTML(testCase.domain, testCase.page)
+
+		if err != nil {
+			t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+		}
+
+		if comp := reflect.DeepEqual(result.Structured, testCase.expected); !comp {
+			t.Errorf("%s failed, %v != %v", testCase.name, result.Structured, testCase.expected)
+		}
+	})
+}
+
+func TestParseStructuredNested(t *testing.T) {
+	// items and their properties on the same tag, with a stray property and another item after them
+	page := []byte(`<html><body>
+<div itemscope itemtype="https://schema.org/Product">
+	<div itemprop="name">Widget</div>
+	<div><div itemprop="offers" itemscope itemtype="https://schema.org/Offer"><div itemprop="price">19.99</div></div></div>
+</div>
+<div itemprop="color">red</div>
+<div itemscope itemtype="https://schema.org/Person"><div itemprop="name">Jane Doe</div></div>
+</body></html>`)
+
+	expected := []map[string]any{
+		{
+			"@source": SourceMicrodata,
+			"@type":   "Product",
+			"name":    "Widget",
+			"offers": map[string]any{
+				"@source": SourceMicrodata,
+				"@type":   "Offer",
+				"price":   "19.99",
+			},
+		},
+		{
+			"@source": SourceMicrodata,
+			"@type":   "Person",
+			"name":    "Jane Doe",
+		},
+	}
+
+	result, err := ParseHTML(nil, page)
+	if err != nil {
+		t.Fatalf("ParseHTML: nested structured data test case 1 failed, unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result.Structured, expected) {
+		t.Errorf("ParseHTML: nested structured data test case 2 failed, %v != %v", result.Structured, expected)
+	}
+}
+
 func TestParseRobots(t *testing.T) {
 	textFile, err := os.ReadFile("./test_files/example.txt")
 	if err != nil {
@@ -230,13 +402,30 @@ func TestParseRobots(t *testing.T) {
 		if result.Delay != testCase.expected.Delay {
 			t.Errorf("%s failed, %v != %v", testCase.name, result.Delay, testCase.expected.Delay)
 		}
+
 	})
+
+	// lines without a colon used to panic
+	if _, err := ParseRobots("www.google.com", []byte("User-agent: *\nnonsense\nDisallow: /private")); err != nil {
+		t.Errorf("ParseRobots: test case 2 failed, unexpected error: %v", err)
+	}
+
+	// sitemap lines stand apart from any user agent's group
+	sitemapFile, err := os.ReadFile("./test_files/example_sitemap.txt")
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+	result, err := ParseRobots("www.google.com", sitemapFile)
+	expected := []string{"https://arxiv.org/sitemap.xml", "https://arxiv.org/sitemap_index.xml"}
+	if err != nil || !slices.Equal(result.Sitemaps, expected) || result.Delay != 2 {
+		t.Errorf("ParseRobots: test case 3 failed, %v != %v (%v)", result.Sitemaps, expected, err)
+	}
 }
 
 func TestCheckAbility(t *testing.T) {
 	testCases := []struct {
 		name     string
-		visited  map[string]struct{}
+		visited  MemorySeen
 		rules    Rules
 		normURL  string
 		expected bool
@@ -364,6 +553,17 @@ func TestCheckAbility(t *testing.T) {
 			normURL:  "www.google.com/maps/places/oregon",
 			expected: false,
 		},
+		{
+			name:    "CheckAbility: test case 12",
+			visited: map[string]struct{}{},
+			rules: Rules{
+				Disallowed: []string{
+					"www.google.com/",
+				},
+			},
+			normURL:  "www.google.com",
+			expected: false,
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -375,6 +575,51 @@ func TestCheckAbility(t *testing.T) {
 	}
 }
 
+func TestAllowed(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rules    Rules
+		normURL  string
+		expected bool
+	}{
+		{
+			name:     "Allowed: test case 1",
+			rules:    Rules{},
+			normURL:  "www.google.com",
+			expected: true,
+		},
+		{
+			name:     "Allowed: test case 2",
+			rules:    Rules{Disallowed: []string{"www.google.com/"}},
+			normURL:  "www.google.com",
+			expected: false,
+		},
+		{
+			name:     "Allowed: test case 3",
+			rules:    Rules{Disallowed: []string{"www.google.com/"}, Allowed: []string{"www.google.com/maps"}},
+			normURL:  "www.google.com/maps",
+			expected: true,
+		},
+		{
+			name:     "Allowed: test case 4",
+			rules:    Rules{Disallowed: []string{"www.google.com/maps"}},
+			normURL:  "www.google.com",
+			expected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			// asking twice gives the same answer, nothing is remembered
+			for range 2 {
+				if result := Allowed(testCase.rules, testCase.normURL); result != testCase.expected {
+					t.Errorf("%s failed, %v != %v", testCase.name, result, testCase.expected)
+				}
+			}
+		})
+	}
+}
+
 func TestCheckDomain(t *testing.T) {
 	dom, err := url.Parse("https://www.google.com")
 	if err != nil {
@@ -482,3 +727,1333 @@ func TestQueue(t *testing.T) {
 		t.Errorf("Queue: test case 11 failed, expected error: %s", errors.New("queue empty"))
 	}
 }
+
+func TestNormalise(t *testing.T) {
+	testCases := []struct {
+		name       string
+		normaliser Normaliser
+		input      string
+		expected   string
+	}{
+		{
+			name:       "Normalise: test case 1",
+			normaliser: Normaliser{},
+			input:      "  Café   au LAIT.  ",
+			expected:   "Café au LAIT.",
+		},
+		{
+			name:       "Normalise: test case 2",
+			normaliser: Normaliser{Lowercase: true, StripPunctuation: true},
+			input:      "Café au LAIT, costs €3.50!",
+			expected:   "café au lait costs 3.50",
+		},
+		{
+			name:       "Normalise: test case 3",
+			normaliser: Normaliser{Lowercase: true, StripPunctuation: true, FoldDiacritics: true},
+			input:      "Café naïve résumé",
+			expected:   "cafe naive resume",
+		},
+		{
+			name:       "Normalise: test case 4",
+			normaliser: Normaliser{Lowercase: true, StripPunctuation: true},
+			input:      "Привет, МИР! 東京は「晴れ」です。",
+			expected:   "привет мир 東京は 晴れ です",
+		},
+		{
+			name:       "Normalise: test case 5",
+			normaliser: Normaliser{StripPunctuation: true},
+			input:      "fmt.Println(x) returns 1,000 items.",
+			expected:   "fmt Println x returns 1,000 items",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if result := testCase.normaliser.Normalise(testCase.input); result != testCase.expected {
+				t.Errorf("%s failed, %s != %s", testCase.name, result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestToUTF8(t *testing.T) {
+	shiftJIS, err := japanese.ShiftJIS.NewEncoder().String(`<html><head><meta charset="Shift_JIS"></head><body><p>日本語</p></body></html>`)
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+
+	gbk, err := simplifiedchinese.GBK.NewEncoder().String("<p>中文</p>")
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name        string
+		page        []byte
+		contentType string
+		expected    string
+	}{
+		{
+			name:        "ToUTF8: test case 1",
+			page:        []byte("<p>café</p>"),
+			contentType: "text/html",
+			expected:    "<p>café</p>",
+		},
+		{
+			name:        "ToUTF8: test case 2",
+			page:        []byte("<p>caf\xe9</p>"),
+			contentType: "text/html; charset=windows-1252",
+			expected:    "<p>café</p>",
+		},
+		{
+			name:        "ToUTF8: test case 3",
+			page:        []byte("<p>\xbfQu\xe9?</p>"),
+			contentType: "text/html; charset=ISO-8859-1",
+			expected:    "<p>¿Qué?</p>",
+		},
+		{
+			name:        "ToUTF8: test case 4",
+			page:        []byte(shiftJIS),
+			contentType: "text/html",
+			expected:    `<html><head><meta charset="Shift_JIS"></head><body><p>日本語</p></body></html>`,
+		},
+		{
+			name:        "ToUTF8: test case 5",
+			page:        []byte(gbk),
+			contentType: "text/html; charset=gbk",
+			expected:    "<p>中文</p>",
+		},
+		{
+			name:        "ToUTF8: test case 6",
+			page:        []byte("\xff\xfe<\x00p\x00>\x00\xe9\x00"),
+			contentType: "text/html; charset=windows-1252",
+			expected:    "<p>é",
+		},
+		{
+			name:        "ToUTF8: test case 7",
+			page:        []byte("\xef\xbb\xbf<p>café</p>"),
+			contentType: "text/html",
+			expected:    "<p>café</p>",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := ToUTF8(testCase.page, testCase.contentType)
+
+			if err != nil {
+				t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+			}
+
+			if string(result) != testCase.expected {
+				t.Errorf("%s failed, %s != %s", testCase.name, result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestGetPageOriginal(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=windows-1252")
+		w.Write([]byte("<p>caf\xe9</p>"))
+	}))
+	defer server.Close()
+
+	page, err := GetPage(server.URL)
+	if err != nil {
+		t.Fatalf("GetPageOriginal: test case 1 failed, unexpected error: %v", err)
+	}
+	if string(page.Body) != "<p>café</p>" {
+		t.Errorf("GetPageOriginal: test case 2 failed, %q != %q", page.Body, "<p>café</p>")
+	}
+	if string(page.Original) != "<p>caf\xe9</p>" {
+		t.Errorf("GetPageOriginal: test case 3 failed, %q != %q", page.Original, "<p>caf\xe9</p>")
+	}
+}
+
+func TestGetPageEncodings(t *testing.T) {
+	page := strings.Repeat("<p>compressed content</p>", 200)
+
+	compress := func(encoding string) []byte {
+		buffer := &bytes.Buffer{}
+
+		var writer io.WriteCloser
+		switch encoding {
+		case "gzip":
+			writer = gzip.NewWriter(buffer)
+		case "deflate":
+			writer = zlib.NewWriter(buffer)
+		case "br":
+			writer = brotli.NewWriter(buffer)
+		case "zstd":
+			zs, err := zstd.NewWriter(buffer)
+			if err != nil {
+				t.Errorf("error setting up test, unexpected error: %v", err)
+			}
+			writer = zs
+		}
+
+		writer.Write([]byte(page))
+		writer.Close()
+
+		return buffer.Bytes()
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != AcceptEncoding {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		encoding := strings.TrimPrefix(r.URL.Path, "/")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		if encoding == "identity" {
+			w.Write([]byte(page))
+			return
+		}
+
+		w.Header().Set("Content-Encoding", encoding)
+		w.Write(compress(encoding))
+	}))
+	defer server.Close()
+
+	for i, encoding := range []string{"identity", "gzip", "deflate", "br", "zstd"} {
+		name := fmt.Sprintf("GetPage: test case %d", i+1)
+
+		t.Run(name, func(t *testing.T) {
+			result, err := GetPage(fmt.Sprintf("%s/%s", server.URL, encoding))
+			if err != nil {
+				t.Errorf("%s failed, unexpected error: %v", name, err)
+			}
+
+			if string(result.Body) != page {
+				t.Errorf("%s failed, body doesn't match for %s", name, encoding)
+			}
+
+			if encoding != "identity" && result.WireBytes >= int64(len(result.Body)) {
+				t.Errorf("%s failed, %d wire bytes not smaller than %d", name, result.WireBytes, len(result.Body))
+			}
+		})
+	}
+
+	t.Run("GetPage: test case 6", func(t *testing.T) {
+		limit := MaxBodySize
+		MaxBodySize = int64(len(page) - 1)
+		defer func() {
+			MaxBodySize = limit
+		}()
+
+		if _, err := GetPage(fmt.Sprintf("%s/%s", server.URL, "gzip")); err == nil {
+			t.Errorf("GetPage: test case 6 failed, expected error for body over the size limit")
+		}
+	})
+
+	t.Run("GetRawPage: test case 1", func(t *testing.T) {
+		result, err := GetRawPage(fmt.Sprintf("%s/%s", server.URL, "gzip"))
+		if err != nil {
+			t.Fatalf("GetRawPage: test case 1 failed, unexpected error: %v", err)
+		}
+
+		if !bytes.HasPrefix(result.Request, []byte("GET /gzip HTTP/1.1\r\n")) || !bytes.Contains(result.Request, []byte("Accept-Encoding: "+AcceptEncoding)) {
+			t.Errorf("GetRawPage: test case 1 failed, unexpected request %q", result.Request)
+		}
+
+		head, body, ok := bytes.Cut(result.Response, []byte("\r\n\r\n"))
+		if !ok || !bytes.HasPrefix(head, []byte("HTTP/1.1 200 OK\r\n")) || !bytes.Contains(head, []byte("Content-Encoding: gzip")) {
+			t.Errorf("GetRawPage: test case 1 failed, unexpected response head %q", head)
+		}
+
+		// the archived body is exactly what came over the wire
+		if !bytes.Equal(body, compress("gzip")) {
+			t.Errorf("GetRawPage: test case 1 failed, body isn't the gzip encoded page")
+		}
+	})
+
+	t.Run("GetRawPage: test case 2", func(t *testing.T) {
+		result, err := GetPage(fmt.Sprintf("%s/%s", server.URL, "gzip"))
+		if err != nil || result.Request != nil || result.Response != nil {
+			t.Errorf("GetRawPage: test case 2 failed, GetPage kept the raw exchange (%v)", err)
+		}
+	})
+}
+
+func TestParseText(t *testing.T) {
+	page, err := os.ReadFile("./test_files/example_plain.txt")
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+
+	domain, err := url.Parse("https://www.google.com")
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+
+	expected := Response{
+		Title: "Attention Is All You Need",
+		Content: []string{
+			"Attention Is All You Need",
+			"The dominant sequence transduction models are based on recurrent or convolutional neural networks.",
+			"Code is available at https://www.google.com/code and the paper at https://www.example.com/paper.pdf.",
+		},
+		Links: []Link{
+			{URL: "https://www.google.com/code", Position: 0},
+			{URL: "https://www.example.com/paper.pdf", Position: 1},
+		},
+	}
+
+	t.Run("ParseText: test case 1", func(t *testing.T) {
+		result, err := ParseText(domain, page)
+		if err != nil {
+			t.Errorf("ParseText: test case 1 failed, unexpected error: %v", err)
+		}
+
+		if comp := reflect.DeepEqual(result, expected); !comp {
+			t.Errorf("ParseText: test case 1 failed, %v != %v", result, expected)
+		}
+	})
+}
+
+func TestParsePDF(t *testing.T) {
+	page, err := os.ReadFile("./test_files/example.pdf")
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+
+	domain, err := url.Parse("https://www.google.com")
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name         string
+		page         []byte
+		expected     Response
+		errorPresent bool
+	}{
+		{
+			name: "ParsePDF: test case 1",
+			page: page,
+			expected: Response{
+				Title: "Attention Is All You Need",
+				Content: []string{
+					"Attention Is All You Need",
+					"The dominant sequence transduction models are based on recurrent networks.",
+					"We propose a new simple network architecture, the Transformer.",
+				},
+				Links: []Link{
+					{URL: "https://www.google.com/abs/1706.03762", Position: 0},
+					{URL: "https://www.google.com/pdf/1706.03762v2", Position: 1},
+				},
+			},
+			errorPresent: false,
+		},
+		{
+			name:         "ParsePDF: test case 2",
+			page:         []byte("%PDF-1.4 not really a pdf"),
+			expected:     Response{},
+			errorPresent: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := ParsePDF(domain, testCase.page)
+
+			if (err != nil) != testCase.errorPresent {
+				t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+			}
+
+			if comp := reflect.DeepEqual(result, testCase.expected); !comp {
+				t.Errorf("%s failed, %v != %v", testCase.name, result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestGetPageMediaTypes(t *testing.T) {
+	RegisterHandler("application/x-custom", func(domain *url.URL, page []byte) (Response, error) {
+		return Response{Title: string(page)}, nil
+	})
+	// handlers are global, so the custom one mustn't outlive the test
+	t.Cleanup(func() {
+		handlersMu.Lock()
+		defer handlersMu.Unlock()
+
+		delete(handlers, "application/x-custom")
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", strings.TrimPrefix(r.URL.Path, "/"))
+		w.Write([]byte("<html><head><title>Media</title></head></html>"))
+	}))
+	defer server.Close()
+
+	domain, err := url.Parse(server.URL)
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name         string
+		mediaType    string
+		expected     string
+		errorPresent bool
+	}{
+		{
+			name:         "GetPage: media type test case 1",
+			mediaType:    "application/xhtml+xml",
+			expected:     "Media",
+			errorPresent: false,
+		},
+		{
+			name:         "GetPage: media type test case 2",
+			mediaType:    "application/x-custom",
+			expected:     "<html><head><title>Media</title></head></html>",
+			errorPresent: false,
+		},
+		{
+			name:         "GetPage: media type test case 3",
+			mediaType:    "image/png",
+			expected:     "",
+			errorPresent: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			page, err := GetPage(fmt.Sprintf("%s/%s", server.URL, testCase.mediaType))
+			if (err != nil) != testCase.errorPresent {
+				t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+			}
+			if err != nil {
+				return
+			}
+
+			result, err := Parse(domain, page)
+			if err != nil {
+				t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+			}
+
+			if result.Title != testCase.expected {
+				t.Errorf("%s failed, %s != %s", testCase.name, result.Title, testCase.expected)
+			}
+		})
+	}
+
+	// the deprecated GetHTML still only takes text/html
+	if page, err := GetHTML(server.URL + "/text/html"); err != nil || !strings.Contains(string(page), "<title>Media</title>") {
+		t.Errorf("GetHTML: test case 1 failed, unexpected page %s (%v)", page, err)
+	}
+	if _, err := GetHTML(server.URL + "/application/xhtml+xml"); err == nil {
+		t.Errorf("GetHTML: test case 2 failed, expected error for application/xhtml+xml")
+	}
+}
+
+func TestSimHash(t *testing.T) {
+	base := "the quick brown fox jumps over the lazy dog while the farmer watches from the porch and the sun sets slowly behind the hills of the valley"
+	nearly := "the quick brown fox jumps over the lazy dog while the farmer watches from the porch and the sun sets slowly behind the hills of the river"
+	different := "quarterly earnings rose sharply as the company expanded into new markets across asia and europe despite rising costs for raw materials"
+
+	if result := SimHash(base); result != SimHash(base) {
+		t.Errorf("SimHash: test case 1 failed, %d != %d", result, SimHash(base))
+	}
+
+	if distance := HammingDistance(SimHash(base), SimHash(nearly)); distance > 10 {
+		t.Errorf("SimHash: test case 2 failed, near duplicates %d bits apart", distance)
+	}
+
+	if distance := HammingDistance(SimHash(base), SimHash(different)); distance < 16 {
+		t.Errorf("SimHash: test case 3 failed, different texts only %d bits apart", distance)
+	}
+
+	if result := SimHash(""); result != 0 {
+		t.Errorf("SimHash: test case 4 failed, %d != %d", result, 0)
+	}
+}
+
+func TestFingerprintIndex(t *testing.T) {
+	testCases := []struct {
+		name          string
+		url           string
+		value         uint64
+		expected      string
+		expectedFound bool
+	}{
+		{
+			name:          "FingerprintIndex: test case 1",
+			url:           "https://www.google.com/a",
+			value:         0xF0F0F0F0F0F0F0F0,
+			expected:      "",
+			expectedFound: false,
+		},
+		{
+			name:          "FingerprintIndex: test case 2",
+			url:           "https://www.google.com/b",
+			value:         0xF0F0F0F0F0F0F0F7,
+			expected:      "https://www.google.com/a",
+			expectedFound: true,
+		},
+		{
+			name:          "FingerprintIndex: test case 3",
+			url:           "https://www.google.com/c",
+			value:         0xF0F0F0F0F0F0F0FF,
+			expected:      "",
+			expectedFound: false,
+		},
+		{
+			name:          "FingerprintIndex: test case 4",
+			url:           "https://www.google.com/d",
+			value:         0x0F0F0F0F0F0F0F0F,
+			expected:      "",
+			expectedFound: false,
+		},
+		{
+			name:          "FingerprintIndex: test case 5",
+			url:           "https://www.google.com/e",
+			value:         0x8F0F0F0F0F0F0F0E,
+			expected:      "https://www.google.com/d",
+			expectedFound: true,
+		},
+	}
+
+	index := NewFingerprintIndex(3)
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, found := index.CheckAndAdd(testCase.url, testCase.value)
+
+			if found != testCase.expectedFound {
+				t.Errorf("%s failed, %t != %t", testCase.name, found, testCase.expectedFound)
+			}
+
+			if result != testCase.expected {
+				t.Errorf("%s failed, %s != %s", testCase.name, result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestFrontier(t *testing.T) {
+	testCases := []struct {
+		name     string
+		frontier Frontier
+		expected []string
+	}{
+		{
+			name:     "Frontier: test case 1",
+			frontier: NewFIFO(),
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name:     "Frontier: test case 2",
+			frontier: NewLIFO(),
+			expected: []string{"c", "b", "a"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		for _, url := range []string{"a", "b", "c"} {
+			testCase.frontier.Push(Item{URL: url})
+		}
+
+		result := []string{}
+		for testCase.frontier.Len() > 0 {
+			item, err := testCase.frontier.Pop()
+			if err != nil {
+				t.Errorf("%s failed, unexpected error: %v", testCase.name, err)
+			}
+			result = append(result, item.URL)
+		}
+
+		if comp := slices.Equal(result, testCase.expected); !comp {
+			t.Errorf("%s failed, %v != %v", testCase.name, result, testCase.expected)
+		}
+
+		if _, err := testCase.frontier.Pop(); !errors.Is(err, ErrFrontierEmpty) {
+			t.Errorf("%s failed, expected error: %v", testCase.name, ErrFrontierEmpty)
+		}
+	}
+
+	// interleaving pushes and pops makes the ring buffer wrap around before it grows
+	fifo := NewFIFO()
+	next, expected := 0, 0
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 7; i++ {
+			fifo.Push(Item{URL: fmt.Sprint(next)})
+			next++
+		}
+		for i := 0; i < 5; i++ {
+			item, _ := fifo.Pop()
+			if item.URL != fmt.Sprint(expected) {
+				t.Fatalf("Frontier: test case 3 failed, %s != %d", item.URL, expected)
+			}
+			expected++
+		}
+	}
+	if fifo.Len() != next-expected {
+		t.Errorf("Frontier: test case 4 failed, %d != %d", fifo.Len(), next-expected)
+	}
+}
+
+func TestPriorityFrontier(t *testing.T) {
+	patterns := []Pattern{
+		{Match: regexp.MustCompile(`/docs/`), Weight: 2},
+		{Match: regexp.MustCompile(`/tag/`), Weight: -2},
+	}
+	sitemap := []SitemapURL{{Loc: "https://example.com/about/", Priority: 0.9}}
+
+	frontier := NewPriority(Combine(
+		Weighted{Scorer: SitemapScorer(sitemap), Weight: 1},
+		Weighted{Scorer: InlinkScorer, Weight: 1},
+		Weighted{Scorer: DepthScorer, Weight: 0.1},
+		Weighted{Scorer: PatternScorer(patterns), Weight: 1},
+	))
+
+	frontier.Push(Item{URL: "https://example.com/tag/go", Depth: 1})
+	frontier.Push(Item{URL: "https://example.com/news", Depth: 1})
+	frontier.Push(Item{URL: "https://example.com/blog", Depth: 1})
+	frontier.Push(Item{URL: "https://example.com/about", Depth: 1})
+	frontier.Push(Item{URL: "https://example.com/docs/intro", Depth: 2})
+	// pushed again from other pages, so it now has three inlinks
+	frontier.Push(Item{URL: "https://example.com/blog", Depth: 2})
+	frontier.Push(Item{URL: "https://example.com/blog", Depth: 3})
+
+	if frontier.Len() != 5 {
+		t.Errorf("PriorityFrontier: test case 1 failed, %d != %d", frontier.Len(), 5)
+	}
+
+	expected := []string{
+		"https://example.com/docs/intro",
+		"https://example.com/about",
+		"https://example.com/blog",
+		"https://example.com/news",
+		"https://example.com/tag/go",
+	}
+
+	result := []string{}
+	for frontier.Len() > 0 {
+		item, err := frontier.Pop()
+		if err != nil {
+			t.Errorf("PriorityFrontier: test case 2 failed, unexpected error: %v", err)
+		}
+		if item.URL == "https://example.com/blog" && (item.Inlinks != 3 || item.Depth != 1) {
+			t.Errorf("PriorityFrontier: test case 3 failed, %+v", item)
+		}
+		result = append(result, item.URL)
+	}
+
+	if comp := slices.Equal(result, expected); !comp {
+		t.Errorf("PriorityFrontier: test case 4 failed, %v != %v", result, expected)
+	}
+
+	// with every score equal it falls back to the order urls were pushed in
+	ties := NewPriority(func(Item) float64 { return 0 })
+	for _, url := range []string{"a", "b", "c", "d"} {
+		ties.Push(Item{URL: url})
+	}
+	for _, url := range []string{"a", "b", "c", "d"} {
+		if item, _ := ties.Pop(); item.URL != url {
+			t.Errorf("PriorityFrontier: test case 5 failed, %s != %s", item.URL, url)
+		}
+	}
+}
+
+func TestParseSitemap(t *testing.T) {
+	page, err := os.ReadFile("./test_files/sitemap.xml")
+	if err != nil {
+		t.Errorf("error setting up test, unexpected error: %v", err)
+	}
+
+	urls, children, err := ParseSitemap(page)
+	if err != nil {
+		t.Errorf("ParseSitemap: test case 1 failed, unexpected error: %v", err)
+	}
+
+	expected := []SitemapURL{
+		{Loc: "https://example.com/", Priority: 1},
+		{Loc: "https://example.com/docs/getting-started", Priority: 0.8},
+		{Loc: "https://example.com/blog/archive", Priority: 0.5},
+		{Loc: "https://example.com/legal", Priority: 0.5},
+	}
+	if comp := slices.Equal(urls, expected); !comp {
+		t.Errorf("ParseSitemap: test case 2 failed, %v != %v", urls, expected)
+	}
+	if len(children) != 0 {
+		t.Errorf("ParseSitemap: test case 3 failed, %v not empty", children)
+	}
+
+	index := []byte(`<sitemapindex><sitemap><loc>https://example.com/a.xml</loc></sitemap><sitemap><loc>https://example.com/b.xml.gz</loc></sitemap></sitemapindex>`)
+	_, children, err = ParseSitemap(index)
+	if err != nil || !slices.Equal(children, []string{"https://example.com/a.xml", "https://example.com/b.xml.gz"}) {
+		t.Errorf("ParseSitemap: test case 4 failed, %v, %v", children, err)
+	}
+
+	if _, _, err := ParseSitemap([]byte("not xml")); err == nil {
+		t.Errorf("ParseSitemap: test case 5 failed, expected error")
+	}
+}
+
+func TestGetSitemaps(t *testing.T) {
+	mu := sync.Mutex{}
+	requested := []time.Time{}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, time.Now())
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%[1]s/pages.xml</loc></sitemap><sitemap><loc>%[1]s/more.xml.gz</loc></sitemap><sitemap><loc>%[1]s/missing.xml</loc></sitemap></sitemapindex>`, server.URL)
+		case "/pages.xml":
+			fmt.Fprintf(w, `<urlset><url><loc>%s/a</loc><priority>0.9</priority></url></urlset>`, server.URL)
+		case "/more.xml.gz":
+			gz := gzip.NewWriter(w)
+			fmt.Fprintf(gz, `<urlset><url><loc>%s/b</loc></url></urlset>`, server.URL)
+			gz.Close()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	expected := []SitemapURL{
+		{Loc: server.URL + "/a", Priority: 0.9},
+		{Loc: server.URL + "/b", Priority: 0.5},
+	}
+
+	if urls := GetSitemaps(t.Context(), server.URL, nil, NewThrottle(0)); !slices.Equal(urls, expected) {
+		t.Errorf("GetSitemaps: test case 1 failed, %v != %v", urls, expected)
+	}
+
+	if urls := GetSitemaps(t.Context(), server.URL, []string{server.URL + "/pages.xml"}, NewThrottle(0)); !slices.Equal(urls, expected[:1]) {
+		t.Errorf("GetSitemaps: test case 2 failed, %v != %v", urls, expected[:1])
+	}
+
+	// every sitemap fetched waits out the crawl delay, the missing one included
+	mu.Lock()
+	requested = []time.Time{}
+	mu.Unlock()
+
+	delay := 100 * time.Millisecond
+	if urls := GetSitemaps(t.Context(), server.URL, nil, NewThrottle(delay)); !slices.Equal(urls, expected) {
+		t.Errorf("GetSitemaps: test case 3 failed, %v != %v", urls, expected)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requested) != 4 {
+		t.Fatalf("GetSitemaps: test case 4 failed, %d != %d sitemaps requested", len(requested), 4)
+	}
+	for i := 1; i < len(requested); i++ {
+		if gap := requested[i].Sub(requested[i-1]); gap < delay-5*time.Millisecond {
+			t.Errorf("GetSitemaps: test case 5 failed, %v between requests, less than %v", gap, delay)
+		}
+	}
+
+	// a cancelled crawl stops fetching them
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if urls := GetSitemaps(ctx, server.URL, nil, NewThrottle(delay)); len(urls) != 0 {
+		t.Errorf("GetSitemaps: test case 6 failed, %v != %v", urls, []SitemapURL{})
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	bloom := newBloomFilter(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		bloom.add(hashURL(fmt.Sprintf("https://example.com/%d", i)))
+	}
+
+	for i := 0; i < 10000; i++ {
+		if !bloom.test(hashURL(fmt.Sprintf("https://example.com/%d", i))) {
+			t.Fatalf("BloomFilter: test case 1 failed, %d missing", i)
+		}
+	}
+
+	positives := 0
+	for i := 0; i < 10000; i++ {
+		if bloom.test(hashURL(fmt.Sprintf("https://example.org/%d", i))) {
+			positives++
+		}
+	}
+	if positives > 300 {
+		t.Errorf("BloomFilter: test case 2 failed, %d false positives out of 10000", positives)
+	}
+}
+
+func TestDiskSeen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "seen")
+
+	seen, err := NewDiskSeen(dir, 100, NewBloomFilter(1000))
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	// enough windows to spill more than maxSeenSegments times, so segments get merged too
+	for i := 0; i < 1500; i++ {
+		if seen.Add(fmt.Sprintf("example.com/%d", i)) {
+			t.Fatalf("DiskSeen: test case 1 failed, %d reported as seen", i)
+		}
+	}
+
+	if len(seen.memory) >= 100 {
+		t.Errorf("DiskSeen: test case 2 failed, %d hashes held in memory", len(seen.memory))
+	}
+	if len(seen.segments) > maxSeenSegments {
+		t.Errorf("DiskSeen: test case 3 failed, %d segments weren't merged", len(seen.segments))
+	}
+
+	for i := 0; i < 1500; i++ {
+		if !seen.Add(fmt.Sprintf("example.com/%d", i)) {
+			t.Fatalf("DiskSeen: test case 4 failed, %d not seen", i)
+		}
+	}
+	if seen.Has("example.com/unseen") {
+		t.Errorf("DiskSeen: test case 5 failed, unseen url reported as seen")
+	}
+	if seen.Len() != 1500 {
+		t.Errorf("DiskSeen: test case 6 failed, %d != %d", seen.Len(), 1500)
+	}
+
+	if err := seen.Close(); err != nil {
+		t.Errorf("DiskSeen: test case 7 failed, unexpected error: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("DiskSeen: test case 8 failed, %s not removed", dir)
+	}
+}
+
+func TestDiskSeenShared(t *testing.T) {
+	bloom := NewBloomFilter(1000)
+
+	first, err := NewDiskSeen(filepath.Join(t.TempDir(), "first"), 10, bloom)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	defer first.Close()
+	second, err := NewDiskSeen(filepath.Join(t.TempDir(), "second"), 10, bloom)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	defer second.Close()
+
+	// both sets add at once, and neither should see what the other added
+	wg := sync.WaitGroup{}
+	for _, seen := range []*DiskSeen{first, second} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				seen.Add(fmt.Sprintf("%s/%d", seen.dir, i))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 200; i++ {
+		if first.Has(fmt.Sprintf("%s/%d", second.dir, i)) {
+			t.Fatalf("DiskSeenShared: test case 1 failed, %d from the second set seen by the first", i)
+		}
+		if !second.Has(fmt.Sprintf("%s/%d", second.dir, i)) {
+			t.Fatalf("DiskSeenShared: test case 2 failed, %d not seen", i)
+		}
+	}
+}
+
+func TestDiskSeenOwnBloom(t *testing.T) {
+	// without a shared filter the set builds its own rather than panicking on the first add
+	seen, err := NewDiskSeen(t.TempDir(), 10, nil)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	defer seen.Close()
+
+	for i := 0; i < 50; i++ {
+		if seen.Add(fmt.Sprintf("https://example.com/%d", i)) {
+			t.Errorf("DiskSeenOwnBloom: test case 1 failed, %d seen before it was added", i)
+		}
+	}
+	if !seen.Has("https://example.com/0") || seen.Has("https://example.com/50") {
+		t.Errorf("DiskSeenOwnBloom: test case 2 failed, unexpected membership")
+	}
+}
+
+func TestDiskFrontier(t *testing.T) {
+	fifo, err := NewDiskFIFO(filepath.Join(t.TempDir(), "fifo"), 10)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	lifo, err := NewDiskLIFO(filepath.Join(t.TempDir(), "lifo"), 10)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		disk     Frontier
+		memory   Frontier
+		segments func() int
+	}{
+		{
+			name:     "DiskFrontier: test case 1",
+			disk:     fifo,
+			memory:   NewFIFO(),
+			segments: func() int { return len(fifo.log.segments) },
+		},
+		{
+			name:     "DiskFrontier: test case 2",
+			disk:     lifo,
+			memory:   NewLIFO(),
+			segments: func() int { return len(lifo.log.segments) },
+		},
+	}
+
+	for _, testCase := range testCases {
+		// pushes and pops interleave, so segments are read back while others are still being written
+		next := 0
+		for round := 0; round < 5; round++ {
+			for i := 0; i < 40; i++ {
+				item := Item{URL: fmt.Sprint(next), Depth: round}
+				testCase.disk.Push(item)
+				testCase.memory.Push(item)
+				next++
+			}
+
+			if testCase.segments() == 0 {
+				t.Errorf("%s failed, nothing spilled to disk", testCase.name)
+			}
+
+			for i := 0; i < 25; i++ {
+				result, err := testCase.disk.Pop()
+				expected, _ := testCase.memory.Pop()
+				if err != nil || result != expected {
+					t.Fatalf("%s failed, %v != %v: %v", testCase.name, result, expected, err)
+				}
+			}
+		}
+
+		if testCase.disk.Len() != testCase.memory.Len() {
+			t.Errorf("%s failed, %d != %d", testCase.name, testCase.disk.Len(), testCase.memory.Len())
+		}
+
+		for testCase.memory.Len() > 0 {
+			result, err := testCase.disk.Pop()
+			expected, _ := testCase.memory.Pop()
+			if err != nil || result != expected {
+				t.Fatalf("%s failed, %v != %v: %v", testCase.name, result, expected, err)
+			}
+		}
+
+		if _, err := testCase.disk.Pop(); !errors.Is(err, ErrFrontierEmpty) {
+			t.Errorf("%s failed, expected error: %v", testCase.name, ErrFrontierEmpty)
+		}
+	}
+
+	if err := fifo.Close(); err != nil {
+		t.Errorf("DiskFrontier: test case 3 failed, unexpected error: %v", err)
+	}
+	if _, err := os.Stat(fifo.log.dir); !os.IsNotExist(err) {
+		t.Errorf("DiskFrontier: test case 4 failed, %s not removed", fifo.log.dir)
+	}
+}
+
+func TestCassette(t *testing.T) {
+	page := strings.Repeat("<p>recorded content</p>", 100)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(page))
+			gz.Close()
+		case "/moved":
+			http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, nil)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	transport := Client.Transport
+	defer func() {
+		Client.Transport = transport
+	}()
+
+	// robots.txt, a redirected page, a 404 and a refused connection
+	fetch := func() ([]byte, Page, []error) {
+		robots, _ := GetRobots(server.URL)
+		page, _ := GetPage(server.URL + "/moved")
+		_, missing := GetPage(server.URL + "/missing")
+		_, refused := GetPage("http://127.0.0.1:1/")
+		return robots, page, []error{missing, refused}
+	}
+
+	Client.Transport = recorder
+	robots, recorded, errs := fetch()
+	server.Close()
+
+	// the redirect, its target, robots.txt, the 404 and the refused connection
+	if entries, _ := os.ReadDir(dir); len(entries) != 5 {
+		t.Errorf("Cassette: test case 1 failed, %d != %d interactions recorded", len(entries), 5)
+	}
+
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	Client.Transport = replayer
+	replayedRobots, replayed, replayedErrs := fetch()
+
+	if !bytes.Equal(replayedRobots, robots) || len(robots) == 0 {
+		t.Errorf("Cassette: test case 2 failed, %q != %q", replayedRobots, robots)
+	}
+
+	if string(replayed.Body) != page || replayed.Encoding != "gzip" || replayed.WireBytes != recorded.WireBytes || replayed.Status != recorded.Status {
+		t.Errorf("Cassette: test case 3 failed, %+v != %+v", replayed, recorded)
+	}
+
+	statusErr := &StatusError{}
+	if !errors.As(replayedErrs[0], &statusErr) || statusErr.Code != 404 || errs[0] == nil {
+		t.Errorf("Cassette: test case 4 failed, %v != %v", replayedErrs[0], errs[0])
+	}
+
+	if replayedErrs[1] == nil || errs[1] == nil || replayedErrs[1].Error() != errs[1].Error() {
+		t.Errorf("Cassette: test case 5 failed, %v != %v", replayedErrs[1], errs[1])
+	}
+
+	if _, err := GetPage(server.URL + "/unrecorded"); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("Cassette: test case 6 failed, %v != %v", err, ErrNotRecorded)
+	}
+}
+
+func TestCassetteLimit(t *testing.T) {
+	limit := MaxBodySize
+	MaxBodySize = 100
+	defer func() {
+		MaxBodySize = limit
+	}()
+
+	page := strings.Repeat("<p>a page too large to keep</p>", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, nil)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	transport := Client.Transport
+	defer func() {
+		Client.Transport = transport
+	}()
+
+	// the crawler sees the whole body as it would without the recorder, the cassette only what it reads
+	Client.Transport = recorder
+	if _, err := GetPage(server.URL); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("CassetteLimit: test case 1 failed, %v != %v", err, ErrBodyTooLarge)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	encoded, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	interaction := Interaction{}
+	if err := json.Unmarshal(encoded, &interaction); err != nil || len(interaction.Body) != 101 {
+		t.Errorf("CassetteLimit: test case 2 failed, %d != %d bytes recorded (%v)", len(interaction.Body), 101, err)
+	}
+
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+	Client.Transport = replayer
+	if _, err := GetPage(server.URL); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("CassetteLimit: test case 3 failed, %v != %v", err, ErrBodyTooLarge)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	throttle := NewThrottle(100 * time.Millisecond)
+
+	// requests made at once still start a delay apart
+	started := time.Now()
+	turns := make([]time.Duration, 4)
+	wg := sync.WaitGroup{}
+	for i := range turns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := throttle.Wait(t.Context()); err != nil {
+				t.Errorf("Throttle: test case 1 failed, unexpected error: %v", err)
+			}
+			turns[i] = time.Since(started)
+		}()
+	}
+	wg.Wait()
+	slices.Sort(turns)
+
+	if turns[0] > 50*time.Millisecond {
+		t.Errorf("Throttle: test case 2 failed, first request waited %v", turns[0])
+	}
+	for i := 1; i < len(turns); i++ {
+		if gap := turns[i] - turns[i-1]; gap < 95*time.Millisecond {
+			t.Errorf("Throttle: test case 3 failed, requests %d and %d only %v apart", i-1, i, gap)
+		}
+	}
+
+	// a slow request counts towards the delay, so the next one doesn't wait again
+	time.Sleep(110 * time.Millisecond)
+	before := time.Now()
+	if err := throttle.Wait(t.Context()); err != nil || time.Since(before) > 50*time.Millisecond {
+		t.Errorf("Throttle: test case 4 failed, waited %v (%v)", time.Since(before), err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if err := throttle.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Throttle: test case 5 failed, %v != %v", err, context.Canceled)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+
+	for _, data := range []string{"first", "second"} {
+		if err := WriteFileAtomic(path, []byte(data)); err != nil {
+			t.Fatalf("WriteFileAtomic: test case 1 failed, unexpected error: %v", err)
+		}
+	}
+
+	if data, err := os.ReadFile(path); err != nil || string(data) != "second" {
+		t.Errorf("WriteFileAtomic: test case 2 failed, %q != %q (%v)", data, "second", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("WriteFileAtomic: test case 3 failed, %d files left behind", len(entries))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("WriteFileAtomic: test case 4 failed, unexpected error: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("WriteFileAtomic: test case 4 failed, %v != %v", info.Mode().Perm(), os.FileMode(0o600))
+	}
+
+	// nothing is left behind when the file can't be put in place
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "file"), []byte("data")); err == nil {
+		t.Errorf("WriteFileAtomic: test case 5 failed, expected error for a missing directory")
+	}
+}
+
+func TestChromeRenderer(t *testing.T) {
+	renderer, err := NewChromeRenderer(os.Getenv("CHROME_PATH"), 1, 10*time.Second)
+	if err != nil {
+		t.Skipf("chrome isn't available: %v", err)
+	}
+	defer renderer.Close()
+
+	// the page is empty until a script fetches its text, a while after it's loaded
+	inFlight, peak, documents := atomic.Int32{}, atomic.Int32{}, atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/data.json":
+			current := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				highest := peak.Load()
+				if current <= highest || peak.CompareAndSwap(highest, current) {
+					break
+				}
+			}
+
+			time.Sleep(200 * time.Millisecond)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"text": "rendered by a script on %s"}`, r.URL.Query().Get("page"))
+		case "/favicon.ico":
+			http.NotFound(w, r)
+		default:
+			documents.Add(1)
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprintf(w, `<html><head><title>App</title></head><body><div id="app"></div><script>
+setTimeout(function() {
+	fetch("/data.json?page=%s").then(function(res) { return res.json(); }).then(function(data) {
+		var p = document.createElement("p");
+		p.textContent = data.text;
+		document.getElementById("app").appendChild(p);
+	});
+}, 100);
+</script></body></html>`, strings.TrimPrefix(r.URL.Path, "/"))
+		}
+	}))
+	defer server.Close()
+
+	// the gate lets everything through, but sees every request for data the pages make
+	mu := sync.Mutex{}
+	requested := []string{}
+	gate := func(ctx context.Context, rawURL string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if strings.Contains(rawURL, "/data.json") {
+			requested = append(requested, rawURL)
+		}
+		return nil
+	}
+
+	pages := []string{"a", "b", "c"}
+	fetched := make([]Page, len(pages))
+	for i, page := range pages {
+		fetched[i], err = GetPage(server.URL + "/" + page)
+		if err != nil {
+			t.Fatalf("error setting up test, unexpected error: %v", err)
+		}
+	}
+
+	results := make([]string, len(pages))
+	errs := make([]error, len(pages))
+	wg := sync.WaitGroup{}
+	for i := range pages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			body, err := renderer.Render(t.Context(), fetched[i], gate)
+			results[i], errs[i] = string(body), err
+		}()
+	}
+	wg.Wait()
+
+	for i, page := range pages {
+		expected := fmt.Sprintf("<p>rendered by a script on %s</p>", page)
+		if errs[i] != nil || !strings.Contains(results[i], expected) {
+			t.Errorf("ChromeRenderer: test case %d failed, %s not in %q (%v)", i+1, expected, results[i], errs[i])
+		}
+	}
+
+	// renders are capped at one at a time
+	if peak.Load() != 1 {
+		t.Errorf("ChromeRenderer: test case 4 failed, %d != %d renders at once", peak.Load(), 1)
+	}
+
+	// the browser is handed the pages as fetched, only their scripts' requests go out
+	if documents.Load() != int32(len(pages)) {
+		t.Errorf("ChromeRenderer: test case 5 failed, %d != %d documents requested", documents.Load(), len(pages))
+	}
+	mu.Lock()
+	if len(requested) != len(pages) {
+		t.Errorf("ChromeRenderer: test case 6 failed, %v went through the gate", requested)
+	}
+	mu.Unlock()
+
+	// a request the gate blocks never reaches the server, so the page stays empty
+	blocked, err := renderer.Render(t.Context(), fetched[0], func(ctx context.Context, rawURL string) error {
+		return errors.New("disallowed")
+	})
+	if err != nil || strings.Contains(string(blocked), "rendered by a script") {
+		t.Errorf("ChromeRenderer: test case 7 failed, unexpected render %q (%v)", blocked, err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	page := []byte(`<html lang="en"><head><title>Site name | Article</title>
+<meta name="author" content="Jane Doe"></head><body>
+<nav><a href="/">Home</a><a href="/about">About</a></nav>
+<article>
+	<h1 class="headline">The real headline</h1>
+	<time datetime="2025-03-01T12:00:00Z">1 March</time>
+	<div class="article-body">
+		<div class="para">First paragraph, written without p tags.</div>
+		<div class="para">Second paragraph with a <a href="/articles/2">related article</a>.</div>
+		<div class="share"><a href="https://social.example.com/share">Share this</a></div>
+	</div>
+	<section class="comments"><p>A comment that isn't content.</p><a href="/users/1">commenter</a></section>
+</article>
+</body></html>`)
+
+	profiles, err := CompileProfiles([]Profile{
+		{
+			Match:   `^https://news\.example\.com/articles/`,
+			Title:   "h1.headline",
+			Body:    ".article-body .para",
+			Date:    "article time",
+			Author:  `meta[name="author"]`,
+			Exclude: ".share, .comments",
+			Links:   ".article-body",
+		},
+	})
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	domain, err := url.Parse("https://news.example.com")
+	if err != nil {
+		t.Fatalf("error setting up test, unexpected error: %v", err)
+	}
+
+	result, err := profiles.Parse(domain, Page{URL: "https://news.example.com/articles/1", Body: page, MediaType: "text/html"})
+	if err != nil {
+		t.Fatalf("Profiles: test case 1 failed, unexpected error: %v", err)
+	}
+
+	if result.Title != "The real headline" || result.Date != "2025-03-01T12:00:00Z" || result.Author != "Jane Doe" || result.Language != "en" {
+		t.Errorf("Profiles: test case 2 failed, unexpected response %+v", result)
+	}
+
+	expected := []string{"First paragraph, written without p tags.", "Second paragraph with a related article."}
+	if !reflect.DeepEqual(result.Content, expected) {
+		t.Errorf("Profiles: test case 3 failed, %q != %q", result.Content, expected)
+	}
+
+	// only links in the article body are followed, the rest are kept unless they were excluded
+	links, followed := []string{}, []string{}
+	for _, link := range result.Links {
+		links = append(links, link.URL)
+		if !link.Unfollowed {
+			followed = append(followed, link.URL)
+		}
+	}
+	expectedLinks := []string{"https://news.example.com/", "https://news.example.com/about", "https://news.example.com/articles/2"}
+	if !reflect.DeepEqual(links, expectedLinks) {
+		t.Errorf("Profiles: test case 4 failed, %v != %v", links, expectedLinks)
+	}
+	if !reflect.DeepEqual(followed, []string{"https://news.example.com/articles/2"}) {
+		t.Errorf("Profiles: test case 5 failed, %v != %v", followed, []string{"https://news.example.com/articles/2"})
+	}
+
+	// pages the profile doesn't match are extracted as usual
+	result, err = profiles.Parse(domain, Page{URL: "https://news.example.com/about", Body: page, MediaType: "text/html"})
+	if err != nil || result.Title != "Site name | Article" || !reflect.DeepEqual(result.Content, []string{"A comment that isn't content."}) || len(result.Links) != 5 {
+		t.Errorf("Profiles: test case 6 failed, unexpected response %+v (%v)", result, err)
+	}
+
+	invalid := [][]Profile{
+		{{Match: `(`}},
+		{{Match: `.*`, Body: "div[["}},
+	}
+	for i, profiles := range invalid {
+		if _, err := CompileProfiles(profiles); err == nil {
+			t.Errorf("Profiles: test case %d failed, expected error for %+v", i+7, profiles[0])
+		}
+	}
+}